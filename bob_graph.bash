@@ -22,6 +22,9 @@ set -e
 #
 # To view users of libOther
 # ./bob_graph --graph-start-nodes=libOther --graph-rev-deps
+#
+# To write the graph as JSON, GraphML or Mermaid rather than Graphviz dot
+# ./bob_graph --graph-start-nodes=libMy --graph-format=json
 
 # Switch to the build directory
 cd "$(dirname "${BASH_SOURCE[0]}")"
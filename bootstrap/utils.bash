@@ -54,4 +54,5 @@ function create_bob_symlinks() {
 
     ln -sf "${BOB_DIR}/bob.bash" "${BUILDDIR}/bob"
     ln -sf "${BOB_DIR}/bob_graph.bash" "${BUILDDIR}/bob_graph"
+    ln -sf "${BOB_DIR}/bob_query.bash" "${BUILDDIR}/bob_query"
 }
@@ -25,6 +25,7 @@ import (
 	"sync"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"github.com/ARM-software/bob-build/internal/ccflags"
 	"github.com/ARM-software/bob-build/internal/escape"
@@ -235,12 +236,31 @@ func androidLibraryBuildAction(sb *strings.Builder, mod blueprint.Module, ctx bl
 	}
 	srcs := utils.NewStringSlice(m.Properties.getSources(ctx), m.Properties.Build.SourceProps.Specials)
 
-	// Remove sources which are not compiled
+	// Remove sources which are not compiled. The Android build system
+	// only compiles sources with its own rules.
+	for _, src := range srcs {
+		if rule, ok := getCompilerRule(src); ok && getSourceLanguage(src) == langNone {
+			ctx.PropertyErrorf("srcs", "%s needs bob_compiler_rule %s, which is not supported on Android",
+				src, rule.Name())
+		}
+	}
 	nonCompiledDeps := utils.Filter(utils.IsNotCompilableSource, srcs)
 	srcs = utils.Filter(utils.IsCompilableSource, srcs)
 
 	writeListAssignment(sb, "LOCAL_SRC_FILES", srcs)
 
+	// Android.mk only compiles C++ sources with one extension, which
+	// defaults to .cpp
+	cxxExts := []string{}
+	for _, src := range srcs {
+		if getSourceLanguage(src) == langCxx {
+			cxxExts = utils.AppendIfUnique(cxxExts, filepath.Ext(src))
+		}
+	}
+	if len(cxxExts) == 1 && cxxExts[0] != ".cpp" {
+		sb.WriteString("LOCAL_CPP_EXTENSION:=" + cxxExts[0] + "\n")
+	}
+
 	versionScript := m.getVersionScript(ctx)
 	if bt == binTypeShared || bt == binTypeExecutable {
 		if versionScript != nil {
@@ -269,6 +289,11 @@ func androidLibraryBuildAction(sb *strings.Builder, mod blueprint.Module, ctx bl
 	// Setup ARM mode if needed
 	sb.WriteString(specifyArmMode(cflagsList, m.Properties.Conlyflags, m.Properties.Cxxflags))
 
+	if proptools.Bool(m.Properties.Sanitize.Memory) {
+		ctx.PropertyErrorf("sanitize.memory", "memory sanitizer is not supported on Android")
+	}
+	writeListAssignment(sb, "LOCAL_SANITIZE", m.Properties.getSanitizers())
+
 	// convert Shared_libs, Resolved_static_libs, and Whole_static_libs
 	// to Android module names rather than Bob module names
 	sharedLibs := androidModuleNames(m.Properties.Shared_libs)
@@ -457,6 +482,74 @@ func androidLibraryBuildAction(sb *strings.Builder, mod blueprint.Module, ctx bl
 	}
 	sb.WriteString("\ninclude $(" + rulePrefix[tgt] + ruleSuffix[bt] + ")\n")
 
+	writeSrcsFlags(sb, m, ctx, srcs)
+
+	androidMkWriteString(ctx, m.altShortName(), sb)
+}
+
+// Android.mk has no per-source flags, so srcs_flags are added to the
+// PRIVATE_* variables used to compile each object. A pattern is used so
+// that both architectures of multilib modules are covered.
+func writeSrcsFlags(sb *strings.Builder, m *library, ctx blueprint.ModuleContext, srcs []string) {
+	srcsFlags := m.Properties.getSrcsFlags(ctx)
+	vars := []struct {
+		name  string
+		flags func(*SrcsFlagsGroup) []string
+	}{
+		{"PRIVATE_ASFLAGS", func(g *SrcsFlagsGroup) []string { return g.Asflags }},
+		{"PRIVATE_CFLAGS", func(g *SrcsFlagsGroup) []string { return g.Cflags }},
+		{"PRIVATE_CONLYFLAGS", func(g *SrcsFlagsGroup) []string { return g.Conlyflags }},
+		{"PRIVATE_CPPFLAGS", func(g *SrcsFlagsGroup) []string { return g.Cxxflags }},
+	}
+
+	for _, src := range srcs {
+		group, ok := srcsFlags[src]
+		if !ok {
+			continue
+		}
+		obj := "%/" + m.altName() + "_intermediates/" + strings.TrimSuffix(src, filepath.Ext(src)) + ".o"
+		for _, v := range vars {
+			flags := utils.Filter(ccflags.AndroidCompileFlags, v.flags(group))
+			if len(flags) > 0 {
+				sb.WriteString(obj + ": " + v.name + " += " + strings.Join(flags, " ") + "\n")
+			}
+		}
+	}
+}
+
+func (g *androidMkGenerator) headerActions(m *headerLibrary, ctx blueprint.ModuleContext) {
+	if !enabledAndRequired(m) {
+		return
+	}
+
+	sb := &strings.Builder{}
+
+	sb.WriteString("##########################\ninclude $(CLEAR_VARS)\n\n")
+	sb.WriteString("LOCAL_MODULE:=" + m.altName() + "\n\n")
+
+	exportIncludeDirs := utils.NewStringSlice(m.Properties.Export_include_dirs,
+		utils.PrefixDirs(m.Properties.Export_local_include_dirs, "$(LOCAL_PATH)"))
+
+	exportHeaderLibs := androidModuleNames(m.Properties.Export_header_libs)
+	headerLibs := append(androidModuleNames(m.Properties.Header_libs), exportHeaderLibs...)
+
+	reexportHeaders := exportHeaderLibs
+	for _, lib := range androidModuleNames(m.Properties.Reexport_libs) {
+		if utils.Contains(headerLibs, lib) && !utils.Contains(reexportHeaders, lib) {
+			reexportHeaders = append(reexportHeaders, lib)
+		}
+	}
+
+	writeListAssignment(sb, "LOCAL_HEADER_LIBRARIES", headerLibs)
+	writeListAssignment(sb, "LOCAL_EXPORT_HEADER_LIBRARY_HEADERS", reexportHeaders)
+	writeListAssignment(sb, "LOCAL_EXPORT_C_INCLUDE_DIRS", exportIncludeDirs)
+	if m.Properties.isProprietary() {
+		sb.WriteString("LOCAL_MODULE_OWNER := " + m.Properties.Owner + "\n")
+		sb.WriteString("LOCAL_PROPRIETARY_MODULE := true\n")
+	}
+
+	sb.WriteString("\ninclude $(" + rulePrefix[m.Properties.TargetType] + "HEADER_LIBRARY)\n")
+
 	androidMkWriteString(ctx, m.altShortName(), sb)
 }
 
@@ -484,6 +577,16 @@ func (g *androidMkGenerator) binaryActions(m *binary, ctx blueprint.ModuleContex
 	}
 }
 
+func (g *androidMkGenerator) testActions(m *test, ctx blueprint.ModuleContext) {
+	// Running tests is left to the Android test infrastructure
+	g.binaryActions(&m.binary, ctx)
+}
+
+func (g *androidMkGenerator) externalLibActions(m *externalLib, ctx blueprint.ModuleContext) {
+	// External libraries are defined by Android makefiles, so there
+	// is nothing to do
+}
+
 func (*androidMkGenerator) declareAlias(sb *strings.Builder, name string, srcs []string) {
 	sb.WriteString("\ninclude $(CLEAR_VARS)\n\n")
 	sb.WriteString("LOCAL_MODULE := " + name + "\n")
@@ -23,6 +23,7 @@ import (
 	"fmt"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"github.com/ARM-software/bob-build/internal/bpwriter"
 	"github.com/ARM-software/bob-build/internal/ccflags"
@@ -135,6 +136,109 @@ func addPGOProps(m bpwriter.Module, props AndroidPGOProps) {
 	g.AddStringList("cflags", props.Pgo.Cflags)
 }
 
+// The UndefinedBehaviorSanitizer checks, and groups of checks, which
+// can be listed in Soong's misc_undefined
+var ubsanChecks = map[string]bool{
+	"alignment":                            true,
+	"bool":                                 true,
+	"bounds":                               true,
+	"builtin":                              true,
+	"enum":                                 true,
+	"float-cast-overflow":                  true,
+	"float-divide-by-zero":                 true,
+	"function":                             true,
+	"implicit-conversion":                  true,
+	"implicit-integer-sign-change":         true,
+	"implicit-integer-truncation":          true,
+	"implicit-signed-integer-truncation":   true,
+	"implicit-unsigned-integer-truncation": true,
+	"integer":                              true,
+	"integer-divide-by-zero":               true,
+	"nonnull-attribute":                    true,
+	"null":                                 true,
+	"nullability":                          true,
+	"nullability-arg":                      true,
+	"nullability-assign":                   true,
+	"nullability-return":                   true,
+	"object-size":                          true,
+	"pointer-overflow":                     true,
+	"return":                               true,
+	"returns-nonnull-attribute":            true,
+	"shift":                                true,
+	"shift-base":                           true,
+	"shift-exponent":                       true,
+	"signed-integer-overflow":              true,
+	"unreachable":                          true,
+	"unsigned-integer-overflow":            true,
+	"unsigned-shift-base":                  true,
+	"vla-bound":                            true,
+	"vptr":                                 true,
+}
+
+// Split the misc sanitizers into the UndefinedBehaviorSanitizer checks
+// Soong accepts in misc_undefined, and the ones it doesn't support.
+// `undefined` itself enables Soong's undefined property.
+func splitMiscSanitizers(misc []string) (undefined bool, checks, unsupported []string) {
+	for _, name := range misc {
+		if name == "undefined" {
+			undefined = true
+		} else if ubsanChecks[name] {
+			checks = append(checks, name)
+		} else {
+			unsupported = append(unsupported, name)
+		}
+	}
+	return
+}
+
+// Soong only supports some of the sanitizers, and names the others
+// differently, so map the properties to its sanitize: {} block.
+func addSanitizeProps(m bpwriter.Module, props SanitizeProps, mctx blueprint.ModuleContext) {
+	s := &props.Sanitize
+	if proptools.Bool(s.Memory) {
+		mctx.PropertyErrorf("sanitize.memory", "memory sanitizer is not supported on Android")
+	}
+
+	undefined, checks, unsupported := splitMiscSanitizers(s.Misc)
+	for _, name := range unsupported {
+		mctx.PropertyErrorf("sanitize.misc", "%s is not supported on Android.bp, "+
+			"which only supports UndefinedBehaviorSanitizer checks here", name)
+	}
+	if undefined {
+		s.Undefined = proptools.BoolPtr(true)
+	}
+
+	if len(props.getSanitizers()) == 0 {
+		return
+	}
+
+	g := m.NewGroup("sanitize")
+	g.AddOptionalBool("address", s.Address)
+	g.AddOptionalBool("thread", s.Thread)
+	g.AddOptionalBool("undefined", s.Undefined)
+	// Soong's misc_undefined holds the names of individual
+	// UndefinedBehaviorSanitizer checks
+	g.AddStringList("misc_undefined", checks)
+}
+
+// Soong propagates the LTO mode to static libraries itself, so only the
+// mode set on this module is passed on.
+func addLtoProps(m bpwriter.Module, props LtoProps) {
+	if props.Lto == nil {
+		return
+	}
+
+	g := m.NewGroup("lto")
+	switch *props.Lto {
+	case ltoModeFull:
+		g.AddBool("full", true)
+	case ltoModeThin:
+		g.AddBool("thin", true)
+	case ltoModeNone:
+		g.AddBool("never", true)
+	}
+}
+
 func addRequiredModules(m bpwriter.Module, l library, mctx blueprint.ModuleContext) {
 	if _, _, ok := getSoongInstallPath(l.getInstallableProps()); ok {
 		requiredModuleNames := l.getInstallDepPhonyNames(mctx)
@@ -210,11 +314,25 @@ func addCcLibraryProps(m bpwriter.Module, l library, mctx blueprint.ModuleContex
 		m.AddString("stem", l.outputName())
 	}
 	m.AddStringList("srcs", utils.Filter(utils.IsCompilableSource, l.Properties.getSources(mctx)))
+	// Soong only compiles sources with its own rules
+	for _, src := range l.Properties.getSources(mctx) {
+		if rule, ok := getCompilerRule(src); ok && getSourceLanguage(src) == langNone {
+			mctx.PropertyErrorf("srcs", "%s needs bob_compiler_rule %s, which is not supported on Android.bp",
+				src, rule.Name())
+		}
+	}
 	m.AddStringList("generated_sources", l.getGeneratedSourceModules(mctx))
 	genHeaderModules, exportGenHeaderModules := l.getGeneratedHeaderModules(mctx)
 	m.AddStringList("generated_headers", append(genHeaderModules, exportGenHeaderModules...))
 	m.AddStringList("export_generated_headers", exportGenHeaderModules)
 	m.AddStringList("exclude_srcs", l.Properties.Exclude_srcs)
+	// Soong has no per-source flags, and adding them to the whole
+	// module could change how the other sources are compiled
+	for i, group := range l.Properties.SrcsFlagsProps.groups() {
+		if len(group.Srcs) > 0 {
+			mctx.PropertyErrorf(srcsFlagsBlocks.propertyName(i+1), "not supported on Android.bp")
+		}
+	}
 	err := addCFlags(m, cflags, l.Properties.Conlyflags, l.Properties.Cxxflags)
 	if err != nil {
 		panic(fmt.Errorf("Module %s: %s", mctx.ModuleName(), err.Error()))
@@ -237,6 +355,8 @@ func addCcLibraryProps(m bpwriter.Module, l library, mctx blueprint.ModuleContex
 
 	addProvenanceProps(m, l.Properties.Build.AndroidProps)
 	addPGOProps(m, l.Properties.Build.AndroidPGOProps)
+	addSanitizeProps(m, l.Properties.Build.SanitizeProps, mctx)
+	addLtoProps(m, l.Properties.Build.LtoProps)
 	addRequiredModules(m, l, mctx)
 
 	if l.Properties.Post_install_cmd != nil ||
@@ -347,6 +467,16 @@ func (g *androidBpGenerator) binaryActions(l *binary, mctx blueprint.ModuleConte
 	}
 }
 
+func (g *androidBpGenerator) testActions(l *test, mctx blueprint.ModuleContext) {
+	// Running tests is left to the Android test infrastructure
+	g.binaryActions(&l.binary, mctx)
+}
+
+func (g *androidBpGenerator) externalLibActions(l *externalLib, mctx blueprint.ModuleContext) {
+	// External libraries are defined outside of Bob, so Soong already
+	// knows about them
+}
+
 func (g *androidBpGenerator) sharedActions(l *sharedLibrary, mctx blueprint.ModuleContext) {
 	if !enabledAndRequired(l) {
 		return
@@ -410,3 +540,44 @@ func (g *androidBpGenerator) staticActions(l *staticLibrary, mctx blueprint.Modu
 	addCcLibraryProps(m, l.library, mctx)
 	addStaticOrSharedLibraryProps(m, l.library, mctx)
 }
+
+func (g *androidBpGenerator) headerActions(l *headerLibrary, mctx blueprint.ModuleContext) {
+	if !enabledAndRequired(l) {
+		return
+	}
+
+	if len(l.Properties.Export_include_dirs) > 0 {
+		panic(fmt.Errorf("Module %s exports non-local include dirs %v - this is not supported",
+			mctx.ModuleName(), l.Properties.Export_include_dirs))
+	}
+
+	m, err := AndroidBpFile().NewModule("cc_library_headers", l.shortName())
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// Header libraries are device-only by default
+	if l.Properties.TargetType == tgtTypeHost {
+		m.AddBool("host_supported", true)
+		m.AddBool("device_supported", false)
+	}
+
+	// Exported header libraries must be mentioned in both header_libs
+	// *and* export_header_lib_headers
+	headerLibs := ccModuleNames(mctx, l.Properties.Header_libs, l.Properties.Export_header_libs)
+	reexportHeaders := ccModuleNames(mctx, l.Properties.Export_header_libs)
+	for _, lib := range ccModuleNames(mctx, l.Properties.Reexport_libs) {
+		if utils.Contains(headerLibs, lib) && !utils.Contains(reexportHeaders, lib) {
+			reexportHeaders = append(reexportHeaders, lib)
+		}
+	}
+
+	m.AddStringList("export_include_dirs", l.Properties.Export_local_include_dirs)
+	genHeaderModules, exportGenHeaderModules := l.getGeneratedHeaderModules(mctx)
+	m.AddStringList("generated_headers", append(genHeaderModules, exportGenHeaderModules...))
+	m.AddStringList("export_generated_headers", exportGenHeaderModules)
+	m.AddStringList("header_libs", headerLibs)
+	m.AddStringList("export_header_lib_headers", reexportHeaders)
+
+	addProvenanceProps(m, l.Properties.Build.AndroidProps)
+}
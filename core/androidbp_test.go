@@ -105,3 +105,12 @@ func Test_addCFlags4(t *testing.T) {
 
 	assert.Equal(t, err.Error(), "Both thumb and no thumb (arm) options are specified")
 }
+
+func Test_splitMiscSanitizers(t *testing.T) {
+	undefined, checks, unsupported := splitMiscSanitizers(
+		[]string{"integer", "leak", "undefined", "bounds", "cfi"})
+
+	assert.True(t, undefined)
+	assert.Equal(t, []string{"integer", "bounds"}, checks)
+	assert.Equal(t, []string{"leak", "cfi"}, unsupported)
+}
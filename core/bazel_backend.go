@@ -0,0 +1,646 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/fileutils"
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// The Bazel workspace is the Bob source directory, and outputs are
+// referred to by the path Bazel gives them, so that they can be used
+// in genrule commands and compiler options.
+const bazelGenDir = "$(GENDIR)"
+
+// The load statement needed for pkg_files
+const bazelPkgFilesLoad = `load("@rules_pkg//pkg:mappings.bzl", "pkg_files")`
+
+var (
+	// Bazel rules for each module, indexed by package and then
+	// target name. Modules generate their build actions in parallel,
+	// so access is protected by bazelFragmentsLock.
+	bazelFragmentsLock sync.Mutex
+	bazelFragments     = map[string]map[string]string{}
+	// The load statements needed by each package
+	bazelLoads = map[string]map[string]bool{}
+	// The directories containing a build.bp, each of which becomes a
+	// Bazel package
+	bazelPackages = map[string]bool{}
+)
+
+type bazelGenerator struct {
+	toolchainSet
+}
+
+/* Compile time checks for interfaces that must be implemented by bazelGenerator */
+var _ generatorBackend = (*bazelGenerator)(nil)
+
+// Quote a string for a BUILD file
+func bazelQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+func bazelQuoteAll(list []string) []string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = bazelQuote(s)
+	}
+	return quoted
+}
+
+// Format a list of values, which must already be quoted, with one
+// value per line when there is more than one.
+func bazelList(values []string) string {
+	switch len(values) {
+	case 0:
+		return "[]"
+	case 1:
+		return "[" + values[0] + "]"
+	}
+	return "[\n        " + strings.Join(values, ",\n        ") + ",\n    ]"
+}
+
+// An attribute of a Bazel rule, with its value already formatted
+type bazelAttr struct {
+	name  string
+	value string
+}
+
+// A Bazel rule, written to a BUILD file with write()
+type bazelRule struct {
+	kind  string
+	attrs []bazelAttr
+}
+
+func newBazelRule(kind, name string) *bazelRule {
+	r := &bazelRule{kind: kind}
+	r.addString("name", name)
+	return r
+}
+
+// Add an attribute, formatted as Starlark. Empty values are skipped.
+func (r *bazelRule) addExpr(name, value string) {
+	if value != "" {
+		r.attrs = append(r.attrs, bazelAttr{name, value})
+	}
+}
+
+func (r *bazelRule) addString(name, value string) {
+	if value != "" {
+		r.addExpr(name, bazelQuote(value))
+	}
+}
+
+func (r *bazelRule) addList(name string, values []string) {
+	if len(values) > 0 {
+		r.addExpr(name, bazelList(bazelQuoteAll(values)))
+	}
+}
+
+func (r *bazelRule) addBool(name string, value bool) {
+	if value {
+		r.addExpr(name, "True")
+	}
+}
+
+// Modules which are not built by default are tagged as manual, so
+// that they are only built when something needs them.
+func (r *bazelRule) addManualTag(m enableable) {
+	if !isBuiltByDefault(m) {
+		r.addList("tags", []string{"manual"})
+	}
+}
+
+func (r *bazelRule) write(sb *strings.Builder) {
+	sb.WriteString(r.kind + "(\n")
+	for _, attr := range r.attrs {
+		sb.WriteString("    " + attr.name + " = " + attr.value + ",\n")
+	}
+	sb.WriteString(")\n")
+}
+
+// Returns a glob() expression for the files matching patterns
+func bazelGlob(patterns []string) string {
+	if len(patterns) == 0 {
+		return ""
+	}
+	return "glob(" + bazelList(bazelQuoteAll(patterns)) + ", allow_empty = True)"
+}
+
+// Returns a list of labels, followed by a glob() of patterns
+func bazelListWithGlob(labels, patterns []string) string {
+	list := ""
+	if len(labels) > 0 {
+		list = bazelList(bazelQuoteAll(labels))
+	}
+	glob := bazelGlob(patterns)
+	if list != "" && glob != "" {
+		return list + " + " + glob
+	}
+	return list + glob
+}
+
+// Report a construct the Bazel backend cannot express. The warning is
+// printed with the location of the module, and added to the BUILD
+// file above the module's rules.
+func bazelWarnf(ctx blueprint.ModuleContext, sb *strings.Builder, property, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	propertyWarningf(ctx, property, "%s", msg)
+	sb.WriteString("# Warning: " + msg + "\n")
+}
+
+// Returns the package containing a path relative to the workspace,
+// which is the closest directory above it with a build.bp
+func bazelOwningPackage(path string) (string, bool) {
+	bazelFragmentsLock.Lock()
+	defer bazelFragmentsLock.Unlock()
+
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if bazelPackages[dir] {
+			return dir, true
+		}
+		if dir == "." || dir == "/" {
+			return "", false
+		}
+	}
+}
+
+// Returns a label for a target, relative to the package pkg
+func bazelLabel(pkg, targetPkg, name string) string {
+	if targetPkg == pkg {
+		return ":" + name
+	}
+	if targetPkg == "." {
+		targetPkg = ""
+	}
+	return "//" + targetPkg + ":" + name
+}
+
+// Returns a label for a source file or build output, relative to the
+// package pkg. Files outside the workspace do not have labels.
+func bazelPathLabel(pkg, path string) (string, bool) {
+	path = strings.TrimPrefix(path, bazelGenDir+"/")
+	path = filepath.Clean(path)
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, "../") {
+		return "", false
+	}
+
+	owner, ok := bazelOwningPackage(path)
+	if !ok {
+		return "", false
+	}
+	name := path
+	if owner != "." {
+		name = strings.TrimPrefix(path, owner+"/")
+	}
+	if owner == pkg {
+		// Files in the same package are referred to by name
+		return name, true
+	}
+	return bazelLabel(pkg, owner, name), true
+}
+
+// Returns the labels for a list of files, warning about any which do
+// not have one
+func bazelPathLabels(ctx blueprint.ModuleContext, sb *strings.Builder, property string, paths []string) []string {
+	labels := []string{}
+	for _, path := range paths {
+		if label, ok := bazelPathLabel(ctx.ModuleDir(), path); ok {
+			labels = utils.AppendIfUnique(labels, label)
+		} else {
+			bazelWarnf(ctx, sb, property, "%s is outside the Bazel workspace, so cannot be used", path)
+		}
+	}
+	return labels
+}
+
+// The name of the Bazel target created for a module
+func bazelTargetName(ctx blueprint.BaseModuleContext, m blueprint.Module) string {
+	if p, ok := m.(phonyInterface); ok {
+		return p.shortName()
+	}
+	return ctx.OtherModuleName(m)
+}
+
+// Returns the label of another module's target
+func bazelModuleLabel(ctx blueprint.ModuleContext, m blueprint.Module) string {
+	return bazelLabel(ctx.ModuleDir(), ctx.OtherModuleDir(m), bazelTargetName(ctx, m))
+}
+
+// Record the rules of a module, with any load statements they need
+func bazelAddFragment(pkg, name string, sb *strings.Builder, loads ...string) {
+	bazelFragmentsLock.Lock()
+	defer bazelFragmentsLock.Unlock()
+
+	if bazelFragments[pkg] == nil {
+		bazelFragments[pkg] = map[string]string{}
+		bazelLoads[pkg] = map[string]bool{}
+	}
+	bazelFragments[pkg][name] = sb.String()
+	for _, load := range loads {
+		bazelLoads[pkg][load] = true
+	}
+}
+
+// Record the packages, which are needed to find the labels of files
+// before any module generates its rules
+func bazelPackagesMutator(mctx blueprint.BottomUpMutatorContext) {
+	bazelFragmentsLock.Lock()
+	defer bazelFragmentsLock.Unlock()
+
+	bazelPackages[mctx.ModuleDir()] = true
+}
+
+func (g *bazelGenerator) buildDir() string {
+	return bazelGenDir
+}
+
+func (g *bazelGenerator) sourceDir() string {
+	// Commands are run from the root of the workspace
+	return "."
+}
+
+func (g *bazelGenerator) bobScriptsDir() string {
+	srcToScripts, _ := filepath.Rel(getSourceDir(), getBobScriptsDir())
+	return srcToScripts
+}
+
+func (g *bazelGenerator) sharedLibsDir(tgt tgtType) string {
+	// Bazel decides where shared libraries are found at runtime
+	return g.buildDir()
+}
+
+func (g *bazelGenerator) escapeFlag(s string) string {
+	// Bazel splits compiler options and genrule commands like the
+	// shell, after expanding `$(...)` variables, so flags are quoted
+	// for the shell and `$` is escaped.
+	return strings.Replace(proptools.ShellEscape(s), "$", "$$", -1)
+}
+
+// The path of an output of a module in the package pkg
+func bazelOutputPath(pkg string, elems ...string) string {
+	return filepath.Join(append([]string{bazelGenDir, pkg}, elems...)...)
+}
+
+// Write a pkg_files rule placing a module's files in its install
+// path, returning the load statements needed.
+func (g *bazelGenerator) install(sb *strings.Builder, m interface{}, ctx blueprint.ModuleContext, srcs []string) []string {
+	ins := m.(installable)
+	props := ins.getInstallableProps()
+
+	if props.Post_install_tool != nil || props.Post_install_cmd != nil {
+		bazelWarnf(ctx, sb, "post_install_cmd", "post install actions are not supported by the Bazel backend")
+	}
+
+	installPath, ok := props.getInstallPath()
+	if !ok || len(srcs) == 0 {
+		return nil
+	}
+
+	r := newBazelRule("pkg_files", bazelTargetName(ctx, ctx.Module())+"_install")
+	r.addList("srcs", srcs)
+	r.addString("prefix", installPath)
+	r.write(sb)
+
+	return []string{bazelPkgFilesLoad}
+}
+
+func (g *bazelGenerator) aliasActions(m *alias, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	srcs := []string{}
+
+	/* Only depend on enabled targets */
+	ctx.VisitDirectDepsIf(
+		func(p blueprint.Module) bool { return ctx.OtherModuleDependencyTag(p) == aliasTag },
+		func(p blueprint.Module) {
+			if e, ok := p.(enableable); ok {
+				if !isEnabled(e) {
+					return
+				}
+			}
+			if _, ok := p.(*kernelModule); ok {
+				// Kernel modules do not create a target
+				return
+			}
+			srcs = utils.AppendIfUnique(srcs, bazelModuleLabel(ctx, p))
+		})
+
+	r := newBazelRule("filegroup", m.Name())
+	r.addList("srcs", srcs)
+	r.write(sb)
+
+	bazelAddFragment(ctx.ModuleDir(), m.Name(), sb)
+}
+
+// Expand the ninja-style variable references in cmd using args, so
+// that the command can be used in a genrule. `$$` and unknown
+// variables are escaped, so that they are left to the shell.
+func bazelExpandCommand(cmd string, args map[string]string) string {
+	return ninjaVariableRegexp.ReplaceAllStringFunc(cmd, func(s string) string {
+		if s == "$$" {
+			return s
+		}
+		if value, ok := args[strings.Trim(s, "${}")]; ok {
+			return value
+		}
+		return "$" + s
+	})
+}
+
+// Write a genrule running the commands of a generated module. The
+// outputs of the module are recorded, and returned as labels.
+func (g *bazelGenerator) generateCommonActions(sb *strings.Builder, m *generateCommon, ctx blueprint.ModuleContext, inouts []inout) []string {
+	pkg := ctx.ModuleDir()
+
+	// Each module's outputs are in their own directory, so that
+	// modules in the same package can output files with the same name
+	m.outputdir = bazelOutputPath(pkg, m.Name())
+	prefixInoutsWithOutputDir(inouts, m.outputDir())
+	// Calculate and record outputs and include dirs
+	m.recordOutputsFromInout(inouts)
+	m.includeDirs = utils.PrefixDirs(m.Properties.Export_gen_include_dirs, m.outputDir())
+	m.encapsulatedOuts = getGeneratedEncapsulatedFiles(ctx)
+
+	cmd, args, implicits, _ := m.getArgs(ctx)
+
+	if m.Properties.FlagArgsBuild.Build_wrapper != nil {
+		bazelWarnf(ctx, sb, "build_wrapper", "build_wrapper is not supported by the Bazel backend, so is not used")
+		args["build_wrapper"] = ""
+	}
+	if proptools.Bool(m.Properties.Depfile) {
+		bazelWarnf(ctx, sb, "depfile", "dependency files are not used by Bazel, so every input must be listed")
+	}
+
+	// Tools run on the machine doing the build, so are referred to by
+	// label rather than by their path in the target configuration.
+	tools := []string{}
+	if m.Properties.Tool != nil {
+		tools = append(tools, bazelPathLabels(ctx, sb, "tool", []string{args["tool"]})...)
+		implicits = utils.Remove(implicits, args["tool"])
+	}
+	if m.Properties.Host_bin != nil {
+		hostBin := m.getHostBinModule(ctx)
+		label := bazelModuleLabel(ctx, hostBin)
+		implicits = utils.Remove(implicits, args["host_bin"])
+		args["host_bin"] = "$(execpath " + label + ")"
+		tools = append(tools, label)
+	}
+
+	srcs := []string{}
+	outs := []string{}
+	commands := []string{}
+	for _, inout := range inouts {
+		args["in"] = strings.Join(inout.in, " ")
+		args["out"] = strings.Join(inout.out, " ")
+		args["depfile"] = inout.depfile
+		args["rspfile"] = inout.rspfile
+
+		if _, ok := args["headers_generated"]; ok {
+			headers := utils.Filter(utils.IsHeader, inout.out)
+			args["headers_generated"] = strings.Join(headers, " ")
+		}
+		if _, ok := args["srcs_generated"]; ok {
+			sources := utils.Filter(utils.IsNotHeader, inout.out)
+			args["srcs_generated"] = strings.Join(sources, " ")
+		}
+
+		command := bazelExpandCommand(cmd, args)
+		if m.Properties.Rsp_content != nil {
+			// Bazel has no equivalent of ninja's rspfile_content,
+			// so write the response file before running the command.
+			content := bazelExpandCommand(*m.Properties.Rsp_content, args)
+			command = "printf '%s' " + proptools.ShellEscape(content) +
+				" > " + inout.rspfile + " && " + command
+		}
+		commands = append(commands, command)
+
+		srcs = append(srcs, utils.NewStringSlice(inout.in, inout.implicitSrcs)...)
+		for _, out := range append(utils.NewStringSlice(inout.out), inout.implicitOuts...) {
+			outs = append(outs, strings.TrimPrefix(out, bazelOutputPath(pkg)+"/"))
+		}
+	}
+	srcs = append(srcs, implicits...)
+
+	r := newBazelRule("genrule", m.shortName())
+	r.addList("srcs", bazelPathLabels(ctx, sb, "srcs", srcs))
+	r.addList("outs", outs)
+	r.addList("tools", tools)
+	r.addString("cmd", strings.Join(commands, " && "))
+	if m.Properties.Console {
+		r.addBool("local", true)
+	}
+	r.write(sb)
+
+	labels := []string{}
+	for _, out := range outs {
+		labels = append(labels, bazelLabel(pkg, pkg, out))
+	}
+	return labels
+}
+
+// The interfaces shared by all the generated module types
+type bazelGeneratedModule interface {
+	phonyInterface
+	enableable
+	installable
+}
+
+func (g *bazelGenerator) generatedModuleActions(m bazelGeneratedModule, ctx blueprint.ModuleContext,
+	sb *strings.Builder, outputs []string) {
+
+	loads := g.install(sb, m, ctx, outputs)
+	bazelAddFragment(ctx.ModuleDir(), m.shortName(), sb, loads...)
+}
+
+func (g *bazelGenerator) generateSourceActions(m *generateSource, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *bazelGenerator) transformSourceActions(m *transformSource, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *bazelGenerator) genStaticActions(m *generateStaticLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *bazelGenerator) genSharedActions(m *generateSharedLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *bazelGenerator) genBinaryActions(m *generateBinary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *bazelGenerator) kernelModuleActions(m *kernelModule, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	bazelWarnf(ctx, sb, "", "bob_kernel_module is not supported by the Bazel backend, so is not built")
+	bazelAddFragment(ctx.ModuleDir(), m.shortName(), sb)
+}
+
+func (g *bazelGenerator) resourceActions(m *resource, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+
+	files := getBackendPathsInSourceDir(g, m.filesToInstall(ctx))
+	r := newBazelRule("filegroup", m.shortName())
+	r.addList("srcs", bazelPathLabels(ctx, sb, "srcs", files))
+	r.write(sb)
+
+	loads := g.install(sb, m, ctx, []string{":" + m.shortName()})
+	bazelAddFragment(ctx.ModuleDir(), m.shortName(), sb, loads...)
+}
+
+func (g *bazelGenerator) externalLibActions(m *externalLib, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	linkopts := utils.NewStringSlice(m.exportLdflags(), m.exportLdlibs())
+
+	// Prebuilt libraries outside the workspace cannot be imported,
+	// so are linked by path instead.
+	prebuilt := ""
+	if len(m.outputs()) > 0 {
+		if label, ok := bazelPathLabel(ctx.ModuleDir(), m.outputs()[0]); ok {
+			prebuilt = label
+		} else {
+			linkopts = append([]string{m.outputs()[0]}, linkopts...)
+		}
+	}
+
+	// The imported library needs a wrapper to add any linker flags
+	importName := name
+	if prebuilt != "" && len(linkopts) > 0 {
+		importName = name + "_prebuilt"
+	}
+
+	if prebuilt != "" {
+		r := newBazelRule("cc_import", importName)
+		if strings.HasSuffix(prebuilt, ".a") {
+			r.addString("static_library", prebuilt)
+		} else {
+			r.addString("shared_library", prebuilt)
+		}
+		r.write(sb)
+	}
+	if prebuilt == "" || len(linkopts) > 0 {
+		r := newBazelRule("cc_library", name)
+		if prebuilt != "" {
+			r.addList("deps", []string{":" + importName})
+		}
+		r.addList("linkopts", linkopts)
+		r.write(sb)
+	}
+
+	loads := []string{}
+	if prebuilt != "" {
+		loads = g.install(sb, m, ctx, []string{prebuilt})
+	}
+	bazelAddFragment(ctx.ModuleDir(), name, sb, loads...)
+}
+
+type bazelSingleton struct {
+}
+
+func bazelSingletonFactory() blueprint.Singleton {
+	return &bazelSingleton{}
+}
+
+// Returns the contents of a package's BUILD.bazel
+func bazelBuildFile(pkg string) *strings.Builder {
+	sb := &strings.Builder{}
+	sb.WriteString("# Generated by Bob. Do not edit.\n")
+
+	loads := []string{}
+	for load := range bazelLoads[pkg] {
+		loads = append(loads, load)
+	}
+	if len(loads) > 0 {
+		sb.WriteString("\n")
+		sort.Strings(loads)
+		for _, load := range loads {
+			sb.WriteString(load + "\n")
+		}
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(`package(default_visibility = ["//visibility:public"])` + "\n")
+
+	for _, name := range utils.SortedKeys(bazelFragments[pkg]) {
+		if bazelFragments[pkg][name] != "" {
+			sb.WriteString("\n")
+			sb.WriteString(bazelFragments[pkg][name])
+		}
+	}
+	return sb
+}
+
+func (s *bazelSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	bazelFragmentsLock.Lock()
+	defer bazelFragmentsLock.Unlock()
+
+	buildFiles := []string{}
+	for pkg := range bazelFragments {
+		buildFile := getPathInSourceDir(pkg, "BUILD.bazel")
+		err := fileutils.WriteIfChanged(buildFile, bazelBuildFile(pkg))
+		if err != nil {
+			utils.Exit(1, err.Error())
+		}
+		buildFiles = append(buildFiles, buildFile)
+	}
+
+	// As on the Android.bp backend, write a dummy ninja target to
+	// ensure that the bob package context dependencies are output.
+	ctx.Build(pctx,
+		blueprint.BuildParams{
+			Rule:     dummyRule,
+			Outputs:  buildFiles,
+			Optional: true,
+		})
+}
+
+func (g *bazelGenerator) init(ctx *blueprint.Context, config *bobConfig) {
+	ctx.RegisterBottomUpMutator("bazel_packages", bazelPackagesMutator).Parallel()
+	ctx.RegisterSingletonType("bazel_singleton", bazelSingletonFactory)
+
+	g.toolchainSet.parseConfig(config)
+}
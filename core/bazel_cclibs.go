@@ -0,0 +1,338 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// The headers Bazel accepts in the srcs and hdrs of C/C++ rules
+var bazelHeaderPatterns = []string{"*.h", "*.hh", "*.hpp", "*.hxx", "*.inc", "*.inl"}
+
+// Returns glob patterns matching the headers in include directories.
+// Bazel only makes declared headers available to the compiler, and
+// a package can only declare its own files, so directories in other
+// packages are reported. System directories, given as absolute paths,
+// do not need to be declared.
+func bazelHeaderGlobs(ctx blueprint.ModuleContext, sb *strings.Builder, property string, dirs []string) []string {
+	pkg := ctx.ModuleDir()
+	patterns := []string{}
+	for _, dir := range dirs {
+		if filepath.IsAbs(dir) {
+			continue
+		}
+		dir = filepath.Clean(dir)
+		rel, err := filepath.Rel(pkg, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			bazelWarnf(ctx, sb, property,
+				"%s is outside the package, so its headers are not declared to Bazel", dir)
+			continue
+		}
+		for _, pattern := range bazelHeaderPatterns {
+			patterns = append(patterns, filepath.Join(rel, "**", pattern))
+		}
+	}
+	return patterns
+}
+
+// The name of the Bazel target building a shared library, which
+// decides the name of the library. This includes the variant when the
+// library is built for both the host and the target.
+func bazelSharedLibName(m *sharedLibrary) string {
+	name := m.outputName()
+	if len(m.supportedVariants()) > 1 {
+		name += "__" + string(m.Properties.TargetType)
+	}
+	return name + m.fileNameExtension
+}
+
+// Report the properties of a library which the Bazel backend ignores
+func (g *bazelGenerator) checkUnsupported(sb *strings.Builder, l *library, ctx blueprint.ModuleContext) {
+	props := &l.Properties
+
+	if props.Build_wrapper != nil {
+		bazelWarnf(ctx, sb, "build_wrapper", "build_wrapper is not supported by the Bazel backend, so is not used")
+	}
+	if len(props.Asflags) > 0 {
+		bazelWarnf(ctx, sb, "asflags", "Bazel has no assembler options, so asflags are not used")
+	}
+	if props.Pch != nil {
+		bazelWarnf(ctx, sb, "pch", "precompiled headers are not supported by the Bazel backend")
+	}
+	if len(props.Whole_static_libs) > 0 {
+		bazelWarnf(ctx, sb, "whole_static_libs",
+			"whole_static_libs are linked like static_libs, as Bazel only supports alwayslink on the library itself")
+	}
+	for i, group := range props.SrcsFlagsProps.groups() {
+		if len(group.Srcs) > 0 {
+			bazelWarnf(ctx, sb, srcsFlagsBlocks.propertyName(i+1),
+				"per-source flags are not supported by the Bazel backend, so are not used")
+		}
+	}
+	if props.usesPgo() {
+		bazelWarnf(ctx, sb, "pgo", "profile-guided optimization is not supported by the Bazel backend")
+	}
+	// Coverage only has an effect when the COVERAGE option is enabled
+	if proptools.Bool(props.Coverage) && getConfig(ctx).Properties.GetBool("coverage") {
+		bazelWarnf(ctx, sb, "coverage",
+			"coverage is not supported by the Bazel backend, so the module is not instrumented")
+	}
+	if proptools.Bool(props.Unity_build) {
+		bazelWarnf(ctx, sb, "unity_build",
+			"unity builds are not supported by the Bazel backend, so sources are compiled one at a time")
+	}
+}
+
+// Create a C/C++ rule for a library, with its sources, headers,
+// compiler options and dependencies. The order of flags matches the
+// Linux backend.
+func (g *bazelGenerator) ccRule(sb *strings.Builder, kind, name string, l *library, ctx blueprint.ModuleContext) *bazelRule {
+	pkg := ctx.ModuleDir()
+	g.checkUnsupported(sb, l, ctx)
+
+	srcs := []string{}
+	for _, src := range l.GetSrcs(ctx) {
+		if !strings.HasPrefix(src, bazelGenDir) && getSourceLanguage(src) == langNone && !utils.IsHeader(src) {
+			if _, ok := getCompilerRule(src); ok {
+				bazelWarnf(ctx, sb, "srcs", "%s needs a bob_compiler_rule, which is not supported by the Bazel backend", src)
+			}
+			continue
+		}
+		srcs = append(srcs, getBackendPathInSourceDir(g, src))
+	}
+	srcs = bazelPathLabels(ctx, sb, "srcs", srcs)
+	hdrs := []string{}
+	deps := []string{}
+
+	ctx.VisitDirectDeps(func(m blueprint.Module) {
+		switch ctx.OtherModuleDependencyTag(m) {
+		case generatedHeaderTag:
+			srcs = utils.AppendIfUnique(srcs, bazelModuleLabel(ctx, m))
+		case exportGeneratedHeaderTag:
+			hdrs = utils.AppendIfUnique(hdrs, bazelModuleLabel(ctx, m))
+		case staticDepTag, wholeStaticDepTag, sharedDepTag, headerDepTag:
+			switch dep := m.(type) {
+			case *sharedLibrary:
+				// Shared libraries are linked by adding them to srcs
+				srcs = utils.AppendIfUnique(srcs,
+					bazelLabel(pkg, ctx.OtherModuleDir(m), bazelSharedLibName(dep)))
+			case *generateStaticLibrary, *generateSharedLibrary:
+				srcs = utils.AppendIfUnique(srcs, bazelModuleLabel(ctx, m))
+			default:
+				deps = utils.AppendIfUnique(deps, bazelModuleLabel(ctx, m))
+			}
+		}
+	})
+
+	expLocalIncludes, expIncludes, exportedCflags := l.GetExportedVariables(ctx)
+
+	// The order we want is  local_include_dirs, export_local_include_dirs,
+	//                       include_dirs, export_include_dirs
+	localIncludeDirs := utils.NewStringSlice(l.Properties.Local_include_dirs,
+		l.Properties.Export_local_include_dirs)
+
+	localIncludeDirs = utils.PrefixDirs(localIncludeDirs, g.sourceDir())
+	expLocalIncludes = utils.PrefixDirs(expLocalIncludes, g.sourceDir())
+
+	includeDirs := append(localIncludeDirs, l.Properties.Include_dirs...)
+	includeDirs = append(includeDirs, l.Properties.Export_include_dirs...)
+	includeDirs = append(includeDirs, expLocalIncludes...)
+	includeDirs = append(includeDirs, expIncludes...)
+
+	gendirs, _ := l.GetGeneratedHeaders(ctx)
+	includeDirs = append(includeDirs, gendirs...)
+
+	tc := g.getToolchain(l.Properties.TargetType)
+	_, cctargetflags := tc.getCCompiler()
+	_, cxxtargetflags := tc.getCXXCompiler()
+
+	// The compilers themselves are chosen by Bazel, so only the flags
+	// are taken from the toolchain.
+	copts := utils.PrefixAll(includeDirs, "-I")
+	copts = append(copts, utils.NewStringSlice(l.Properties.Cflags, l.Properties.Export_cflags, exportedCflags,
+		l.getSanitizerCflags(tc), l.getLtoCflags(tc))...)
+
+	r := newBazelRule(kind, name)
+	r.addExpr("srcs", bazelListWithGlob(srcs,
+		bazelHeaderGlobs(ctx, sb, "local_include_dirs", utils.NewStringSlice(l.Properties.Local_include_dirs))))
+	r.addExpr("hdrs", bazelListWithGlob(hdrs,
+		bazelHeaderGlobs(ctx, sb, "export_local_include_dirs", l.Properties.Export_local_include_dirs)))
+	r.addList("copts", copts)
+	r.addList("conlyopts", utils.NewStringSlice(cctargetflags, l.Properties.Conlyflags))
+	r.addList("cxxopts", utils.NewStringSlice(cxxtargetflags, l.Properties.Cxxflags))
+	r.addList("deps", deps)
+
+	return r
+}
+
+// Add the linker options of a shared library or binary
+func (g *bazelGenerator) addLinkOptions(sb *strings.Builder, r *bazelRule, l *library, ctx blueprint.ModuleContext) {
+	tc := g.getToolchain(l.Properties.TargetType)
+	linker := tc.getLinker()
+
+	linkopts := utils.NewStringSlice(linker.getFlags(), l.Properties.Ldflags, l.getSanitizerLdflags(tc),
+		l.getLtoLdflags(tc))
+
+	if versionScript := l.getVersionScript(ctx); versionScript != nil {
+		labels := bazelPathLabels(ctx, sb, "version_script", []string{*versionScript})
+		if len(labels) > 0 {
+			linkopts = append(linkopts, linker.setVersionScript("$(location "+labels[0]+")"))
+			r.addList("additional_linker_inputs", labels)
+		}
+	}
+
+	linkopts = append(linkopts, l.Properties.Ldlibs...)
+	linkopts = append(linkopts, linker.getLibs()...)
+	linkopts = utils.Filter(func(s string) bool { return s != "" }, linkopts)
+	r.addList("linkopts", linkopts)
+
+	if proptools.Bool(l.Properties.Strip) {
+		bazelWarnf(ctx, sb, "strip", "Bazel strips binaries when run with --strip, so strip is not used")
+	}
+}
+
+func (g *bazelGenerator) headerActions(m *headerLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	// There is nothing to build, but the library declares the headers
+	// it exports
+	r := g.ccRule(sb, "cc_library", name, &m.library, ctx)
+	r.addManualTag(m)
+	r.write(sb)
+
+	bazelAddFragment(ctx.ModuleDir(), name, sb)
+}
+
+func (g *bazelGenerator) staticActions(m *staticLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+	pkg := ctx.ModuleDir()
+
+	// Calculate and record outputs. Bazel names the archive after the
+	// target.
+	m.outputdir = bazelOutputPath(pkg)
+	m.outs = []string{bazelOutputPath(pkg, "lib"+name+".a")}
+
+	r := g.ccRule(sb, "cc_library", name, &m.library, ctx)
+	r.addBool("linkstatic", true)
+	r.addManualTag(m)
+	r.write(sb)
+
+	loads := g.install(sb, m, ctx, []string{":" + name})
+	bazelAddFragment(pkg, name, sb, loads...)
+}
+
+func (g *bazelGenerator) sharedActions(m *sharedLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+	pkg := ctx.ModuleDir()
+
+	// Calculate and record outputs
+	soName := bazelSharedLibName(m)
+	m.outputdir = bazelOutputPath(pkg)
+	m.outs = []string{bazelOutputPath(pkg, soName)}
+
+	if m.Properties.Build.isForwardingSharedLibrary() {
+		bazelWarnf(ctx, sb, "forwarding_shlib",
+			"forwarding_shlib is not supported by the Bazel backend, so users are not linked to its dependencies")
+	}
+	if m.Properties.Library_version != "" {
+		bazelWarnf(ctx, sb, "library_version", "library_version is not supported by the Bazel backend")
+	}
+
+	// Bazel builds shared libraries with a cc_binary named after the
+	// library. An alias allows it to be built by the module's name.
+	r := g.ccRule(sb, "cc_binary", soName, &m.library, ctx)
+	r.addBool("linkshared", true)
+	g.addLinkOptions(sb, r, &m.library, ctx)
+	r.addManualTag(m)
+	r.write(sb)
+
+	if name != soName {
+		alias := newBazelRule("alias", name)
+		alias.addString("actual", ":"+soName)
+		alias.write(sb)
+	}
+
+	loads := g.install(sb, m, ctx, []string{":" + soName})
+	bazelAddFragment(pkg, name, sb, loads...)
+}
+
+// Write the rule for a binary, without adding it to the output
+func (g *bazelGenerator) binaryFragment(sb *strings.Builder, kind string, m *binary, ctx blueprint.ModuleContext) *bazelRule {
+	name := m.shortName()
+	pkg := ctx.ModuleDir()
+
+	// Calculate and record outputs. Bazel names the binary after the
+	// target.
+	m.outputdir = bazelOutputPath(pkg)
+	m.outs = []string{bazelOutputPath(pkg, name)}
+
+	r := g.ccRule(sb, kind, name, &m.library, ctx)
+	g.addLinkOptions(sb, r, &m.library, ctx)
+	r.addManualTag(m)
+
+	return r
+}
+
+func (g *bazelGenerator) binaryActions(m *binary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	g.binaryFragment(sb, "cc_binary", m, ctx).write(sb)
+
+	loads := g.install(sb, m, ctx, []string{":" + name})
+	bazelAddFragment(ctx.ModuleDir(), name, sb, loads...)
+}
+
+// Returns the Bazel test size with the shortest timeout which is at
+// least the given number of seconds
+func bazelTestTimeout(seconds int64) string {
+	switch {
+	case seconds <= 60:
+		return "short"
+	case seconds <= 300:
+		return "moderate"
+	case seconds <= 900:
+		return "long"
+	}
+	return "eternal"
+}
+
+func (g *bazelGenerator) testActions(m *test, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	// Tests are run by `bazel test`, rather than a `check` target
+	r := g.binaryFragment(sb, "cc_test", &m.binary, ctx)
+	r.addList("args", m.Properties.Test_args)
+	if m.Properties.Timeout != nil {
+		r.addString("timeout", bazelTestTimeout(*m.Properties.Timeout))
+	}
+	r.write(sb)
+
+	loads := g.install(sb, m, ctx, []string{":" + name})
+	bazelAddFragment(ctx.ModuleDir(), name, sb, loads...)
+}
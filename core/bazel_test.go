@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_bazelRule(t *testing.T) {
+	r := newBazelRule("cc_library", "libfoo")
+	r.addList("srcs", []string{"a.c", "b.c"})
+	r.addList("copts", []string{`-DFOO="a b"`})
+	r.addList("deps", []string{})
+	r.addString("timeout", "")
+	r.addBool("linkstatic", true)
+	r.addBool("linkshared", false)
+
+	sb := &strings.Builder{}
+	r.write(sb)
+
+	assert.Equal(t, `cc_library(
+    name = "libfoo",
+    srcs = [
+        "a.c",
+        "b.c",
+    ],
+    copts = ["-DFOO=\"a b\""],
+    linkstatic = True,
+)
+`, sb.String())
+}
+
+func Test_bazelListWithGlob(t *testing.T) {
+	assert.Equal(t, "", bazelListWithGlob(nil, nil))
+	assert.Equal(t, `["a.c"]`, bazelListWithGlob([]string{"a.c"}, nil))
+	assert.Equal(t, `["a.c"] + glob(["inc/**/*.h"], allow_empty = True)`,
+		bazelListWithGlob([]string{"a.c"}, []string{"inc/**/*.h"}))
+}
+
+func Test_bazelPathLabel(t *testing.T) {
+	bazelPackages["."] = true
+	bazelPackages["lib"] = true
+	bazelPackages["lib/sub"] = true
+	defer func() {
+		bazelPackages = map[string]bool{}
+	}()
+
+	label := func(pkg, path string) string {
+		l, ok := bazelPathLabel(pkg, path)
+		if !ok {
+			return "<none>"
+		}
+		return l
+	}
+
+	assert.Equal(t, "src/a.c", label("lib", "lib/src/a.c"))
+	assert.Equal(t, "//lib:src/a.c", label("app", "lib/src/a.c"))
+	assert.Equal(t, "//lib/sub:a.c", label("lib", "lib/sub/a.c"), "Nested packages own their files")
+	assert.Equal(t, "//:main.c", label("lib", "main.c"))
+	assert.Equal(t, "//lib:gen/out.c", label(".", "$(GENDIR)/lib/gen/out.c"))
+	assert.Equal(t, "<none>", label("lib", "/usr/lib/libz.a"))
+	assert.Equal(t, "<none>", label("lib", "../outside.c"))
+}
+
+func Test_bazelExpandCommand(t *testing.T) {
+	args := map[string]string{
+		"in":       "lib/a.in",
+		"out":      "$(GENDIR)/lib/gen/a.c",
+		"host_bin": "$(execpath //tools:gen)",
+	}
+
+	assert.Equal(t, "$(execpath //tools:gen) lib/a.in -o $(GENDIR)/lib/gen/a.c",
+		bazelExpandCommand("${host_bin} ${in} -o $out", args))
+	assert.Equal(t, "echo $$PATH $${unknown}",
+		bazelExpandCommand("echo $$PATH ${unknown}", args))
+}
+
+func Test_bazelTestTimeout(t *testing.T) {
+	assert.Equal(t, "short", bazelTestTimeout(10))
+	assert.Equal(t, "moderate", bazelTestTimeout(61))
+	assert.Equal(t, "long", bazelTestTimeout(900))
+	assert.Equal(t, "eternal", bazelTestTimeout(3600))
+}
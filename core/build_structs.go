@@ -18,7 +18,6 @@
 package core
 
 import (
-	"fmt"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -104,7 +103,10 @@ type generatorBackend interface {
 	kernelModuleActions(m *kernelModule, ctx blueprint.ModuleContext)
 	sharedActions(*sharedLibrary, blueprint.ModuleContext)
 	staticActions(*staticLibrary, blueprint.ModuleContext)
+	headerActions(*headerLibrary, blueprint.ModuleContext)
 	resourceActions(*resource, blueprint.ModuleContext)
+	testActions(*test, blueprint.ModuleContext)
+	externalLibActions(*externalLib, blueprint.ModuleContext)
 
 	// Backend specific info for module types
 	buildDir() string
@@ -468,6 +470,8 @@ func targetMutator(mctx blueprint.TopDownMutatorContext) {
 				panic(err)
 			}
 		}
+
+		recordDisabledByTarget(mctx, t, tgt, &getConfig(mctx).Properties)
 	}
 }
 
@@ -593,7 +597,8 @@ func checkDisabledMutator(mctx blueprint.BottomUpMutatorContext) {
 	}
 
 	// check if any direct dependency is disabled
-	disabledDeps := []string{}
+	disabledDeps := []blueprint.Module{}
+	visited := map[string]bool{}
 
 	mctx.VisitDirectDeps(func(dep blueprint.Module) {
 		// ignore defaults - it's allowed for them to be disabled
@@ -601,18 +606,24 @@ func checkDisabledMutator(mctx blueprint.BottomUpMutatorContext) {
 			return
 		}
 		if e, ok := dep.(enableable); ok {
-			if !isEnabled(e) {
-				disabledDeps = utils.AppendIfUnique(disabledDeps, dep.Name())
+			if !isEnabled(e) && !visited[dep.Name()] {
+				visited[dep.Name()] = true
+				disabledDeps = append(disabledDeps, dep)
 			}
 		}
 	})
 
-	// disable current module if dependency is disabled, or panic if it's required
+	// disable current module if dependency is disabled, or report an error if it's required
 	if len(disabledDeps) > 0 {
 		if isRequired(ep) {
-			panic(fmt.Errorf("Module %s is required but depends on disabled modules %s", module.Name(), strings.Join(disabledDeps, ", ")))
+			for _, dep := range disabledDeps {
+				moduleErrorf(mctx, "is required but depends on %s, which is %s",
+					dep.Name(), disabledReason(enableablePropsOf(dep)))
+			}
 		} else {
-			ep.getEnableableProps().Enabled = proptools.BoolPtr(false)
+			props := ep.getEnableableProps()
+			props.Enabled = proptools.BoolPtr(false)
+			recordDisabledByDependency(props, disabledDeps[0])
 			return
 		}
 	}
@@ -624,6 +635,8 @@ func registerModuleTypes(register func(string, factoryWithConfig)) {
 	register("bob_binary", binaryFactory)
 	register("bob_static_library", staticLibraryFactory)
 	register("bob_shared_library", sharedLibraryFactory)
+	register("bob_header_library", headerLibraryFactory)
+	register("bob_test", testFactory)
 
 	register("bob_defaults", defaultsFactory)
 
@@ -638,6 +651,7 @@ func registerModuleTypes(register func(string, factoryWithConfig)) {
 	register("bob_generate_binary", genBinaryFactory)
 
 	register("bob_alias", aliasFactory)
+	register("bob_compiler_rule", compilerRuleFactory)
 	register("bob_kernel_module", kernelModuleFactory)
 	register("bob_resource", resourceFactory)
 	register("bob_install_group", installGroupFactory)
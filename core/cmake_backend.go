@@ -0,0 +1,556 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/fileutils"
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+var (
+	// CMake fragments for each module, indexed by CMake target name.
+	// Modules generate their build actions in parallel, so access
+	// is protected by cmakeFragmentsLock.
+	cmakeFragmentsLock sync.Mutex
+	cmakeFragments     = map[string]string{}
+
+	// CMake variables which may be referenced by paths written to
+	// CMakeLists.txt. These are not escaped, so that CMake expands them.
+	cmakeVariables = []string{
+		"BOB_SCRIPTS_DIR",
+		"BOB_SOURCE_DIR",
+		"CMAKE_AR",
+		"CMAKE_COMMAND",
+		"CMAKE_CURRENT_BINARY_DIR",
+	}
+
+	// Matches `$$`, `${var}` and `$var` in a ninja-style command
+	ninjaVariableRegexp = regexp.MustCompile(`\$\$|\$\{([a-zA-Z0-9_-]+)\}|\$([a-zA-Z0-9_-]+)`)
+)
+
+type cmakeGenerator struct {
+	toolchainSet
+}
+
+/* Compile time checks for interfaces that must be implemented by cmakeGenerator */
+var _ generatorBackend = (*cmakeGenerator)(nil)
+
+// Quote a string so that it is passed to a CMake command as a single
+// argument.
+func cmakeQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, `;`, `\;`).Replace(s)
+
+	// Keep references to the variables we define, and generator
+	// expressions, so that CMake still evaluates them.
+	s = strings.Replace(s, `\$<`, `$<`, -1)
+	for _, v := range cmakeVariables {
+		s = strings.Replace(s, `\${`+v+`}`, "${"+v+"}", -1)
+	}
+
+	return `"` + s + `"`
+}
+
+func cmakeQuoteAll(list []string) []string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = cmakeQuote(s)
+	}
+	return quoted
+}
+
+// Write a CMake command invocation, one argument per line. Arguments
+// are written as-is, so any values must already be quoted.
+func cmakeWriteCommand(sb *strings.Builder, command string, args ...string) {
+	sb.WriteString(command + "(")
+	for i, arg := range args {
+		if i > 0 {
+			sb.WriteString("\n    ")
+		}
+		sb.WriteString(arg)
+	}
+	sb.WriteString(")\n")
+}
+
+// Expand the ninja-style variable references in cmd using args, so
+// that the command can be run by CMake. `$$` becomes a plain `$` for
+// the shell, and unknown variables are left untouched.
+func cmakeExpandCommand(cmd string, args map[string]string) string {
+	return ninjaVariableRegexp.ReplaceAllStringFunc(cmd, func(s string) string {
+		if s == "$$" {
+			return "$"
+		}
+		if value, ok := args[strings.Trim(s, "${}")]; ok {
+			return value
+		}
+		return s
+	})
+}
+
+func cmakeAddFragment(name string, sb *strings.Builder) {
+	cmakeFragmentsLock.Lock()
+	defer cmakeFragmentsLock.Unlock()
+
+	cmakeFragments[name] = sb.String()
+}
+
+func cmakeHasTarget(name string) bool {
+	cmakeFragmentsLock.Lock()
+	defer cmakeFragmentsLock.Unlock()
+
+	_, ok := cmakeFragments[name]
+	return ok
+}
+
+// The name of the CMake target created for a module
+func cmakeTargetName(ctx blueprint.BaseModuleContext, m blueprint.Module) string {
+	if p, ok := m.(phonyInterface); ok {
+		return p.shortName()
+	}
+	return ctx.OtherModuleName(m)
+}
+
+// Returns the CMake targets of the direct dependencies of the current
+// module. Dependencies which do not create a target, such as external
+// libraries, are skipped.
+func cmakeDependencyTargets(ctx blueprint.ModuleContext) []string {
+	targets := []string{}
+	ctx.VisitDirectDeps(func(m blueprint.Module) {
+		name := cmakeTargetName(ctx, m)
+		if cmakeHasTarget(name) {
+			targets = utils.AppendIfUnique(targets, name)
+		}
+	})
+	return targets
+}
+
+func cmakeAddDependencies(sb *strings.Builder, name string, ctx blueprint.ModuleContext) {
+	deps := cmakeDependencyTargets(ctx)
+	if len(deps) > 0 {
+		cmakeWriteCommand(sb, "add_dependencies", cmakeQuoteAll(append([]string{name}, deps...))...)
+	}
+}
+
+func (g *cmakeGenerator) buildDir() string {
+	// All outputs are placed in the CMake build tree, which is chosen
+	// when CMake is run on the generated CMakeLists.txt.
+	return "${CMAKE_CURRENT_BINARY_DIR}"
+}
+
+func (g *cmakeGenerator) sourceDir() string {
+	return "${BOB_SOURCE_DIR}"
+}
+
+func (g *cmakeGenerator) bobScriptsDir() string {
+	return "${BOB_SCRIPTS_DIR}"
+}
+
+func (g *cmakeGenerator) sharedLibsDir(tgt tgtType) string {
+	return filepath.Join(g.buildDir(), string(tgt), "shared")
+}
+
+func (g *cmakeGenerator) staticLibOutputDir(tgt tgtType) string {
+	return filepath.Join(g.buildDir(), string(tgt), "static")
+}
+
+func (g *cmakeGenerator) binaryOutputDir(tgt tgtType) string {
+	return filepath.Join(g.buildDir(), string(tgt), "executable")
+}
+
+func (g *cmakeGenerator) sourceOutputDir(m *generateCommon) string {
+	return filepath.Join(g.buildDir(), "gen", m.Name())
+}
+
+func (g *cmakeGenerator) kernelModOutputDir(m *kernelModule) string {
+	return filepath.Join(g.buildDir(), "target", "kernel_modules", m.outputName())
+}
+
+func (g *cmakeGenerator) escapeFlag(s string) string {
+	// CMake quotes each argument when it writes command lines, and
+	// flags are quoted for CMakeLists.txt when they are written out, so
+	// the CMake backend just passes them through.
+	return s
+}
+
+// Write the install rules for a module. Modules built by CMake
+// targets are installed with install(TARGETS), so that CMake also
+// installs the library symlinks. Everything else is installed as files.
+func (g *cmakeGenerator) install(sb *strings.Builder, m interface{}, ctx blueprint.ModuleContext, target string) {
+	ins := m.(installable)
+
+	props := ins.getInstallableProps()
+	installPath, ok := props.getInstallPath()
+	if !ok {
+		return
+	}
+
+	if target != "" {
+		cmakeWriteCommand(sb, "install", "TARGETS", cmakeQuote(target),
+			"DESTINATION", cmakeQuote(installPath))
+		return
+	}
+
+	files := ins.filesToInstall(ctx)
+	if _, ok := m.(*resource); ok {
+		// Resources always come from the source directory.
+		files = getBackendPathsInSourceDir(g, files)
+	}
+	if len(files) > 0 {
+		args := append([]string{"FILES"}, cmakeQuoteAll(files)...)
+		cmakeWriteCommand(sb, "install", append(args, "DESTINATION", cmakeQuote(installPath))...)
+	}
+}
+
+// Write a custom target building outputs, optionally as part of the
+// default target.
+func cmakeCustomTarget(sb *strings.Builder, name string, outputs []string, all bool) {
+	args := []string{cmakeQuote(name)}
+	if all {
+		args = append(args, "ALL")
+	}
+	if len(outputs) > 0 {
+		args = append(args, "DEPENDS")
+		args = append(args, cmakeQuoteAll(outputs)...)
+	}
+	cmakeWriteCommand(sb, "add_custom_target", args...)
+}
+
+func (g *cmakeGenerator) aliasActions(m *alias, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	deps := []string{}
+
+	/* Only depend on enabled targets */
+	ctx.VisitDirectDepsIf(
+		func(p blueprint.Module) bool { return ctx.OtherModuleDependencyTag(p) == aliasTag },
+		func(p blueprint.Module) {
+			if e, ok := p.(enableable); ok {
+				if !isEnabled(e) {
+					return
+				}
+			}
+			name := cmakeTargetName(ctx, p)
+			if cmakeHasTarget(name) {
+				deps = append(deps, name)
+			}
+		})
+
+	cmakeCustomTarget(sb, m.Name(), nil, false)
+	if len(deps) > 0 {
+		cmakeWriteCommand(sb, "add_dependencies", cmakeQuoteAll(append([]string{m.Name()}, deps...))...)
+	}
+	cmakeAddFragment(m.Name(), sb)
+}
+
+// Write the custom commands of a generated module, returning all the
+// files which they output.
+func (g *cmakeGenerator) generateCommonActions(sb *strings.Builder, m *generateCommon, ctx blueprint.ModuleContext, inouts []inout) []string {
+	m.outputdir = g.sourceOutputDir(m)
+	prefixInoutsWithOutputDir(inouts, m.outputDir())
+	// Calculate and record outputs and include dirs
+	m.recordOutputsFromInout(inouts)
+	m.includeDirs = utils.PrefixDirs(m.Properties.Export_gen_include_dirs, m.outputDir())
+	m.encapsulatedOuts = getGeneratedEncapsulatedFiles(ctx)
+
+	cmd, args, implicits, hostTarget := m.getArgs(ctx)
+
+	if _, ok := args["host_bin"]; ok {
+		cmd = "LD_LIBRARY_PATH=" + g.sharedLibsDir(hostTarget) + ":$$LD_LIBRARY_PATH " + cmd
+	}
+
+	outputs := []string{}
+	for _, inout := range inouts {
+		if inout.depfile != "" && len(inout.out) > 1 {
+			panic(fmt.Errorf("Module %s uses a depfile with multiple outputs", ctx.ModuleName()))
+		}
+
+		args["in"] = strings.Join(inout.in, " ")
+		args["out"] = strings.Join(inout.out, " ")
+		args["depfile"] = inout.depfile
+		args["rspfile"] = inout.rspfile
+
+		if _, ok := args["headers_generated"]; ok {
+			headers := utils.Filter(utils.IsHeader, inout.out)
+			args["headers_generated"] = strings.Join(headers, " ")
+		}
+		if _, ok := args["srcs_generated"]; ok {
+			sources := utils.Filter(utils.IsNotHeader, inout.out)
+			args["srcs_generated"] = strings.Join(sources, " ")
+		}
+
+		command := cmakeExpandCommand(cmd, args)
+		if m.Properties.Rsp_content != nil {
+			// CMake has no equivalent of ninja's rspfile_content,
+			// so write the response file before running the command.
+			content := cmakeExpandCommand(*m.Properties.Rsp_content, args)
+			command = "printf '%s' " + proptools.ShellEscape(content) +
+				" > " + inout.rspfile + " && " + command
+		}
+
+		outs := append(utils.NewStringSlice(inout.out), inout.implicitOuts...)
+
+		// Unlike ninja, CMake does not always create output directories
+		dirs := []string{}
+		for _, out := range outs {
+			dirs = utils.AppendIfUnique(dirs, filepath.Dir(out))
+		}
+
+		cmdArgs := append([]string{"OUTPUT"}, cmakeQuoteAll(outs)...)
+		cmdArgs = append(cmdArgs, "COMMAND", "${CMAKE_COMMAND}", "-E", "make_directory")
+		cmdArgs = append(cmdArgs, cmakeQuoteAll(dirs)...)
+		cmdArgs = append(cmdArgs, "COMMAND", "sh", "-c", cmakeQuote(command))
+		deps := utils.NewStringSlice(inout.in, inout.implicitSrcs, implicits)
+		if len(deps) > 0 {
+			cmdArgs = append(cmdArgs, "DEPENDS")
+			cmdArgs = append(cmdArgs, cmakeQuoteAll(deps)...)
+		}
+		if inout.depfile != "" {
+			cmdArgs = append(cmdArgs, "DEPFILE", cmakeQuote(inout.depfile))
+		}
+		if m.Properties.Console {
+			// Console can be used to run longrunning jobs (even interactive jobs).
+			cmdArgs = append(cmdArgs, "USES_TERMINAL")
+		}
+		cmdArgs = append(cmdArgs, "WORKING_DIRECTORY", g.buildDir(), "VERBATIM")
+		cmakeWriteCommand(sb, "add_custom_command", cmdArgs...)
+
+		outputs = append(outputs, outs...)
+	}
+
+	return outputs
+}
+
+// Write a command copying a file to another location
+func cmakeCopy(sb *strings.Builder, src, dest string) {
+	cmakeWriteCommand(sb, "add_custom_command",
+		"OUTPUT", cmakeQuote(dest),
+		"COMMAND", "${CMAKE_COMMAND}", "-E", "make_directory", cmakeQuote(filepath.Dir(dest)),
+		"COMMAND", "${CMAKE_COMMAND}", "-E", "copy", cmakeQuote(src), cmakeQuote(dest),
+		"DEPENDS", cmakeQuote(src),
+		"VERBATIM")
+}
+
+// The interfaces shared by all the generated module types
+type cmakeGeneratedModule interface {
+	phonyInterface
+	enableable
+	installable
+}
+
+// Write the common parts of the generated module types: a target
+// building their outputs, and the install rules.
+func (g *cmakeGenerator) generatedModuleActions(m cmakeGeneratedModule, ctx blueprint.ModuleContext,
+	sb *strings.Builder, outputs []string) {
+
+	cmakeCustomTarget(sb, m.shortName(), outputs, isBuiltByDefault(m))
+	cmakeAddDependencies(sb, m.shortName(), ctx)
+	g.install(sb, m, ctx, "")
+	cmakeAddFragment(m.shortName(), sb)
+}
+
+func (g *cmakeGenerator) generateSourceActions(m *generateSource, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *cmakeGenerator) transformSourceActions(m *transformSource, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+func (g *cmakeGenerator) genStaticActions(m *generateStaticLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+	g.generatedModuleActions(m, ctx, sb, outputs)
+}
+
+// Full path for a generated shared library, copied to the common
+// shared library directory so that it can be found at runtime.
+func (g *cmakeGenerator) getSharedLibLinkPath(t targetableModule) string {
+	return filepath.Join(g.sharedLibsDir(t.getTarget()), t.outputFileName())
+}
+
+func (g *cmakeGenerator) genSharedActions(m *generateSharedLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+
+	// Copy the generated library from gen_dir to the common library directory
+	soFile := g.getSharedLibLinkPath(m)
+	cmakeCopy(sb, m.outputs()[0], soFile)
+
+	g.generatedModuleActions(m, ctx, sb, append(outputs, soFile))
+}
+
+func (g *cmakeGenerator) genBinaryActions(m *generateBinary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	inouts := m.generateInouts(ctx, g)
+	outputs := g.generateCommonActions(sb, &m.generateCommon, ctx, inouts)
+
+	// Copy the generated binary from gen_dir to the common binary directory
+	binFile := filepath.Join(g.binaryOutputDir(m.getTarget()), m.outputFileName())
+	cmakeCopy(sb, m.outputs()[0], binFile)
+
+	g.generatedModuleActions(m, ctx, sb, append(outputs, binFile))
+}
+
+// The kernel module build command. This matches kbuildRule on the Linux
+// backend, with the variables expanded by cmakeExpandCommand.
+const cmakeKbuildCommand = "python $kmod_build -o $out --depfile $depfile " +
+	"--common-root $src_dir " +
+	"--module-dir $output_module_dir $extra_includes " +
+	"--sources $in " +
+	"--kernel $kernel_dir --cross-compile '$kernel_cross_compile' " +
+	"$cc_flag $hostcc_flag $clang_triple_flag $ld_flag " +
+	"$kbuild_options --extra-cflags='$extra_cflags' $make_args"
+
+func (g *cmakeGenerator) kernelModuleActions(m *kernelModule, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+
+	// Calculate and record outputs
+	m.outputdir = g.kernelModOutputDir(m)
+	m.outs = []string{filepath.Join(m.outputDir(), m.outputName()+".ko")}
+
+	sources := utils.NewStringSlice(
+		getBackendPathsInSourceDir(g, m.Properties.getSources(ctx)),
+		m.Properties.SourceProps.Specials,
+		m.extraSymbolsFiles(ctx))
+
+	args := m.generateKbuildArgs(ctx).toDict()
+	args["in"] = strings.Join(sources, " ")
+	args["out"] = m.outputs()[0]
+	args["depfile"] = m.outputs()[0] + ".d"
+	args["src_dir"] = g.sourceDir()
+
+	cmdArgs := []string{
+		"OUTPUT", cmakeQuote(m.outputs()[0]),
+		"BYPRODUCTS", cmakeQuote(filepath.Join(m.outputDir(), "Module.symvers")),
+		"COMMAND", "${CMAKE_COMMAND}", "-E", "make_directory", cmakeQuote(m.outputDir()),
+		"COMMAND", "sh", "-c", cmakeQuote(cmakeExpandCommand(cmakeKbuildCommand, args)),
+		"DEPENDS", cmakeQuote(args["kmod_build"]),
+	}
+	cmdArgs = append(cmdArgs, cmakeQuoteAll(sources)...)
+	cmdArgs = append(cmdArgs, "DEPFILE", cmakeQuote(args["depfile"]), "USES_TERMINAL",
+		"WORKING_DIRECTORY", g.buildDir(), "VERBATIM")
+	cmakeWriteCommand(sb, "add_custom_command", cmdArgs...)
+
+	cmakeCustomTarget(sb, m.shortName(), m.outputs(), true)
+	cmakeAddDependencies(sb, m.shortName(), ctx)
+	g.install(sb, m, ctx, "")
+	cmakeAddFragment(m.shortName(), sb)
+}
+
+func (g *cmakeGenerator) resourceActions(m *resource, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+
+	// Resources are not built, so the target only exists so that
+	// aliases can refer to it.
+	cmakeCustomTarget(sb, m.shortName(), nil, false)
+	g.install(sb, m, ctx, "")
+	cmakeAddFragment(m.shortName(), sb)
+}
+
+func (g *cmakeGenerator) externalLibActions(m *externalLib, ctx blueprint.ModuleContext) {
+	// Only prebuilt libraries have anything to install
+	if len(m.outputs()) == 0 {
+		return
+	}
+
+	sb := &strings.Builder{}
+	cmakeCustomTarget(sb, m.shortName(), nil, false)
+	g.install(sb, m, ctx, "")
+
+	// install(FILES) cannot create symlinks, so do it with a script
+	if installPath, ok := m.getInstallableProps().getInstallPath(); ok {
+		symlinks := m.librarySymlinks(ctx)
+		for _, name := range utils.SortedKeys(symlinks) {
+			code := "file(CREATE_LINK \"" + symlinks[name] + "\" " +
+				"\"$ENV{DESTDIR}${CMAKE_INSTALL_PREFIX}/" + filepath.Join(installPath, name) + "\" SYMBOLIC)"
+			cmakeWriteCommand(sb, "install", "CODE", cmakeQuote(code))
+		}
+	}
+
+	cmakeAddFragment(m.shortName(), sb)
+}
+
+type cmakeSingleton struct {
+}
+
+func cmakeSingletonFactory() blueprint.Singleton {
+	return &cmakeSingleton{}
+}
+
+func (s *cmakeSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	sb := &strings.Builder{}
+
+	srcDir, err := filepath.Abs(getSourceDir())
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+	scriptsDir, err := filepath.Abs(getBobScriptsDir())
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+
+	sb.WriteString("# Generated by Bob. Do not edit.\n")
+	cmakeWriteCommand(sb, "cmake_minimum_required", "VERSION", "3.20")
+	cmakeWriteCommand(sb, "project", cmakeQuote(filepath.Base(srcDir)), "LANGUAGES", "C", "CXX", "ASM")
+	cmakeWriteCommand(sb, "set", "BOB_SOURCE_DIR", cmakeQuote(srcDir))
+	cmakeWriteCommand(sb, "set", "BOB_SCRIPTS_DIR", cmakeQuote(scriptsDir))
+	cmakeWriteCommand(sb, "enable_testing")
+
+	cmakeFragmentsLock.Lock()
+	for _, name := range utils.SortedKeys(cmakeFragments) {
+		sb.WriteString("\n")
+		sb.WriteString(cmakeFragments[name])
+	}
+	cmakeFragmentsLock.Unlock()
+
+	cmakeListsFile := getPathInBuildDir("CMakeLists.txt")
+	err = fileutils.WriteIfChanged(cmakeListsFile, sb)
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+
+	// As on the Android.bp backend, write a dummy ninja target to
+	// ensure that the bob package context dependencies are output.
+	ctx.Build(pctx,
+		blueprint.BuildParams{
+			Rule:     dummyRule,
+			Outputs:  []string{cmakeListsFile},
+			Optional: true,
+		})
+}
+
+func (g *cmakeGenerator) init(ctx *blueprint.Context, config *bobConfig) {
+	ctx.RegisterSingletonType("cmake_singleton", cmakeSingletonFactory)
+
+	g.toolchainSet.parseConfig(config)
+}
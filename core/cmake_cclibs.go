@@ -0,0 +1,526 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// Wrap flags in a generator expression, so that they are only used
+// when compiling the given language.
+func cmakeLanguageFlags(lang string, flags []string) []string {
+	// Characters which would end the generator expression early
+	escaper := strings.NewReplacer(">", "$<ANGLE-R>", ",", "$<COMMA>", ";", "$<SEMICOLON>")
+
+	wrapped := []string{}
+	for _, flag := range flags {
+		wrapped = append(wrapped, "$<$<COMPILE_LANGUAGE:"+lang+">:"+escaper.Replace(flag)+">")
+	}
+	return wrapped
+}
+
+// Returns the sources of a C/C++ library compiled by CMake itself, with
+// paths usable by CMake. Sources needing a bob_compiler_rule are
+// compiled by custom commands instead.
+func (g *cmakeGenerator) getSrcs(l *library, ctx blueprint.ModuleContext) []string {
+	srcs := []string{}
+	for _, source := range l.GetSrcs(ctx) {
+		if _, ok := getCompilerRule(source); ok && getSourceLanguage(source) == langNone {
+			continue
+		}
+		if !strings.HasPrefix(source, g.buildDir()) {
+			source = getBackendPathInSourceDir(g, source)
+		}
+		srcs = append(srcs, source)
+	}
+	return srcs
+}
+
+// Returns the include directories of a library, in the order used by
+// the Linux backend
+func (g *cmakeGenerator) getIncludeDirs(l *library, ctx blueprint.ModuleContext) []string {
+	expLocalIncludes, expIncludes, _ := l.GetExportedVariables(ctx)
+
+	// The order we want is  local_include_dirs, export_local_include_dirs,
+	//                       include_dirs, export_include_dirs
+	localIncludeDirs := utils.NewStringSlice(l.Properties.Local_include_dirs,
+		l.Properties.Export_local_include_dirs)
+
+	localIncludeDirs = utils.PrefixDirs(localIncludeDirs, g.sourceDir())
+	expLocalIncludes = utils.PrefixDirs(expLocalIncludes, g.sourceDir())
+
+	includeDirs := append(localIncludeDirs, l.Properties.Include_dirs...)
+	includeDirs = append(includeDirs, l.Properties.Export_include_dirs...)
+	includeDirs = append(includeDirs, expLocalIncludes...)
+	includeDirs = append(includeDirs, expIncludes...)
+
+	gendirs, _ := l.GetGeneratedHeaders(ctx)
+	return append(includeDirs, gendirs...)
+}
+
+// Write the include directories and compile options of a library. The
+// order of flags matches the Linux backend.
+func (g *cmakeGenerator) writeCompileOptions(sb *strings.Builder, l *library, name string, ctx blueprint.ModuleContext) {
+	_, _, exportedCflags := l.GetExportedVariables(ctx)
+
+	includeDirs := g.getIncludeDirs(l, ctx)
+	if len(includeDirs) > 0 {
+		cmakeWriteCommand(sb, "target_include_directories",
+			append([]string{cmakeQuote(name), "PRIVATE"}, cmakeQuoteAll(includeDirs)...)...)
+	}
+
+	tc := g.getToolchain(l.Properties.TargetType)
+	_, astargetflags := tc.getAssembler()
+	_, cctargetflags := tc.getCCompiler()
+	_, cxxtargetflags := tc.getCXXCompiler()
+
+	// The compilers themselves are chosen by CMake, so only the flags
+	// are taken from the toolchain.
+	options := utils.NewStringSlice(l.Properties.Cflags, l.Properties.Export_cflags, exportedCflags,
+		l.getSanitizerCflags(tc), l.getLtoCflags(tc))
+	options = append(options, cmakeLanguageFlags("ASM",
+		utils.NewStringSlice(astargetflags, l.Properties.Asflags))...)
+	options = append(options, cmakeLanguageFlags("C",
+		utils.NewStringSlice(cctargetflags, l.Properties.Conlyflags))...)
+	options = append(options, cmakeLanguageFlags("CXX",
+		utils.NewStringSlice(cxxtargetflags, l.Properties.Cxxflags))...)
+
+	if len(options) > 0 {
+		cmakeWriteCommand(sb, "target_compile_options",
+			append([]string{cmakeQuote(name), "PRIVATE"}, cmakeQuoteAll(options)...)...)
+	}
+
+	// Flags from srcs_flags are source file properties, which CMake
+	// adds after the target's options. The extension decides which
+	// flags apply, as for the target's language-specific options.
+	srcsFlags := l.Properties.getSrcsFlags(ctx)
+	for _, src := range l.Properties.getSources(ctx) {
+		group, ok := srcsFlags[src]
+		if !ok {
+			continue
+		}
+		if flags := group.compileFlags(src); len(flags) > 0 {
+			cmakeWriteCommand(sb, "set_property",
+				append([]string{"SOURCE", cmakeQuote(getBackendPathInSourceDir(g, src)),
+					"APPEND", "PROPERTY", "COMPILE_OPTIONS"}, cmakeQuoteAll(flags)...)...)
+		}
+	}
+}
+
+// Write custom commands compiling the sources which need a
+// bob_compiler_rule, returning the objects to add to the target. The
+// module flags passed to the tool are the ones CMake uses for the
+// target's own sources.
+func (g *cmakeGenerator) writeCompilerRuleCommands(sb *strings.Builder, l *library, ctx blueprint.ModuleContext) []string {
+	tc := g.getToolchain(l.Properties.TargetType)
+	_, _, exportedCflags := l.GetExportedVariables(ctx)
+	_, astargetflags := tc.getAssembler()
+	_, cctargetflags := tc.getCCompiler()
+	_, cxxtargetflags := tc.getCXXCompiler()
+
+	moduleFlags := map[string]string{
+		"asflags": utils.Join(astargetflags, l.Properties.Asflags),
+		"cflags": utils.Join(l.Properties.Cflags, l.Properties.Export_cflags, exportedCflags,
+			l.getSanitizerCflags(tc), l.getLtoCflags(tc),
+			utils.PrefixAll(g.getIncludeDirs(l, ctx), "-I")),
+		"conlyflags": utils.Join(cctargetflags, l.Properties.Conlyflags),
+		"cxxflags":   utils.Join(cxxtargetflags, l.Properties.Cxxflags),
+	}
+	objDir := filepath.Join(g.buildDir(), string(l.Properties.TargetType), "objects", l.outputName())
+
+	objects := []string{}
+	for _, source := range l.GetSrcs(ctx) {
+		rule, ok := getCompilerRule(source)
+		if !ok || getSourceLanguage(source) != langNone {
+			continue
+		}
+
+		var sourceWithoutPrefix string
+		if strings.HasPrefix(source, g.buildDir()) {
+			sourceWithoutPrefix = source[len(g.buildDir()):]
+		} else {
+			sourceWithoutPrefix = source
+			source = getBackendPathInSourceDir(g, source)
+		}
+		output := filepath.Join(objDir, sourceWithoutPrefix) + ".o"
+		depfile := output + ".d"
+
+		command := utils.Join([]string{*rule.Properties.Tool},
+			rule.Properties.getFlags(moduleFlags, depfile), []string{source, "-o", output})
+
+		args := []string{"OUTPUT", cmakeQuote(output),
+			"COMMAND", "${CMAKE_COMMAND}", "-E", "make_directory", cmakeQuote(filepath.Dir(output)),
+			"COMMAND", "sh", "-c", cmakeQuote(command),
+			"DEPENDS", cmakeQuote(source)}
+		if rule.Properties.hasDepfile() {
+			args = append(args, "DEPFILE", cmakeQuote(depfile))
+		}
+		args = append(args, "WORKING_DIRECTORY", g.buildDir(), "VERBATIM")
+		cmakeWriteCommand(sb, "add_custom_command", args...)
+
+		objects = append(objects, output)
+	}
+
+	return objects
+}
+
+// Returns the whole static libraries to link, either as CMake target
+// names, or as paths for generated libraries.
+func (g *cmakeGenerator) getWholeStaticLibs(ctx blueprint.ModuleContext) []string {
+	libs := []string{}
+	ctx.VisitDirectDepsIf(
+		func(m blueprint.Module) bool { return ctx.OtherModuleDependencyTag(m) == wholeStaticDepTag },
+		func(m blueprint.Module) {
+			if sl, ok := m.(*staticLibrary); ok {
+				libs = append(libs, sl.shortName())
+			} else if sl, ok := m.(*generateStaticLibrary); ok {
+				libs = append(libs, sl.outputs()...)
+			} else if _, ok := m.(*externalLib); ok {
+				panic(errors.New(ctx.OtherModuleName(m) +
+					" is external, so cannot be used in whole_static_libs"))
+			} else {
+				panic(errors.New(ctx.OtherModuleName(m) + " is not a static library"))
+			}
+		})
+
+	return libs
+}
+
+// Returns the static libraries to link, in the order given by
+// ResolvedStaticLibs.
+func (g *cmakeGenerator) getStaticLibs(l *library, ctx blueprint.ModuleContext) []string {
+	libs := []string{}
+	for _, moduleName := range l.Properties.ResolvedStaticLibs {
+		dep, _ := ctx.GetDirectDep(moduleName)
+		if dep == nil {
+			panic(fmt.Errorf("%s has no dependency on static lib %s", l.Name(), moduleName))
+		}
+		if sl, ok := dep.(*staticLibrary); ok {
+			libs = append(libs, sl.shortName())
+		} else if sl, ok := dep.(*generateStaticLibrary); ok {
+			libs = append(libs, sl.outputs()...)
+		} else if el, ok := dep.(*externalLib); ok {
+			// External static libraries are added to the link using the flags
+			// exported by their ldlibs and ldflags properties, unless they
+			// are prebuilt.
+			libs = append(libs, el.outputs()...)
+		} else {
+			panic(errors.New(ctx.OtherModuleName(dep) + " is not a static library"))
+		}
+	}
+
+	return libs
+}
+
+// Returns the shared libraries to link, and any linker flags they
+// require. The install paths of the libraries are also returned, so
+// that an rpath can be set.
+func (g *cmakeGenerator) getSharedLibs(l *library, ctx blueprint.ModuleContext) (libs, ldflags, libPaths []string) {
+	// With forwarding shared library we do not have to use
+	// --no-as-needed for dependencies because it is already set
+	useNoAsNeeded := !l.Properties.Build.isForwardingSharedLibrary()
+	hasForwardingLib := false
+	linker := g.getToolchain(l.Properties.TargetType).getLinker()
+
+	ctx.VisitDirectDepsIf(
+		func(m blueprint.Module) bool { return ctx.OtherModuleDependencyTag(m) == sharedDepTag },
+		func(m blueprint.Module) {
+			if sl, ok := m.(*sharedLibrary); ok {
+				b := &sl.library.Properties.Build
+				if b.isForwardingSharedLibrary() {
+					hasForwardingLib = true
+					libs = append(libs, linker.keepSharedLibraryTransitivity())
+					if useNoAsNeeded {
+						libs = append(libs, linker.keepUnusedDependencies())
+					}
+				}
+				libs = append(libs, sl.shortName())
+				if b.isForwardingSharedLibrary() {
+					if useNoAsNeeded {
+						libs = append(libs, linker.dropUnusedDependencies())
+					}
+					libs = append(libs, linker.dropSharedLibraryTransitivity())
+				}
+				if installPath, ok := sl.Properties.InstallableProps.getInstallPath(); ok {
+					libPaths = utils.AppendIfUnique(libPaths, installPath)
+				}
+			} else if sl, ok := m.(*generateSharedLibrary); ok {
+				libs = append(libs, g.getSharedLibLinkPath(sl))
+				if installPath, ok := sl.generateCommon.Properties.InstallableProps.getInstallPath(); ok {
+					libPaths = utils.AppendIfUnique(libPaths, installPath)
+				}
+			} else if el, ok := m.(*externalLib); ok {
+				libs = append(libs, el.outputs()...)
+				libs = append(libs, el.exportLdlibs()...)
+				ldflags = append(ldflags, el.exportLdflags()...)
+			} else {
+				panic(errors.New(ctx.OtherModuleName(m) + " is not a shared library"))
+			}
+		})
+
+	if hasForwardingLib {
+		libs = append(libs, linker.getForwardingLibFlags())
+	}
+	return
+}
+
+// Write the link options and libraries of a shared library or binary
+func (g *cmakeGenerator) writeLinkOptions(sb *strings.Builder, l *library, name string, ctx blueprint.ModuleContext) {
+	tc := g.getToolchain(l.Properties.TargetType)
+	linker := tc.getLinker()
+
+	ldflags := utils.NewStringSlice(linker.getFlags(), l.Properties.Ldflags, l.getSanitizerLdflags(tc),
+		l.getLtoLdflags(tc))
+	if l.Properties.Build.isForwardingSharedLibrary() {
+		ldflags = append(ldflags, linker.keepUnusedDependencies())
+	} else {
+		ldflags = append(ldflags, linker.dropUnusedDependencies())
+	}
+
+	versionScript := l.getVersionScript(ctx)
+	if versionScript != nil {
+		ldflags = append(ldflags, linker.setVersionScript(*versionScript))
+	}
+
+	libs := []string{}
+	if wholeStaticLibs := g.getWholeStaticLibs(ctx); len(wholeStaticLibs) > 0 {
+		libs = append(libs, strings.Fields(linker.linkWholeArchives(wholeStaticLibs))...)
+	}
+	libs = append(libs, g.getStaticLibs(l, ctx)...)
+
+	sharedLibs, sharedLdflags, libPaths := g.getSharedLibs(l, ctx)
+	libs = append(libs, sharedLibs...)
+	libs = append(libs, l.Properties.Ldlibs...)
+	libs = append(libs, linker.getLibs()...)
+	ldflags = append(ldflags, sharedLdflags...)
+
+	ldflags = utils.Filter(func(s string) bool { return s != "" }, ldflags)
+	if len(ldflags) > 0 {
+		cmakeWriteCommand(sb, "target_link_options",
+			append([]string{cmakeQuote(name), "PRIVATE"}, cmakeQuoteAll(ldflags)...)...)
+	}
+
+	libs = utils.Filter(func(s string) bool { return s != "" }, libs)
+	if len(libs) > 0 {
+		cmakeWriteCommand(sb, "target_link_libraries",
+			append([]string{cmakeQuote(name), "PRIVATE"}, cmakeQuoteAll(libs)...)...)
+	}
+
+	if l.Properties.isRpathWanted() {
+		if installPath, ok := l.Properties.InstallableProps.getInstallPath(); ok {
+			var rpaths []string
+			for _, path := range libPaths {
+				out, err := filepath.Rel(installPath, path)
+				if err != nil {
+					panic(fmt.Errorf("Could not find relative path for: %s due to: %s", path, err))
+				}
+				rpaths = append(rpaths, "$ORIGIN/"+out)
+			}
+			cmakeWriteCommand(sb, "set_target_properties", cmakeQuote(name), "PROPERTIES",
+				"INSTALL_RPATH", cmakeQuote(strings.Join(rpaths, ";")))
+		}
+	}
+}
+
+// Write the properties controlling where a target's output goes, and
+// what it is called. props is a list of property name and value pairs.
+func cmakeOutputProperties(sb *strings.Builder, name string, props ...string) {
+	args := []string{cmakeQuote(name), "PROPERTIES"}
+	for i := 0; i+1 < len(props); i += 2 {
+		args = append(args, props[i], cmakeQuote(props[i+1]))
+	}
+	cmakeWriteCommand(sb, "set_target_properties", args...)
+}
+
+// Write the add_library or add_executable command for a library,
+// followed by its compile options.
+func (g *cmakeGenerator) addTarget(sb *strings.Builder, l *library, command, kind string,
+	optional bool, ctx blueprint.ModuleContext) {
+
+	name := l.shortName()
+	args := []string{cmakeQuote(name)}
+	if kind != "" {
+		args = append(args, kind)
+	}
+	if optional {
+		args = append(args, "EXCLUDE_FROM_ALL")
+	}
+	// CMake links the objects of custom commands listed as sources
+	objects := g.writeCompilerRuleCommands(sb, l, ctx)
+	args = append(args, cmakeQuoteAll(g.getSrcs(l, ctx))...)
+	args = append(args, cmakeQuoteAll(objects)...)
+	cmakeWriteCommand(sb, command, args...)
+
+	g.writeCompileOptions(sb, l, name, ctx)
+}
+
+func (g *cmakeGenerator) headerActions(m *headerLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	// There is nothing to build, but the target depends on any
+	// headers the library uses or exports
+	cmakeCustomTarget(sb, name, nil, isBuiltByDefault(m))
+	cmakeAddDependencies(sb, name, ctx)
+
+	cmakeAddFragment(name, sb)
+}
+
+func (g *cmakeGenerator) staticActions(m *staticLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	// Calculate and record outputs
+	m.outputdir = g.staticLibOutputDir(m.Properties.TargetType)
+	m.outs = []string{filepath.Join(m.outputDir(), m.outputFileName())}
+
+	g.addTarget(sb, &m.library, "add_library", "STATIC", !isBuiltByDefault(m), ctx)
+	cmakeOutputProperties(sb, name,
+		"PREFIX", "",
+		"OUTPUT_NAME", m.outputName(),
+		"SUFFIX", ".a",
+		"ARCHIVE_OUTPUT_DIRECTORY", m.outputDir())
+
+	// The archiver can only be set for the whole project, so static
+	// libraries built for LTO are archived again after they have been
+	// created, with an archiver that can index their objects.
+	ar := "${CMAKE_AR}"
+	lto := m.Properties.getLtoMode() != ""
+	if lto {
+		ar, _ = g.getToolchain(m.Properties.TargetType).getLtoArchiver()
+	}
+
+	// Whole static libraries are merged into the archive after it has
+	// been created, using the same script as the Linux backend.
+	if wholeStaticLibs := g.getWholeStaticLibs(ctx); len(wholeStaticLibs) > 0 || lto {
+		archives := []string{}
+		for _, lib := range wholeStaticLibs {
+			if cmakeHasTarget(lib) {
+				lib = "$<TARGET_FILE:" + lib + ">"
+			}
+			archives = append(archives, lib)
+		}
+		tmp := m.outputs()[0] + ".tmp"
+		args := []string{cmakeQuote(name), "POST_BUILD",
+			"COMMAND", "python", cmakeQuote(getBackendPathInBobScriptsDir(g, "whole_static.py")),
+			"--ar", cmakeQuote(ar), "--out", cmakeQuote(tmp), cmakeQuote(m.outputs()[0])}
+		args = append(args, cmakeQuoteAll(archives)...)
+		args = append(args, "COMMAND", "${CMAKE_COMMAND}", "-E", "rename",
+			cmakeQuote(tmp), cmakeQuote(m.outputs()[0]), "VERBATIM")
+		cmakeWriteCommand(sb, "add_custom_command", append([]string{"TARGET"}, args...)...)
+	}
+
+	// Static libraries are not linked, so their dependencies are only
+	// needed for ordering (e.g. generated headers). Using
+	// target_link_libraries would also propagate them to every user,
+	// changing the link order chosen by ResolvedStaticLibs.
+	cmakeAddDependencies(sb, name, ctx)
+	g.install(sb, m, ctx, name)
+	cmakeAddFragment(name, sb)
+}
+
+func (g *cmakeGenerator) sharedActions(m *sharedLibrary, ctx blueprint.ModuleContext) {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	// Calculate and record outputs
+	m.outputdir = g.sharedLibsDir(m.Properties.TargetType)
+	m.outs = []string{filepath.Join(m.outputDir(), m.getRealName())}
+
+	g.addTarget(sb, &m.library, "add_library", "SHARED", !isBuiltByDefault(m), ctx)
+
+	props := []string{
+		"PREFIX", "",
+		"OUTPUT_NAME", m.outputName(),
+		"SUFFIX", m.fileNameExtension,
+		"LIBRARY_OUTPUT_DIRECTORY", m.outputDir(),
+	}
+	if version := m.Properties.Library_version; version != "" {
+		// CMake creates the same link name and soname symlinks as
+		// librarySymlinks() describes.
+		props = append(props, "VERSION", version,
+			"SOVERSION", strings.Split(version, ".")[0])
+	}
+	cmakeOutputProperties(sb, name, props...)
+
+	g.writeLinkOptions(sb, &m.library, name, ctx)
+	cmakeAddDependencies(sb, name, ctx)
+	g.install(sb, m, ctx, name)
+	cmakeAddFragment(name, sb)
+}
+
+// Write the targets for a binary, without adding them to the output
+func (g *cmakeGenerator) binaryFragment(m *binary, ctx blueprint.ModuleContext) *strings.Builder {
+	sb := &strings.Builder{}
+	name := m.shortName()
+
+	// Calculate and record outputs
+	m.outputdir = g.binaryOutputDir(m.Properties.TargetType)
+	m.outs = []string{filepath.Join(m.outputDir(), m.outputName())}
+
+	g.addTarget(sb, &m.library, "add_executable", "", !isBuiltByDefault(m), ctx)
+	cmakeOutputProperties(sb, name,
+		"OUTPUT_NAME", m.outputName(),
+		"RUNTIME_OUTPUT_DIRECTORY", m.outputDir())
+
+	g.writeLinkOptions(sb, &m.library, name, ctx)
+	cmakeAddDependencies(sb, name, ctx)
+	g.install(sb, m, ctx, name)
+
+	return sb
+}
+
+func (g *cmakeGenerator) binaryActions(m *binary, ctx blueprint.ModuleContext) {
+	cmakeAddFragment(m.shortName(), g.binaryFragment(m, ctx))
+}
+
+func (g *cmakeGenerator) testActions(m *test, ctx blueprint.ModuleContext) {
+	sb := g.binaryFragment(&m.binary, ctx)
+	name := m.shortName()
+
+	// Tests are run by CTest, rather than a `check` target. As on
+	// Linux, target tests are only registered when there is a wrapper to
+	// run them with.
+	wrapper, runnable := getTestWrapper(ctx, m.getTarget())
+	if !runnable {
+		cmakeAddFragment(name, sb)
+		return
+	}
+
+	command := []string{cmakeQuote(name)}
+	if wrapper != "" {
+		command = append(cmakeQuoteAll(strings.Fields(wrapper)), cmakeQuote("$<TARGET_FILE:"+name+">"))
+	}
+	cmakeWriteCommand(sb, "add_test",
+		utils.NewStringSlice([]string{"NAME", cmakeQuote(name), "COMMAND"}, command,
+			cmakeQuoteAll(m.Properties.Test_args))...)
+	if m.Properties.Timeout != nil {
+		cmakeWriteCommand(sb, "set_tests_properties", cmakeQuote(name), "PROPERTIES",
+			"TIMEOUT", strconv.FormatInt(*m.Properties.Timeout, 10))
+	}
+
+	cmakeAddFragment(name, sb)
+}
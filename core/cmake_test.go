@@ -0,0 +1,65 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cmakeQuote(t *testing.T) {
+	assert.Equal(t, `"-DFOO=\"a b\""`, cmakeQuote(`-DFOO="a b"`))
+	assert.Equal(t, `"a\;b"`, cmakeQuote("a;b"))
+	assert.Equal(t, `"\\n"`, cmakeQuote(`\n`))
+	assert.Equal(t, `"\$HOME"`, cmakeQuote("$HOME"))
+	assert.Equal(t, `"\${UNKNOWN}/x"`, cmakeQuote("${UNKNOWN}/x"))
+	assert.Equal(t, `"${BOB_SOURCE_DIR}/src/a.c"`, cmakeQuote("${BOB_SOURCE_DIR}/src/a.c"))
+	assert.Equal(t, `"$<TARGET_FILE:libfoo>"`, cmakeQuote("$<TARGET_FILE:libfoo>"))
+}
+
+func Test_cmakeExpandCommand(t *testing.T) {
+	args := map[string]string{
+		"in":   "a.c b.c",
+		"out":  "out.c",
+		"tool": "gen.py",
+	}
+
+	assert.Equal(t, "gen.py --in a.c b.c -o out.c",
+		cmakeExpandCommand("${tool} --in ${in} -o $out", args))
+	assert.Equal(t, "echo $PATH ${unknown}",
+		cmakeExpandCommand("echo $$PATH ${unknown}", args))
+}
+
+func Test_cmakeLanguageFlags(t *testing.T) {
+	assert.Equal(t,
+		[]string{
+			"$<$<COMPILE_LANGUAGE:CXX>:-std=c++11>",
+			"$<$<COMPILE_LANGUAGE:CXX>:-Wl$<COMMA>-z>",
+		},
+		cmakeLanguageFlags("CXX", []string{"-std=c++11", "-Wl,-z"}))
+}
+
+func Test_cmakeWriteCommand(t *testing.T) {
+	sb := &strings.Builder{}
+	cmakeWriteCommand(sb, "add_dependencies", `"a"`, `"b"`)
+	cmakeWriteCommand(sb, "enable_testing")
+
+	assert.Equal(t, "add_dependencies(\"a\"\n    \"b\")\nenable_testing()\n", sb.String())
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// The languages of the sources compiled by the built-in rules
+type sourceLanguage int
+
+const (
+	langNone sourceLanguage = iota
+	// Assembly, passed directly to the assembler
+	langAsm
+	// Assembly which is preprocessed by the C compiler
+	langAsmCpp
+	langC
+	langCxx
+)
+
+// The extensions of the sources compiled by the built-in rules. These
+// must also be matched by utils.IsCompilableSource, which is used
+// by the Android backends.
+var builtinSourceLanguages = map[string]sourceLanguage{
+	".s":   langAsm,
+	".S":   langAsmCpp,
+	".c":   langC,
+	".cc":  langCxx,
+	".cpp": langCxx,
+	".cxx": langCxx,
+	".c++": langCxx,
+	".C":   langCxx,
+}
+
+// Returns the language of a source compiled by a built-in rule, or
+// langNone when no built-in rule compiles it
+func getSourceLanguage(src string) sourceLanguage {
+	return builtinSourceLanguages[path.Ext(src)]
+}
+
+// The module flag properties a compiler rule can pass to its tool
+var compilerRuleModuleFlags = []string{"asflags", "cflags", "conlyflags", "cxxflags"}
+
+// CompilerRuleProps describes the properties of the bob_compiler_rule
+// module
+type CompilerRuleProps struct {
+	// The extensions, including the leading `.`, of the sources this
+	// rule compiles
+	Extensions []string
+	// The compiler to run. This is looked up in PATH, unless it is an
+	// absolute path.
+	Tool *string
+	// Flags always passed to the compiler
+	Flags []string
+	// The flag properties of the module being compiled which are also
+	// passed to the compiler. These can be `asflags`, `cflags`,
+	// `conlyflags` and `cxxflags`. Note that `cflags` includes the
+	// module's include directories.
+	Module_flags []string
+	// The format of the dependency files written by the compiler. This
+	// can be `gcc`, for Makefile style dependencies, or `none`.
+	Depfile_format *string
+	// Flags making the compiler write a dependency file. `${depfile}`
+	// is replaced with its path.
+	Depfile_flags []string
+}
+
+// Type representing each bob_compiler_rule module
+type compilerRule struct {
+	moduleBase
+	Properties struct {
+		CompilerRuleProps
+		Features
+	}
+}
+
+func (m *compilerRule) features() *Features {
+	return &m.Properties.Features
+}
+
+func (m *compilerRule) featurableProperties() []interface{} {
+	return []interface{}{&m.Properties.CompilerRuleProps}
+}
+
+// Returns whether the compiler writes Makefile style dependency files
+func (props *CompilerRuleProps) hasDepfile() bool {
+	return proptools.String(props.Depfile_format) == "gcc"
+}
+
+// Check the properties are consistent, returning the name of the
+// property in error
+func (props *CompilerRuleProps) validate() (string, error) {
+	if len(props.Extensions) == 0 {
+		return "extensions", fmt.Errorf("must list at least one extension")
+	}
+	for _, ext := range props.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			return "extensions", fmt.Errorf("%s must start with '.'", ext)
+		}
+		if _, ok := builtinSourceLanguages[ext]; ok {
+			return "extensions", fmt.Errorf("%s is compiled by a built-in rule", ext)
+		}
+	}
+	if proptools.String(props.Tool) == "" {
+		return "tool", fmt.Errorf("must be set")
+	}
+	for _, flags := range props.Module_flags {
+		if !utils.Contains(compilerRuleModuleFlags, flags) {
+			return "module_flags", fmt.Errorf("%s is not one of asflags, cflags, conlyflags or cxxflags", flags)
+		}
+	}
+	switch proptools.String(props.Depfile_format) {
+	case "gcc":
+		if len(props.Depfile_flags) == 0 {
+			return "depfile_flags", fmt.Errorf("must be set when depfile_format is gcc")
+		}
+	case "", "none":
+		if len(props.Depfile_flags) > 0 {
+			return "depfile_flags", fmt.Errorf("must not be set without a depfile_format")
+		}
+	default:
+		return "depfile_format", fmt.Errorf("must be gcc or none, not %s", *props.Depfile_format)
+	}
+	return "", nil
+}
+
+// Returns the flags to pass to the compiler, given the values of the
+// module's flag properties and the path of its dependency file
+func (props *CompilerRuleProps) getFlags(moduleFlags map[string]string, depfile string) []string {
+	flags := append([]string{}, props.Flags...)
+	for _, f := range props.Module_flags {
+		flags = append(flags, moduleFlags[f])
+	}
+	for _, f := range props.Depfile_flags {
+		flags = append(flags, strings.Replace(f, "${depfile}", depfile, -1))
+	}
+	return flags
+}
+
+// The compiler rules, indexed by the extensions they compile
+var compilerRules = map[string]*compilerRule{}
+var compilerRulesLock sync.Mutex
+
+// Returns the compiler rule which compiles a source, if there is one
+func getCompilerRule(src string) (*compilerRule, bool) {
+	compilerRulesLock.Lock()
+	defer compilerRulesLock.Unlock()
+	rule, ok := compilerRules[path.Ext(src)]
+	return rule, ok
+}
+
+// Compiler rules do not build anything themselves
+func (m *compilerRule) GenerateBuildActions(ctx blueprint.ModuleContext) {
+}
+
+// Create the structure representing the bob_compiler_rule
+func compilerRuleFactory(config *bobConfig) (blueprint.Module, []interface{}) {
+	module := &compilerRule{}
+	module.Properties.Features.Init(&config.Properties, CompilerRuleProps{})
+	return module, []interface{}{&module.Properties, &module.SimpleName.Properties}
+}
+
+// Register each compiler rule against its extensions, so that any
+// module's sources with these extensions are compiled with it
+func compilerRulesMutator(mctx blueprint.BottomUpMutatorContext) {
+	m, ok := mctx.Module().(*compilerRule)
+	if !ok {
+		return
+	}
+
+	if property, err := m.Properties.validate(); err != nil {
+		propertyErrorf(mctx, property, "%s", err)
+		return
+	}
+
+	compilerRulesLock.Lock()
+	defer compilerRulesLock.Unlock()
+
+	for _, ext := range m.Properties.Extensions {
+		if other, ok := compilerRules[ext]; ok && other != m {
+			propertyErrorf(mctx, "extensions", "%s is already compiled by %s",
+				ext, other.Name())
+			continue
+		}
+		compilerRules[ext] = m
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+func Test_builtinSourcesAreCompilable(t *testing.T) {
+	// The Android backends rely on utils.IsCompilableSource
+	for ext := range builtinSourceLanguages {
+		assert.True(t, utils.IsCompilableSource("src/a"+ext), ext)
+	}
+	assert.Equal(t, langCxx, getSourceLanguage("src/a.c++"))
+	assert.Equal(t, langNone, getSourceLanguage("src/a.asm"))
+}
+
+func Test_compilerRuleValidate(t *testing.T) {
+	valid := func() *CompilerRuleProps {
+		return &CompilerRuleProps{
+			Extensions:     []string{".asm"},
+			Tool:           proptools.StringPtr("nasm"),
+			Module_flags:   []string{"asflags"},
+			Depfile_format: proptools.StringPtr("gcc"),
+			Depfile_flags:  []string{"-MD", "${depfile}"},
+		}
+	}
+
+	property, err := valid().validate()
+	assert.Equal(t, "", property)
+	assert.Nil(t, err)
+
+	props := valid()
+	props.Extensions = []string{".cxx"}
+	property, err = props.validate()
+	assert.Equal(t, "extensions", property, "Built-in extensions cannot be overridden")
+	assert.NotNil(t, err)
+
+	props = valid()
+	props.Extensions = []string{"asm"}
+	property, _ = props.validate()
+	assert.Equal(t, "extensions", property)
+
+	props = valid()
+	props.Tool = nil
+	property, _ = props.validate()
+	assert.Equal(t, "tool", property)
+
+	props = valid()
+	props.Module_flags = []string{"ldflags"}
+	property, _ = props.validate()
+	assert.Equal(t, "module_flags", property)
+
+	props = valid()
+	props.Depfile_flags = nil
+	property, _ = props.validate()
+	assert.Equal(t, "depfile_flags", property)
+
+	props = valid()
+	props.Depfile_format = proptools.StringPtr("msvc")
+	property, _ = props.validate()
+	assert.Equal(t, "depfile_format", property)
+}
+
+func Test_compilerRuleFlags(t *testing.T) {
+	props := &CompilerRuleProps{
+		Flags:         []string{"-f", "elf64"},
+		Module_flags:  []string{"asflags", "cflags"},
+		Depfile_flags: []string{"-MD", "${depfile}"},
+	}
+	moduleFlags := map[string]string{"asflags": "$asflags", "cflags": "$cflags"}
+
+	assert.Equal(t, []string{"-f", "elf64", "$asflags", "$cflags", "-MD", "out/a.o.d"},
+		props.getFlags(moduleFlags, "out/a.o.d"))
+}
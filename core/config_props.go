@@ -165,5 +165,5 @@ func (properties *configProperties) LoadConfig(filename string) error {
 	// Calculate the plain list of features once.
 	properties.featureList = utils.SortedKeysBoolMap(properties.features)
 
-	return nil
+	return checkFeatureNames(properties.featureList)
 }
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+)
+
+// CoverageProps defines properties used to instrument modules for code
+// coverage. These only have an effect when the COVERAGE option is
+// enabled.
+type CoverageProps struct {
+	// Instrument this module to record which code it executes. When
+	// set on a binary or shared library, the static libraries it links
+	// are also instrumented, unless they set it themselves.
+	Coverage *bool
+	// Also instrument sources generated by other modules. These are
+	// excluded by default.
+	Coverage_generated_sources *bool
+
+	// Set on a static library linked by an instrumented module
+	InheritedCoverage bool `blueprint:"mutated"`
+	// Whether this module's objects are instrumented
+	CoverageInstrumented bool `blueprint:"mutated"`
+	// Whether a binary or shared library links any instrumented
+	// objects, and so needs the coverage runtime
+	LinkCoverage bool `blueprint:"mutated"`
+}
+
+// Protects InheritedCoverage, which is set on a static library by every
+// module that links it
+var coverageLock sync.Mutex
+
+// Returns the directory Clang's instrumented programs write their
+// profiles to. This must be absolute, as the programs may be run from
+// anywhere.
+func coverageProfileDir(tgt tgtType) string {
+	return filepath.Join(absPath(getBuildDir()), "coverage", string(tgt), "profiles")
+}
+
+// Returns the directory the coverage report for a target type is
+// written to
+func coverageReportDir(tgt tgtType) string {
+	return filepath.Join("${BuildDir}", "coverage", string(tgt))
+}
+
+// Returns the flags needed to compile this module's instrumented sources
+func (l *library) getCoverageCflags(tc toolchain) []string {
+	if !l.Properties.CoverageInstrumented {
+		return []string{}
+	}
+	// Unsupported toolchains were reported by coverageMutator
+	cflags, _, _ := tc.getCoverageFlags(coverageProfileDir(l.Properties.TargetType))
+	return cflags
+}
+
+// Returns the flags needed to link the coverage runtime into a binary
+// or shared library
+func (l *library) getCoverageLdflags(tc toolchain) []string {
+	if !l.Properties.LinkCoverage {
+		return []string{}
+	}
+	_, ldflags, _ := tc.getCoverageFlags(coverageProfileDir(l.Properties.TargetType))
+	return ldflags
+}
+
+// Returns whether a source file of this module should be instrumented
+func (l *library) instrumentsSource(generated bool) bool {
+	return l.Properties.CoverageInstrumented &&
+		(!generated || proptools.Bool(l.Properties.Coverage_generated_sources))
+}
+
+// Decide which modules are instrumented for coverage. Modules visit
+// the static libraries they link, so that they are instrumented too.
+// This is only registered when the COVERAGE option is enabled.
+func coverageMutator(mctx blueprint.TopDownMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	props := &l.Properties.CoverageProps
+	if props.Coverage != nil {
+		props.CoverageInstrumented = *props.Coverage
+	} else {
+		// Every module linking this one has already been visited
+		props.CoverageInstrumented = props.InheritedCoverage
+	}
+
+	if !props.CoverageInstrumented {
+		return
+	}
+
+	if props.Coverage != nil {
+		tc := getBackend(mctx).getToolchain(l.Properties.TargetType)
+		if _, _, err := tc.getCoverageFlags(coverageProfileDir(l.Properties.TargetType)); err != nil {
+			propertyErrorf(mctx, "coverage", "%s", err)
+			props.CoverageInstrumented = false
+			return
+		}
+	}
+
+	if _, ok := getBinaryOrSharedLib(mctx.Module()); !ok {
+		return
+	}
+
+	modulesToVisit := getLinkableModules(mctx)
+	mctx.VisitDepsDepthFirst(func(dep blueprint.Module) {
+		if _, ok := modulesToVisit[dep]; !ok {
+			return
+		}
+		if depLib, ok := dep.(*staticLibrary); ok {
+			coverageLock.Lock()
+			defer coverageLock.Unlock()
+
+			depLib.Properties.InheritedCoverage = true
+		}
+	})
+}
+
+// Find the binaries and shared libraries which link instrumented
+// objects. This includes modules which are not instrumented themselves,
+// but link a static library which another module instrumented.
+func coverageLinkMutator(mctx blueprint.TopDownMutatorContext) {
+	l, ok := getBinaryOrSharedLib(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	link := l.Properties.CoverageInstrumented
+
+	modulesToVisit := getLinkableModules(mctx)
+	mctx.VisitDepsDepthFirst(func(dep blueprint.Module) {
+		if _, ok := modulesToVisit[dep]; !ok {
+			return
+		}
+		if depLib, ok := dep.(*staticLibrary); ok && depLib.Properties.CoverageInstrumented {
+			link = true
+		}
+	})
+
+	l.Properties.LinkCoverage = link
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getCoverageFlags(t *testing.T) {
+	cflags, ldflags, err := toolchainGnuCommon{}.getCoverageFlags("/out/profiles")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--coverage"}, cflags)
+	assert.Equal(t, []string{"--coverage"}, ldflags)
+
+	for _, tc := range []toolchain{toolchainClangCommon{}, toolchainXcode{}} {
+		cflags, ldflags, err = tc.getCoverageFlags("/out/profiles")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"-fprofile-instr-generate=/out/profiles/%m.profraw", "-fcoverage-mapping"}, cflags)
+		assert.Equal(t, []string{"-fprofile-instr-generate=/out/profiles/%m.profraw"}, ldflags)
+	}
+
+	_, _, err = toolchainArmClang{}.getCoverageFlags("/out/profiles")
+	assert.Error(t, err, "Arm Compiler should not support coverage")
+}
+
+func Test_instrumentsSource(t *testing.T) {
+	l := library{}
+	assert.False(t, l.instrumentsSource(false))
+
+	l.Properties.CoverageInstrumented = true
+	assert.True(t, l.instrumentsSource(false))
+	assert.False(t, l.instrumentsSource(true), "Generated sources should be excluded by default")
+
+	l.Properties.Coverage_generated_sources = proptools.BoolPtr(true)
+	assert.True(t, l.instrumentsSource(true))
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/scanner"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/parser"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// Mistakes in build definitions are recorded as diagnostics rather than
+// being reported straight away. Blueprint stops at the end of any
+// mutator which reports an error, so deferring them lets a single run
+// report every problem found by Bob's checks. The recorded diagnostics
+// are passed to Blueprint's ModuleErrorf and PropertyErrorf by
+// reportDiagnosticsMutator.
+//
+// When -diagnostics-json or BOB_DIAGNOSTICS_JSON is set, the diagnostics
+// are also written to the named file as a JSON array.
+
+// A diagnostic describes a problem with a module definition
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Module   string `json:"module"`
+	Property string `json:"property,omitempty"`
+	Message  string `json:"message"`
+}
+
+var (
+	diagnosticsJSONFile string
+
+	diagnosticsLock sync.Mutex
+	// All diagnostics, in the order they were recorded
+	diagnostics = []diagnostic{}
+	// Diagnostics not yet passed to Blueprint, by module name
+	pendingDiagnostics = map[string][]diagnostic{}
+	// Number of diagnostics written to diagnosticsJSONFile, or -1 if
+	// it has not been written yet
+	diagnosticsWritten = -1
+	// Parsed build.bp files, used to find line numbers
+	parsedBlueprints = map[string]*parser.File{}
+)
+
+func init() {
+	// The build runs Bob without extra arguments, so the environment
+	// variable is used when the flag is not given
+	flag.StringVar(&diagnosticsJSONFile, "diagnostics-json", os.Getenv("BOB_DIAGNOSTICS_JSON"),
+		"Also write errors in build definitions to the named file as JSON")
+}
+
+// Records an error in the definition of the current module
+func moduleErrorf(ctx blueprint.BaseModuleContext, format string, args ...interface{}) {
+	recordDiagnostic(ctx, "", fmt.Sprintf(format, args...))
+}
+
+// Prints a warning about a property of the current module. Warnings
+// don't stop the build, so are printed straight away rather than
+// recorded.
+func propertyWarningf(ctx blueprint.BaseModuleContext, property, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s: warning: %s: %s\n", definitionLocation(ctx, property),
+		ctx.ModuleName(), fmt.Sprintf(format, args...))
+}
+
+// Records an error in a property of the current module
+func propertyErrorf(ctx blueprint.BaseModuleContext, property, format string, args ...interface{}) {
+	recordDiagnostic(ctx, property, fmt.Sprintf(format, args...))
+}
+
+func recordDiagnostic(ctx blueprint.BaseModuleContext, property, message string) {
+	d := diagnostic{
+		File:     ctx.BlueprintsFile(),
+		Module:   ctx.ModuleName(),
+		Property: property,
+		Message:  message,
+	}
+
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	// Each variant of a module finds the same problems, so only
+	// keep the first
+	for _, existing := range diagnostics {
+		if existing.File == d.File && existing.Module == d.Module &&
+			existing.Property == d.Property && existing.Message == d.Message {
+			return
+		}
+	}
+
+	if diagnosticsJSONFile != "" {
+		pos := findDefinition(d.File, d.Module, d.Property)
+		d.Line = pos.Line
+		d.Column = pos.Column
+	}
+
+	diagnostics = append(diagnostics, d)
+	pendingDiagnostics[d.Module] = append(pendingDiagnostics[d.Module], d)
+}
+
+// Passes the recorded diagnostics for each module to Blueprint, which
+// reports them with the location of the module or property and stops
+// at the end of the mutator.
+func reportDiagnosticsMutator(mctx blueprint.BottomUpMutatorContext) {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	writeDiagnosticsJSON()
+
+	for _, d := range pendingDiagnostics[mctx.ModuleName()] {
+		if d.Property != "" {
+			mctx.PropertyErrorf(d.Property, "%s", d.Message)
+		} else {
+			mctx.ModuleErrorf("%s", d.Message)
+		}
+	}
+	delete(pendingDiagnostics, mctx.ModuleName())
+}
+
+// Writes all the diagnostics recorded so far to the JSON file, if
+// requested. This is also used to clear the file at startup.
+//
+// Must be called with diagnosticsLock held.
+func writeDiagnosticsJSON() {
+	if diagnosticsJSONFile == "" || diagnosticsWritten == len(diagnostics) {
+		return
+	}
+
+	sorted := append([]diagnostic{}, diagnostics...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].File != sorted[j].File {
+			return sorted[i].File < sorted[j].File
+		}
+		return sorted[i].Line < sorted[j].Line
+	})
+
+	text, err := json.MarshalIndent(sorted, "", "    ")
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+	err = ioutil.WriteFile(diagnosticsJSONFile, append(text, '\n'), 0644)
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+	diagnosticsWritten = len(diagnostics)
+}
+
+func initDiagnostics() {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	writeDiagnosticsJSON()
+}
+
+// Returns the location of a module or property definition as
+// "file:line", for use in messages
+func definitionLocation(ctx blueprint.BaseModuleContext, property string) string {
+	return propertyLocation(ctx.BlueprintsFile(), ctx.ModuleName(), property)
+}
+
+// Like definitionLocation, for a module other than the current one
+func propertyLocation(file, moduleName, property string) string {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	pos := findDefinition(file, moduleName, property)
+	if pos.Line == 0 {
+		return file
+	}
+	return fmt.Sprintf("%s:%d", file, pos.Line)
+}
+
+// Returns the value of a module's name property
+func moduleDefinitionName(m *parser.Module) string {
+	for _, prop := range m.Properties {
+		if prop.Name == "name" {
+			if s, ok := prop.Value.Eval().(*parser.String); ok {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the position of a module's property in a parsed build.bp.
+// Nested properties are separated by '.', e.g. "host.cflags". If the
+// property is not set in the module, the closest enclosing definition
+// is used instead.
+func findPropertyPos(file *parser.File, moduleName, property string) scanner.Position {
+	for _, def := range file.Defs {
+		m, ok := def.(*parser.Module)
+		if !ok || moduleDefinitionName(m) != moduleName {
+			continue
+		}
+
+		pos := m.TypePos
+		if property == "" {
+			return pos
+		}
+
+		props := m.Properties
+		for _, name := range strings.Split(property, ".") {
+			var found *parser.Property
+			for _, prop := range props {
+				if prop.Name == name {
+					found = prop
+					break
+				}
+			}
+			if found == nil {
+				break
+			}
+
+			pos = found.NamePos
+			child, ok := found.Value.Eval().(*parser.Map)
+			if !ok {
+				break
+			}
+			props = child.Properties
+		}
+		return pos
+	}
+
+	return scanner.Position{}
+}
+
+// Finds the position of a module or property definition, parsing the
+// build.bp if it has not already been read.
+//
+// Must be called with diagnosticsLock held.
+func findDefinition(filename, moduleName, property string) scanner.Position {
+	file, ok := parsedBlueprints[filename]
+	if !ok {
+		path := filename
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(getSourceDir(), path)
+		}
+
+		if f, err := os.Open(path); err == nil {
+			var errs []error
+			file, errs = parser.ParseAndEval(filename, f, parser.NewScope(nil))
+			f.Close()
+			if len(errs) > 0 {
+				file = nil
+			}
+		}
+		parsedBlueprints[filename] = file
+	}
+
+	if file == nil {
+		return scanner.Position{}
+	}
+	return findPropertyPos(file, moduleName, property)
+}
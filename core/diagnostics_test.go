@@ -0,0 +1,69 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func pos(line, column int) scanner.Position {
+	return scanner.Position{Filename: "build.bp", Line: line, Column: column}
+}
+
+// Build the parsed form of:
+//
+//	bob_static_library {
+//	    name: "libfoo",
+//	    host: {
+//	        cflags: ["-DHOST"],
+//	    },
+//	}
+func testBlueprintsFile() *parser.File {
+	cflags := &parser.Property{Name: "cflags", NamePos: pos(4, 9),
+		Value: &parser.String{LiteralPos: pos(4, 17), Value: "-DHOST"}}
+	host := &parser.Property{Name: "host", NamePos: pos(3, 5),
+		Value: &parser.Map{LBracePos: pos(3, 11), Properties: []*parser.Property{cflags}}}
+	name := &parser.Property{Name: "name", NamePos: pos(2, 5),
+		Value: &parser.String{LiteralPos: pos(2, 11), Value: "libfoo"}}
+
+	module := &parser.Module{
+		Type:    "bob_static_library",
+		TypePos: pos(1, 1),
+		Map:     parser.Map{Properties: []*parser.Property{name, host}},
+	}
+
+	return &parser.File{Name: "build.bp", Defs: []parser.Definition{module}}
+}
+
+func Test_findPropertyPos(t *testing.T) {
+	file := testBlueprintsFile()
+
+	assert.Equal(t, pos(1, 1), findPropertyPos(file, "libfoo", ""))
+	assert.Equal(t, pos(3, 5), findPropertyPos(file, "libfoo", "host"))
+	assert.Equal(t, pos(4, 9), findPropertyPos(file, "libfoo", "host.cflags"))
+
+	// Properties which are not set fall back to the closest definition
+	assert.Equal(t, pos(3, 5), findPropertyPos(file, "libfoo", "host.ldflags"))
+	assert.Equal(t, pos(1, 1), findPropertyPos(file, "libfoo", "srcs"))
+
+	assert.Equal(t, scanner.Position{}, findPropertyPos(file, "libbar", ""))
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"reflect"
+
+	"github.com/google/blueprint"
+)
+
+// When a module is disabled, the reason is recorded in
+// EnableableProps.DisabledReason. For modules disabled by a property
+// this names the setting responsible and where it is defined, e.g.
+//
+//   disabled by feature `no_foo` in lib/build.bp:12
+//
+// Modules disabled because a dependency is disabled extend the
+// dependency's reason, so the chain leads back to the root cause:
+//
+//   disabled because dep `libfoo` is disabled by feature `no_foo` in lib/build.bp:12
+
+// Returns the EnableableProps of a module, including defaults, or nil
+// if the module cannot be disabled
+func enableablePropsOf(m blueprint.Module) *EnableableProps {
+	if d, ok := m.(*defaults); ok {
+		return &d.Properties.EnableableProps
+	}
+	if e, ok := m.(enableable); ok {
+		return e.getEnableableProps()
+	}
+	return nil
+}
+
+// Returns the reason a disabled module is disabled
+func disabledReason(props *EnableableProps) string {
+	if props.DisabledReason != "" {
+		return props.DisabledReason
+	}
+	return "disabled"
+}
+
+// Records that the current module was disabled by the setting of the
+// given property, described by cause
+func setDisabledBy(ctx blueprint.BaseModuleContext, props *EnableableProps, cause, property string) {
+	props.DisabledReason = "disabled by " + cause + " in " + definitionLocation(ctx, property)
+}
+
+// Returns the value of the enabled property in a property structure,
+// or nil if it is not set
+func enabledProperty(props interface{}) *bool {
+	propsVal := reflect.Indirect(reflect.ValueOf(props))
+	if propsVal.Kind() != reflect.Struct {
+		return nil
+	}
+	field := propsVal.FieldByName("Enabled")
+	if !field.IsValid() || field.IsNil() {
+		return nil
+	}
+	if enabled, ok := field.Interface().(*bool); ok {
+		return enabled
+	}
+	return nil
+}
+
+// Returns the last enabled feature or matching condition block which
+// sets the enabled property, a description of it, and the value it
+// sets. Later blocks take precedence, so this is the one responsible
+// for the final value.
+func (f *Features) enabledSetByFeature(properties *configProperties) (block, desc string, enabled *bool) {
+	featuresData := reflect.ValueOf(f.BlueprintEmbed).Elem()
+
+	for _, featureKey := range properties.featureList {
+		if !properties.features[featureKey] {
+			continue
+		}
+		featureStruct := featuresData.FieldByName(featurePropertyName(featureKey))
+		if !featureStruct.IsValid() {
+			continue
+		}
+		if value := enabledProperty(featureStruct.FieldByName("BlueprintEmbed").Interface()); value != nil {
+			block, desc, enabled = featureKey, "feature `"+featureKey+"`", value
+		}
+	}
+
+	for i, field := range conditionBlocks.values(featuresData) {
+		cond := field.Interface().(singleCondition)
+		if matched, _ := cond.matches(properties); !matched {
+			continue
+		}
+		if value := enabledProperty(cond.BlueprintEmbed); value != nil {
+			block = conditionBlocks.propertyName(i + 1)
+			desc, enabled = "`"+block+"` (`"+*cond.When+"`)", value
+		}
+	}
+	return
+}
+
+// Called after features have been applied to the core properties, to
+// record whether a feature or the enabled property disabled the module.
+func recordDisabledByFeatures(mctx blueprint.BaseModuleContext, m featurable, properties *configProperties) {
+	props := enableablePropsOf(mctx.Module())
+	if props == nil || props.Enabled == nil || *props.Enabled {
+		return
+	}
+
+	if block, desc, enabled := m.features().enabledSetByFeature(properties); enabled != nil {
+		setDisabledBy(mctx, props, desc, block+".enabled")
+	} else {
+		setDisabledBy(mctx, props, "`enabled: false`", "enabled")
+	}
+}
+
+// Called after the target-specific properties have been applied, to
+// record whether the host: {} or target: {} block decided whether the
+// module is enabled.
+func recordDisabledByTarget(mctx blueprint.BaseModuleContext, ts targetSpecificLibrary,
+	tgt tgtType, properties *configProperties) {
+
+	props := enableablePropsOf(mctx.Module())
+	if props == nil {
+		return
+	}
+
+	tgtSpecific := ts.getTargetSpecific(tgt)
+	block, desc, enabled := tgtSpecific.Features.enabledSetByFeature(properties)
+	if enabled == nil {
+		enabled = enabledProperty(tgtSpecific.getTargetSpecificProps())
+	}
+
+	if enabled == nil {
+		return
+	} else if *enabled {
+		// Re-enabled for this variant
+		props.DisabledReason = ""
+	} else if block != "" {
+		setDisabledBy(mctx, props, desc+" in `"+string(tgt)+": {}`",
+			string(tgt)+"."+block+".enabled")
+	} else {
+		setDisabledBy(mctx, props, "`"+string(tgt)+": { enabled: false }`", string(tgt)+".enabled")
+	}
+}
+
+// Called when a defaults module has set the enabled property of the
+// current module to false.
+func recordDisabledByDefaults(mctx blueprint.BaseModuleContext, props *EnableableProps, def *defaults) {
+	props.DisabledReason = "disabled by defaults `" + def.Name() + "` in " +
+		definitionLocation(mctx, "defaults") + ", which is " +
+		disabledReason(&def.Properties.EnableableProps)
+}
+
+// Called when the current module is disabled because dep is disabled
+func recordDisabledByDependency(props *EnableableProps, dep blueprint.Module) {
+	reason := "disabled"
+	if depProps := enableablePropsOf(dep); depProps != nil {
+		reason = disabledReason(depProps)
+	}
+	props.DisabledReason = "disabled because dep `" + dep.Name() + "` is " + reason
+}
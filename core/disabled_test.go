@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_enabledProperty(t *testing.T) {
+	props := CommonProps{}
+	assert.Nil(t, enabledProperty(&props))
+
+	props.Enabled = proptools.BoolPtr(false)
+	assert.Equal(t, proptools.BoolPtr(false), enabledProperty(&props))
+
+	assert.Nil(t, enabledProperty(&BuildProps{}))
+}
+
+func Test_enabledSetByFeature(t *testing.T) {
+	properties := configProperties{
+		features:    map[string]bool{"feature_a": true, "feature_b": true, "feature_c": false},
+		featureList: []string{"feature_a", "feature_b", "feature_c"},
+	}
+
+	features := Features{}
+	features.Init(&properties, EnableableProps{})
+
+	feature, _, enabled := features.enabledSetByFeature(&properties)
+	assert.Equal(t, "", feature)
+	assert.Nil(t, enabled)
+
+	features.injectData(featurePropertyName("feature_a"), "Enabled", proptools.BoolPtr(false))
+	feature, _, enabled = features.enabledSetByFeature(&properties)
+	assert.Equal(t, "feature_a", feature)
+	assert.Equal(t, proptools.BoolPtr(false), enabled)
+
+	// Later features take precedence
+	features.injectData(featurePropertyName("feature_b"), "Enabled", proptools.BoolPtr(true))
+	feature, _, enabled = features.enabledSetByFeature(&properties)
+	assert.Equal(t, "feature_b", feature)
+	assert.Equal(t, proptools.BoolPtr(true), enabled)
+
+	// Disabled features are ignored
+	features.injectData(featurePropertyName("feature_c"), "Enabled", proptools.BoolPtr(false))
+	feature, _, enabled = features.enabledSetByFeature(&properties)
+	assert.Equal(t, "feature_b", feature)
+	assert.Equal(t, proptools.BoolPtr(true), enabled)
+}
@@ -18,13 +18,50 @@
 package core
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/pkgconfig"
+	"github.com/ARM-software/bob-build/internal/utils"
 )
 
 type ExternalLibProps struct {
 	Export_cflags  []string
 	Export_ldflags []string
 	Ldlibs         []string
+
+	ExternalLibVariantProps
+
+	// Properties only used when building for the host
+	Host ExternalLibVariantProps
+	// Properties only used when building for the target
+	Target ExternalLibVariantProps
+}
+
+// ExternalLibVariantProps describe the prebuilt files and pkg-config
+// package of an external library. They can be set for both variants, or separately in the
+// host: {} and target: {} blocks.
+type ExternalLibVariantProps struct {
+	// pkg-config package providing the exported flags, optionally with
+	// a version constraint, e.g. "zlib >= 1.2"
+	Pkg_config *string
+	// Path to a prebuilt static or shared library, relative to the build.bp
+	Prebuilt *string
+	// Version of a prebuilt shared library. The prebuilt must be named
+	// <link name>.<version>, e.g. libfoo.so.1.2, and the link name and
+	// soname symlinks are created when it is installed.
+	Library_version *string
+	// Include directories exported to users, relative to the build.bp
+	Export_local_include_dirs []string
+	// Include directories exported to users
+	Export_include_dirs []string
+
+	InstallableProps
 }
 
 type externalLib struct {
@@ -32,6 +69,11 @@ type externalLib struct {
 	Properties struct {
 		ExternalLibProps
 		Features
+
+		TargetType tgtType `blueprint:"mutated"`
+		// The .pc files read when resolving Pkg_config, and the
+		// directories searched before them
+		PkgConfigDeps []string `blueprint:"mutated"`
 	}
 }
 
@@ -46,32 +88,170 @@ func (m *externalLib) features() *Features {
 func (m *externalLib) outputName() string   { return m.Name() }
 func (m *externalLib) altName() string      { return m.outputName() }
 func (m *externalLib) altShortName() string { return m.altName() }
-func (m *externalLib) shortName() string    { return m.Name() }
+func (m *externalLib) getTarget() tgtType   { return m.Properties.TargetType }
+
+// External libraries are always split into host and target variants,
+// so include the variant to keep their phony targets distinct.
+func (m *externalLib) shortName() string {
+	return m.Name() + "__" + string(m.Properties.TargetType)
+}
+
+// External libraries are already built, so the only output is the
+// prebuilt library, if there is one.
+func (m *externalLib) outputs() []string {
+	if m.Properties.Prebuilt != nil {
+		return []string{*m.Properties.Prebuilt}
+	}
+	return []string{}
+}
 
-// External libraries have no outputs - they are already built.
-func (m *externalLib) outputs() []string         { return []string{} }
 func (m *externalLib) implicitOutputs() []string { return []string{} }
 
 // Implement the splittable interface so "normal" libraries can depend on external ones.
 func (m *externalLib) supportedVariants() []tgtType         { return []tgtType{tgtTypeHost, tgtTypeTarget} }
 func (m *externalLib) disable()                             {}
-func (m *externalLib) setVariant(tgtType)                   {}
 func (m *externalLib) getSplittableProps() *SplittableProps { return &SplittableProps{} }
 
+func (m *externalLib) setVariant(tgt tgtType) {
+	m.Properties.TargetType = tgt
+
+	// Apply the properties from the host: {} or target: {} block
+	src := &m.Properties.Host
+	if tgt == tgtTypeTarget {
+		src = &m.Properties.Target
+	}
+	err := proptools.AppendProperties(&m.Properties.ExternalLibVariantProps, src, nil)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (m *externalLib) processPaths(ctx blueprint.BaseModuleContext, g generatorBackend) {
+	props := &m.Properties.ExternalLibVariantProps
+
+	if props.Prebuilt != nil && !filepath.IsAbs(*props.Prebuilt) {
+		*props.Prebuilt = getBackendPathInSourceDir(g, projectModuleDir(ctx), *props.Prebuilt)
+	}
+	props.Export_local_include_dirs = utils.PrefixDirs(props.Export_local_include_dirs, projectModuleDir(ctx))
+	props.InstallableProps.processPaths(ctx, g)
+}
+
+// Add the flags from the pkg-config package to the exported flags
+func (m *externalLib) resolvePkgConfig(ctx blueprint.BaseModuleContext, r *pkgconfig.Resolver) {
+	if m.Properties.Pkg_config == nil {
+		return
+	}
+
+	pkg, err := r.Resolve(*m.Properties.Pkg_config)
+	if err != nil {
+		moduleErrorf(ctx, "pkg_config: %s", err.Error())
+		return
+	}
+
+	m.Properties.Export_cflags = append(m.Properties.Export_cflags, pkg.Cflags...)
+	m.Properties.Export_ldflags = append(m.Properties.Export_ldflags, pkg.Ldflags...)
+	m.Properties.Ldlibs = append(m.Properties.Ldlibs, pkg.Ldlibs...)
+	m.Properties.PkgConfigDeps = append(pkg.Files, pkg.Dirs...)
+}
+
+// Host and target packages are usually found in different places, so
+// each variant has its own search path and sysroot. Options which are
+// not set fall back to the environment variable read by pkg-config.
+func newPkgConfigResolver(ctx configProvider, tgt tgtType) *pkgconfig.Resolver {
+	props := getConfig(ctx).Properties
+	option := func(name string) string {
+		if value := props.GetString(string(tgt) + "_" + strings.ToLower(name)); value != "" {
+			return value
+		}
+		return os.Getenv(name)
+	}
+
+	return pkgconfig.NewResolver(option("PKG_CONFIG_PATH"),
+		option("PKG_CONFIG_LIBDIR"),
+		option("PKG_CONFIG_SYSROOT_DIR"))
+}
+
+func pkgConfigMutator(mctx blueprint.BottomUpMutatorContext) {
+	if m, ok := mctx.Module().(*externalLib); ok {
+		m.resolvePkgConfig(mctx, newPkgConfigResolver(mctx, m.Properties.TargetType))
+	}
+}
+
+//// Support installable
+
+func (m *externalLib) getInstallableProps() *InstallableProps {
+	return &m.Properties.InstallableProps
+}
+
+func (m *externalLib) filesToInstall(ctx blueprint.BaseModuleContext) []string {
+	return m.outputs()
+}
+
+func (m *externalLib) getInstallDepPhonyNames(ctx blueprint.ModuleContext) []string {
+	return getShortNamesForDirectDepsWithTags(ctx, installDepTag)
+}
+
+// Returns the symlinks needed alongside an installed prebuilt shared
+// library, mapping each symlink to its target.
+func externalLibSymlinks(prebuilt, version string) (map[string]string, error) {
+	symlinks := map[string]string{}
+
+	realName := filepath.Base(prebuilt)
+	if !strings.HasSuffix(realName, "."+version) {
+		return nil, fmt.Errorf("prebuilt %s is not named <link name>.%s", realName, version)
+	}
+
+	linkName := strings.TrimSuffix(realName, "."+version)
+	soname := linkName + "." + strings.Split(version, ".")[0]
+
+	symlinks[linkName] = soname
+	if soname != realName {
+		symlinks[soname] = realName
+	}
+
+	return symlinks, nil
+}
+
+func (m *externalLib) librarySymlinks(ctx blueprint.ModuleContext) map[string]string {
+	props := &m.Properties.ExternalLibVariantProps
+	if props.Prebuilt == nil || props.Library_version == nil {
+		return map[string]string{}
+	}
+
+	symlinks, err := externalLibSymlinks(*props.Prebuilt, *props.Library_version)
+	if err != nil {
+		ctx.PropertyErrorf("library_version", "%s", err.Error())
+		return map[string]string{}
+	}
+	return symlinks
+}
+
 // Implement the propertyExporter interface so that external libraries can pass
 // on properties e.g. from pkg-config
 
-func (m *externalLib) exportCflags() []string           { return m.Properties.Export_cflags }
-func (m *externalLib) exportIncludeDirs() []string      { return []string{} }
-func (m *externalLib) exportLocalIncludeDirs() []string { return []string{} }
-func (m *externalLib) exportLdflags() []string          { return m.Properties.Export_ldflags }
-func (m *externalLib) exportLdlibs() []string           { return m.Properties.Ldlibs }
-func (m *externalLib) exportSharedLibs() []string       { return []string{} }
+func (m *externalLib) exportCflags() []string      { return m.Properties.Export_cflags }
+func (m *externalLib) exportIncludeDirs() []string { return m.Properties.Export_include_dirs }
+func (m *externalLib) exportLocalIncludeDirs() []string {
+	return m.Properties.Export_local_include_dirs
+}
+func (m *externalLib) exportLdflags() []string    { return m.Properties.Export_ldflags }
+func (m *externalLib) exportLdlibs() []string     { return m.Properties.Ldlibs }
+func (m *externalLib) exportSharedLibs() []string { return []string{} }
 
 var _ propertyExporter = (*externalLib)(nil)
+var _ installable = (*externalLib)(nil)
+var _ symlinkInstaller = (*externalLib)(nil)
+var _ pathProcessor = (*externalLib)(nil)
+
+// External libraries are already built, but prebuilt libraries may
+// need to be installed.
+func (m *externalLib) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	// Regenerate when the pkg-config files change, or when a file
+	// which would be found first is added
+	ctx.AddNinjaFileDeps(m.Properties.PkgConfigDeps...)
 
-// External libraries have no actions - they are already built.
-func (m *externalLib) GenerateBuildActions(ctx blueprint.ModuleContext) {}
+	getBackend(ctx).externalLibActions(m, ctx)
+}
 
 func externalLibFactory(config *bobConfig) (blueprint.Module, []interface{}) {
 	module := &externalLib{}
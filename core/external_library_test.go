@@ -0,0 +1,42 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_externalLibSymlinks(t *testing.T) {
+	symlinks, err := externalLibSymlinks("prebuilt/libfoo.so.1.2.3", "1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"libfoo.so":   "libfoo.so.1",
+		"libfoo.so.1": "libfoo.so.1.2.3",
+	}, symlinks)
+
+	// When the version has a single component, the prebuilt is
+	// already named after the soname
+	symlinks, err = externalLibSymlinks("libfoo.so.1", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"libfoo.so": "libfoo.so.1"}, symlinks)
+
+	_, err = externalLibSymlinks("libfoo.so", "1.2")
+	assert.Error(t, err)
+}
@@ -23,6 +23,8 @@ import (
 	"strings"
 
 	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/condition"
 )
 
 // featurePropertyName returns name of feature. Name needs to start from capital letter because
@@ -49,6 +51,33 @@ type singleFeature struct {
 	BlueprintEmbed interface{}
 }
 
+// The condition_N blocks available alongside the features
+var conditionBlocks = numberedBlocks{"condition", 8}
+
+// A condition block holds properties which are applied when a boolean
+// expression over config values is true, e.g.
+//
+//   condition_1: {
+//       when: "debug && !android",
+//       cflags: ["-DHOST_DEBUG"],
+//   },
+type singleCondition struct {
+	When           *string
+	BlueprintEmbed interface{}
+}
+
+// checkFeatureNames reports boolean config options whose feature block
+// would have the same name as a condition block
+func checkFeatureNames(featureList []string) error {
+	for _, featureName := range featureList {
+		if conditionBlocks.hasFieldName(featurePropertyName(featureName)) {
+			return fmt.Errorf("Config option %s clashes with the %s block, which is reserved",
+				featureName, strings.ToLower(featureName))
+		}
+	}
+	return nil
+}
+
 func typesOf(list ...interface{}) []reflect.Type {
 	types := make([]reflect.Type, len(list))
 	for i, element := range list {
@@ -81,7 +110,7 @@ func (f *Features) Init(properties *configProperties, list ...interface{}) {
 	}
 
 	propsType := coalesceTypes(typesOf(list...)...)
-	fields := make([]reflect.StructField, len(properties.featureList))
+	fields := make([]reflect.StructField, len(properties.featureList), len(properties.featureList)+conditionBlocks.count)
 
 	for i, featureName := range properties.featureList {
 		fields[i] = reflect.StructField{
@@ -89,6 +118,7 @@ func (f *Features) Init(properties *configProperties, list ...interface{}) {
 			Type: reflect.TypeOf(singleFeature{}),
 		}
 	}
+	fields = append(fields, conditionBlocks.fields(reflect.TypeOf(singleCondition{}))...)
 
 	bpFeatureStruct := reflect.StructOf(fields)
 	instancePtr := reflect.New(bpFeatureStruct)
@@ -99,6 +129,10 @@ func (f *Features) Init(properties *configProperties, list ...interface{}) {
 		propsInFeature := instance.Field(i).Addr().Interface().(*singleFeature)
 		propsInFeature.BlueprintEmbed = reflect.New(propsType).Interface()
 	}
+	for _, field := range conditionBlocks.values(instance) {
+		propsInCondition := field.Addr().Interface().(*singleCondition)
+		propsInCondition.BlueprintEmbed = reflect.New(propsType).Interface()
+	}
 
 }
 
@@ -190,5 +224,35 @@ func (f *Features) AppendProps(dst []interface{}, properties *configProperties)
 			}
 		}
 	}
+
+	// Condition blocks are applied after features, in order
+	for i, field := range conditionBlocks.values(featuresData) {
+		cond := field.Interface().(singleCondition)
+		matched, err := cond.matches(properties)
+		if err != nil {
+			return &proptools.ExtendPropertyError{
+				Property: conditionBlocks.propertyName(i+1) + ".when",
+				Err:      err,
+			}
+		}
+		if matched {
+			err := proptools.AppendMatchingProperties(dst, cond.BlueprintEmbed, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// matches evaluates the condition's expression. Unused condition blocks
+// never match.
+func (c *singleCondition) matches(properties *configProperties) (bool, error) {
+	if c.When == nil {
+		return false, nil
+	}
+	return condition.Evaluate(*c.When, func(name string) (interface{}, bool) {
+		value, ok := properties.properties[name]
+		return value, ok
+	})
+}
@@ -23,6 +23,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/blueprint/proptools"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/ARM-software/bob-build/internal/utils"
@@ -476,3 +477,57 @@ func Test_should_composite_new_type(t *testing.T) {
 	feature.FieldByName("FieldA").SetString("+value_a")
 	feature.FieldByName("FieldB").SetString("+value_b")
 }
+
+// injectCondition sets the expression of a condition block, and
+// 'injects' data into it like injectData
+func (features *Features) injectCondition(n int, when string, field string, data interface{}) {
+	allFeatures := reflect.ValueOf(features.BlueprintEmbed).Elem()
+	cond := allFeatures.FieldByName(conditionBlocks.fieldName(n)).Addr().Interface().(*singleCondition)
+	cond.When = &when
+	reflect.ValueOf(cond.BlueprintEmbed).Elem().FieldByName(field).Set(reflect.ValueOf(data))
+}
+
+func Test_should_append_condition_blocks_after_features_when_expression_is_true(t *testing.T) {
+	module, properties := createTestModuleAndFeatures()
+	properties.features = map[string]bool{"feature_b": true}
+	properties.properties = map[string]interface{}{
+		"feature_a": false,
+		"feature_b": true,
+		"arch":      "arm64",
+	}
+
+	module.injectCondition(1, "feature_b && !feature_a", "FieldB", "+Cond_b")
+	module.injectCondition(2, "arch == 'x86'", "FieldA", "+Cond_a")
+	module.injectCondition(3, "arch == 'arm64' || missing", "FieldA", "+Cond3_a")
+
+	if err := module.AppendProps([]interface{}{&module}, &properties); err != nil {
+		panic(err)
+	}
+
+	assert.Equalf(t, "a+Cond3_a", module.FieldA, "module.FieldA incorrect")
+	assert.Equalf(t, "bProps_b+Cond_b", module.FieldB, "module.FieldB incorrect")
+}
+
+func Test_should_return_property_error_when_condition_is_invalid(t *testing.T) {
+	module, properties := createTestModuleAndFeatures()
+	properties.properties = map[string]interface{}{}
+
+	module.injectCondition(2, "missing &&", "FieldA", "+Cond_a")
+
+	err := module.AppendProps([]interface{}{&module}, &properties)
+	if assert.Error(t, err) {
+		propertyErr, ok := err.(*proptools.ExtendPropertyError)
+		if assert.True(t, ok, "Error should be an ExtendPropertyError") {
+			assert.Equal(t, "condition_2.when", propertyErr.Property)
+		}
+	}
+}
+
+func Test_should_reject_features_named_like_condition_blocks(t *testing.T) {
+	assert.NoError(t, checkFeatureNames([]string{"condition", "condition_9", "debug"}))
+
+	err := checkFeatureNames([]string{"debug", "CONDITION_3"})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "condition_3"))
+	}
+}
@@ -0,0 +1,425 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/fileutils"
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// The version of the persisted cache. Increase this when the format of
+// the file, or the way flags are checked, changes, so that older
+// results are discarded.
+const flagCacheVersion = 1
+
+// The name of the persisted cache, in the build directory
+const flagCacheFileName = ".flag_cache.json"
+
+// The maximum number of flags checked by each compiler invocation
+const flagProbeBatchSize = 16
+
+// The contents of the persisted cache
+type flagCacheFile struct {
+	Version int `json:"version"`
+	// The results for each compiler identity, indexed by
+	// "<language>/<flag>"
+	Compilers map[string]map[string]bool `json:"compilers"`
+}
+
+// A compiler, with the toolchain flags it is always run with, and the
+// language it is checking flags for
+type flagProbeGroup struct {
+	compiler string
+	// The toolchain flags, joined by NUL characters so that the group
+	// can be used as a map key
+	flags    string
+	language string
+}
+
+// The flags to check with a compiler
+type pendingFlags struct {
+	compilerFlags []string
+	flags         map[string]bool
+}
+
+// Type for caching the flags supported by compilers.
+//
+// Results are indexed by the compiler's identity, which includes its
+// real path, modification time and size, and the toolchain flags it is
+// run with, so that results are discarded when any of these change.
+// The cache is persisted in the build directory, so the compiler is
+// only run for flags which a previous run has not checked.
+type flagSupportedCache struct {
+	lock sync.Mutex
+
+	// The file the cache is persisted to. This is only loaded when
+	// the cache is first used, as the build directory is not known
+	// when the toolchains are created.
+	file   string
+	loaded bool
+
+	// Results, indexed by compiler identity, then "<language>/<flag>"
+	results map[string]map[string]bool
+	// The identities of the compilers used by this run. Only these
+	// are saved, so that stale results are dropped.
+	used map[string]bool
+	// Compiler identities, indexed by compiler and flags
+	identities map[string]string
+
+	// Flags to check before the late templates are applied
+	pending   map[flagProbeGroup]*pendingFlags
+	probeOnce sync.Once
+
+	// Runs a compiler, returning whether it succeeded
+	run func(compiler string, args []string) bool
+}
+
+func newFlagSupportedCache(file string) *flagSupportedCache {
+	return &flagSupportedCache{
+		file:       file,
+		results:    map[string]map[string]bool{},
+		used:       map[string]bool{},
+		identities: map[string]string{},
+		pending:    map[flagProbeGroup]*pendingFlags{},
+		run:        runCompiler,
+	}
+}
+
+// All toolchains share one cache, so that it is persisted in one file
+var sharedFlagCache = newFlagSupportedCache("")
+
+func newFlagCache() *flagSupportedCache {
+	return sharedFlagCache
+}
+
+func runCompiler(compiler string, args []string) bool {
+	_, err := exec.Command(compiler, args...).CombinedOutput()
+	return err == nil
+}
+
+// Returns the compiler used for 'language', and the flags it is always
+// run with
+func getFlagCheckCompiler(tc toolchain, language string) (string, []string, bool) {
+	switch language {
+	case "c++":
+		compiler, flags := tc.getCXXCompiler()
+		return compiler, flags, true
+	case "c":
+		compiler, flags := tc.getCCompiler()
+		return compiler, flags, true
+	}
+	// No other language currently supported
+	return "", nil, false
+}
+
+// Returns a string identifying a compiler and its flags, which changes
+// when the compiler is updated
+func compilerIdentity(compiler string, flags []string) string {
+	path := compiler
+	version := ""
+	if found, err := exec.LookPath(compiler); err == nil {
+		path = found
+		if real, err := filepath.EvalSymlinks(found); err == nil {
+			path = real
+		}
+		if fi, err := os.Stat(path); err == nil {
+			version = fmt.Sprintf("%d:%d", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return strings.Join(utils.NewStringSlice([]string{path, version}, flags), " ")
+}
+
+// Load the persisted results. A missing, unreadable or outdated file
+// just means the flags are checked again. The caller must hold the
+// lock.
+func (cache *flagSupportedCache) load() {
+	if cache.loaded {
+		return
+	}
+	cache.loaded = true
+
+	if cache.file == "" {
+		cache.file = getPathInBuildDir(flagCacheFileName)
+	}
+	data, err := ioutil.ReadFile(cache.file)
+	if err != nil {
+		return
+	}
+	contents := flagCacheFile{}
+	if json.Unmarshal(data, &contents) != nil || contents.Version != flagCacheVersion {
+		return
+	}
+	for identity, results := range contents.Compilers {
+		if results != nil {
+			cache.results[identity] = results
+		}
+	}
+}
+
+// Returns the results for a compiler, marking it as used by this run.
+// The caller must hold the lock.
+func (cache *flagSupportedCache) compilerResults(compiler string, flags []string) map[string]bool {
+	cache.load()
+
+	key := strings.Join(utils.NewStringSlice([]string{compiler}, flags), "\x00")
+	identity, ok := cache.identities[key]
+	if !ok {
+		identity = compilerIdentity(compiler, flags)
+		cache.identities[key] = identity
+	}
+	cache.used[identity] = true
+
+	results, ok := cache.results[identity]
+	if !ok {
+		results = map[string]bool{}
+		cache.results[identity] = results
+	}
+	return results
+}
+
+// Run the compiler once for a batch of flags. If it fails, split the
+// batch until the unsupported flags are found.
+func (cache *flagSupportedCache) probe(compiler string, flags []string, language string,
+	batch []string, results map[string]bool) {
+
+	// Add a '-Werror' to make sure that the compiler exits with an error code if the
+	// flag is unknown. If the flag starts with '-Wno-' remove the 'no-' part so that
+	// we can test the actual flag. This is to work around the fact that gcc is silent
+	// about '-Wno-<flag_name>' flags it doesn't recognise until you actually compile a file
+	testFlags := utils.NewStringSlice(flags, []string{"-x", language, "-c", os.DevNull, "-o", os.DevNull, "-Werror"})
+	for _, flag := range batch {
+		testFlags = append(testFlags, strings.Replace(flag, "-Wno-", "-W", 1))
+	}
+	testFlags = utils.Remove(testFlags, "")
+
+	if cache.run(compiler, testFlags) {
+		for _, flag := range batch {
+			results[flag] = true
+		}
+	} else if len(batch) == 1 {
+		// Compiler did not recognise the flag
+		results[batch[0]] = false
+	} else {
+		mid := len(batch) / 2
+		cache.probe(compiler, flags, language, batch[:mid], results)
+		cache.probe(compiler, flags, language, batch[mid:], results)
+	}
+}
+
+// Check that a toolchain's compiler for 'language' supports the given 'flag'
+func (cache *flagSupportedCache) checkFlag(tc toolchain, language, flag string) bool {
+	compiler, flags, ok := getFlagCheckCompiler(tc, language)
+	if !ok {
+		return false
+	}
+
+	// Check the flags collected before the late templates first
+	cache.probeOnce.Do(cache.probePending)
+
+	key := language + "/" + flag
+
+	cache.lock.Lock()
+	results := cache.compilerResults(compiler, flags)
+	supported, ok := results[key]
+	cache.lock.Unlock()
+	if ok {
+		return supported
+	}
+
+	// We have not seen the flag before, check it by running the compiler with the flag
+	probed := map[string]bool{}
+	cache.probe(compiler, flags, language, []string{flag}, probed)
+
+	cache.lock.Lock()
+	results[key] = probed[flag]
+	cache.lock.Unlock()
+
+	return probed[flag]
+}
+
+// Record a flag to check before the late templates are applied
+func (cache *flagSupportedCache) addPending(tc toolchain, language, flag string) {
+	compiler, flags, ok := getFlagCheckCompiler(tc, language)
+	if !ok {
+		return
+	}
+	group := flagProbeGroup{compiler, strings.Join(flags, "\x00"), language}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.pending[group] == nil {
+		cache.pending[group] = &pendingFlags{flags, map[string]bool{}}
+	}
+	cache.pending[group].flags[flag] = true
+}
+
+// Check all the pending flags which are not already cached. Flags are
+// checked in batches, with each batch run concurrently.
+func (cache *flagSupportedCache) probePending() {
+	type flagProbeBatch struct {
+		flagProbeGroup
+		compilerFlags []string
+		flags         []string
+		results       map[string]bool
+	}
+
+	batches := []flagProbeBatch{}
+
+	cache.lock.Lock()
+	for group, pending := range cache.pending {
+		results := cache.compilerResults(group.compiler, pending.compilerFlags)
+
+		unknown := []string{}
+		for flag := range pending.flags {
+			if _, ok := results[group.language+"/"+flag]; !ok {
+				unknown = append(unknown, flag)
+			}
+		}
+		sort.Strings(unknown)
+
+		for start := 0; start < len(unknown); start += flagProbeBatchSize {
+			end := start + flagProbeBatchSize
+			if end > len(unknown) {
+				end = len(unknown)
+			}
+			batches = append(batches, flagProbeBatch{group, pending.compilerFlags, unknown[start:end], results})
+		}
+	}
+	cache.pending = map[flagProbeGroup]*pendingFlags{}
+	cache.lock.Unlock()
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, runtime.NumCPU())
+
+	for _, batch := range batches {
+		wg.Add(1)
+		sem <- true
+		go func(batch flagProbeBatch) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			probed := map[string]bool{}
+			cache.probe(batch.compiler, batch.compilerFlags, batch.language, batch.flags, probed)
+
+			cache.lock.Lock()
+			for flag, supported := range probed {
+				batch.results[batch.language+"/"+flag] = supported
+			}
+			cache.lock.Unlock()
+		}(batch)
+	}
+	wg.Wait()
+}
+
+// Persist the results for the compilers used by this run
+func (cache *flagSupportedCache) save() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if !cache.loaded {
+		// No flags were checked, so leave any existing results
+		return nil
+	}
+
+	contents := flagCacheFile{
+		Version:   flagCacheVersion,
+		Compilers: map[string]map[string]bool{},
+	}
+	for identity := range cache.used {
+		contents.Compilers[identity] = cache.results[identity]
+	}
+
+	text, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	sb := &strings.Builder{}
+	sb.Write(text)
+	sb.WriteString("\n")
+
+	return fileutils.WriteIfChanged(cache.file, sb)
+}
+
+// Matches the {{add_if_supported}} calls whose argument is a literal
+var addIfSupportedRegexp = regexp.MustCompile(`\{\{\s*add_if_supported\s+"([^"]*)"\s*\}\}`)
+
+// Collect the flags passed to {{add_if_supported}}, so that they are
+// checked together before the late templates are applied, rather than
+// one at a time by each module.
+func collectCompilerFlagsMutator(mctx blueprint.BottomUpMutatorContext) {
+	if e, ok := mctx.Module().(enableable); !ok || !isEnabled(e) {
+		return
+	}
+	t, ok := mctx.Module().(moduleWithBuildProps)
+	if !ok {
+		return
+	}
+
+	build := t.build()
+	tc := getBackend(mctx).getToolchain(build.TargetType)
+
+	// Only the first language checkCompilerFlag tries is collected,
+	// as the others are only needed when it is not supported
+	props := []struct {
+		flags    []string
+		language string
+	}{
+		{build.Cflags, "c++"},
+		{build.Export_cflags, "c++"},
+		{build.Cxxflags, "c++"},
+		{build.Conlyflags, "c"},
+	}
+
+	for _, prop := range props {
+		for _, s := range prop.flags {
+			for _, match := range addIfSupportedRegexp.FindAllStringSubmatch(s, -1) {
+				sharedFlagCache.addPending(tc, prop.language, match[1])
+			}
+		}
+	}
+}
+
+type flagCacheSingleton struct{}
+
+func (m *flagCacheSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	if err := sharedFlagCache.save(); err != nil {
+		utils.Exit(1, err.Error())
+	}
+}
+
+func flagCacheSingletonFactory() blueprint.Singleton {
+	return &flagCacheSingleton{}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A compiler which only supports the flags starting with -Wgood, and
+// records how often it is run
+type fakeFlagCompiler struct {
+	lock sync.Mutex
+	runs int
+}
+
+func (c *fakeFlagCompiler) run(compiler string, args []string) bool {
+	c.lock.Lock()
+	c.runs++
+	c.lock.Unlock()
+
+	checked := false
+	for _, arg := range args {
+		if !checked {
+			// Only check the flags after -Werror
+			checked = arg == "-Werror"
+			continue
+		}
+		if !strings.HasPrefix(arg, "-Wgood") {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestFlagCache(file string) (*flagSupportedCache, *fakeFlagCompiler) {
+	cache := newFlagSupportedCache(file)
+	compiler := &fakeFlagCompiler{}
+	cache.run = compiler.run
+	return cache, compiler
+}
+
+func testFlagCacheToolchain() toolchainGeneric {
+	return toolchainGeneric{desc: genericToolchainDesc{
+		CCompiler:   genericTool{Tool: "test-cc"},
+		CXXCompiler: genericTool{Tool: "test-c++", Flags: []string{"-std=c++11"}},
+	}}
+}
+
+func Test_flagCacheBatchesProbes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flag_cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, compiler := newTestFlagCache(filepath.Join(dir, flagCacheFileName))
+	tc := testFlagCacheToolchain()
+
+	for _, flag := range []string{"-Wgood1", "-Wgood2", "-Wgood3", "-Wbad"} {
+		cache.addPending(tc, "c++", flag)
+	}
+
+	assert.True(t, cache.checkFlag(tc, "c++", "-Wgood1"))
+	// The batch fails, and is split into {-Wbad, -Wgood1}, which fails
+	// and is split again, and {-Wgood2, -Wgood3}
+	assert.Equal(t, 5, compiler.runs)
+
+	assert.False(t, cache.checkFlag(tc, "c++", "-Wbad"))
+	assert.True(t, cache.checkFlag(tc, "c++", "-Wgood3"))
+	assert.Equal(t, 5, compiler.runs, "Collected flags should be cached")
+
+	assert.False(t, cache.checkFlag(tc, "c", "-Wbad"))
+	assert.Equal(t, 6, compiler.runs, "Each language should be checked separately")
+}
+
+func Test_flagCachePersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flag_cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, flagCacheFileName)
+	tc := testFlagCacheToolchain()
+
+	cache, compiler := newTestFlagCache(file)
+	assert.True(t, cache.checkFlag(tc, "c", "-Wgood"))
+	assert.False(t, cache.checkFlag(tc, "c", "-Wbad"))
+	assert.Equal(t, 2, compiler.runs)
+	assert.Nil(t, cache.save())
+
+	cache, compiler = newTestFlagCache(file)
+	assert.True(t, cache.checkFlag(tc, "c", "-Wgood"))
+	assert.False(t, cache.checkFlag(tc, "c", "-Wbad"))
+	assert.Equal(t, 0, compiler.runs, "Results should be loaded from the file")
+
+	// Changing the toolchain flags invalidates the results
+	tc.desc.CCompiler.Flags = []string{"-m32"}
+	cache, compiler = newTestFlagCache(file)
+	assert.True(t, cache.checkFlag(tc, "c", "-Wgood"))
+	assert.Equal(t, 1, compiler.runs)
+	assert.Nil(t, cache.save())
+
+	// Only the compilers used by the last run are kept
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), "test-cc  -m32"))
+	assert.False(t, strings.Contains(string(data), "-Wbad"))
+}
+
+func Test_flagCacheIgnoresOtherVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flag_cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, flagCacheFileName)
+	contents := `{"version": 0, "compilers": {"test-cc ": {"c/-Wbad": true}}}`
+	assert.Nil(t, ioutil.WriteFile(file, []byte(contents), 0644))
+
+	cache, compiler := newTestFlagCache(file)
+	assert.False(t, cache.checkFlag(testFlagCacheToolchain(), "c", "-Wbad"))
+	assert.Equal(t, 1, compiler.runs)
+}
+
+func Test_compilerIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flag_cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	compiler := filepath.Join(dir, "cc")
+	assert.Nil(t, ioutil.WriteFile(compiler, []byte("#!/bin/sh\n"), 0755))
+	link := filepath.Join(dir, "cc-link")
+	assert.Nil(t, os.Symlink(compiler, link))
+
+	identity := compilerIdentity(compiler, []string{"-m32"})
+	assert.Equal(t, identity, compilerIdentity(link, []string{"-m32"}),
+		"Symlinks should resolve to the same compiler")
+	assert.NotEqual(t, identity, compilerIdentity(compiler, []string{"-m64"}))
+
+	// Updating the compiler changes its identity
+	assert.Nil(t, ioutil.WriteFile(compiler, []byte("#!/bin/sh\nexit 0\n"), 0755))
+	assert.NotEqual(t, identity, compilerIdentity(compiler, []string{"-m32"}))
+}
@@ -31,6 +31,7 @@ import (
 var (
 	graphStartNodes      string
 	graphOut             string
+	graphFormat          string
 	graphShowReverseDeps bool
 	graphShowDeps        bool
 	graphShowDefaults    bool
@@ -46,6 +47,8 @@ func init() {
 		"Comma separated list of initial nodes")
 	flag.StringVar(&graphOut, "graph-out", "",
 		"Output file name for dependency graph. Defaults to first graph-start-nodes")
+	flag.StringVar(&graphFormat, "graph-format", "dot",
+		"Format of the dependency graph: "+strings.Join(graph.FormatNames(), ", "))
 	flag.BoolVar(&graphShowReverseDeps, "graph-rev-deps", false,
 		"Show reverse dependencies (users) of graph-start-nodes")
 	flag.BoolVar(&graphShowDeps, "graph-deps", true, "Show dependencies of graph-start-nodes")
@@ -60,6 +63,7 @@ func init() {
 
 type graphvizHandler struct {
 	graph               graph.Graph
+	format              string
 	startNodes          []string
 	showReverseDeps     bool
 	showDeps            bool
@@ -76,11 +80,17 @@ func initGrapvizHandler() *graphvizHandler {
 		return nil
 	}
 
+	extension, err := graph.FormatExtension(graphFormat)
+	if err != nil {
+		utils.Exit(1, "-graph-format: "+err.Error())
+	}
+
 	if graphOut == "" {
-		graphOut = strings.SplitN(graphStartNodes, ",", 2)[0] + ".graph"
+		graphOut = strings.SplitN(graphStartNodes, ",", 2)[0] + extension
 	}
 
 	return &graphvizHandler{graph.NewGraph(graphOut),
+		graphFormat,
 		utils.Trim(strings.Split(graphStartNodes, ",")),
 		graphShowReverseDeps,
 		graphShowDeps,
@@ -107,9 +117,14 @@ func (handler *graphvizHandler) generateGraphviz() {
 		}
 	}
 
+	text, err := graph.Format(outputGraph, handler.format)
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+
 	file, _ := os.Create(outputGraph.GetName())
 	defer file.Close()
-	file.WriteString(graph.ToString(outputGraph))
+	file.WriteString(text)
 }
 
 func (handler *graphvizHandler) graphvizMutator(mctx blueprint.BottomUpMutatorContext) {
@@ -140,7 +155,7 @@ func (handler *graphvizHandler) graphvizMutator(mctx blueprint.BottomUpMutatorCo
 			return
 		}
 		handler.graph.SetNodeBackgroundColor(mainModule.Name(), "orange")
-	case *binary:
+	case *binary, *test:
 		if !handler.showBinaries {
 			return
 		}
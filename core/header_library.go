@@ -0,0 +1,50 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"github.com/google/blueprint"
+)
+
+// A header library exports include directories and cflags to the
+// modules that list it in header_libs, but has nothing to compile or
+// link.
+type headerLibrary struct {
+	library
+}
+
+// Verify that the following interfaces are implemented
+var _ splittable = (*headerLibrary)(nil)
+var _ pathProcessor = (*headerLibrary)(nil)
+var _ propertyExporter = (*headerLibrary)(nil)
+
+// Header libraries have no link-time properties to pass on
+func (m *headerLibrary) exportLdflags() []string    { return []string{} }
+func (m *headerLibrary) exportLdlibs() []string     { return []string{} }
+func (m *headerLibrary) exportSharedLibs() []string { return []string{} }
+
+func (m *headerLibrary) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	if isEnabled(m) {
+		getBackend(ctx).headerActions(m, ctx)
+	}
+}
+
+func headerLibraryFactory(config *bobConfig) (blueprint.Module, []interface{}) {
+	module := &headerLibrary{}
+	return module.LibraryFactory(config, module)
+}
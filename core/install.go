@@ -35,6 +35,8 @@ type EnableableProps struct {
 	// Used to prune unused modules from Android builds, where we can't
 	// control exactly what gets built.
 	Required bool `blueprint:"mutated"`
+	// Why the module is disabled, if it is
+	DisabledReason string `blueprint:"mutated"`
 }
 
 // Modules implementing the enableable interface can be disabled, and select if they are built by default
@@ -61,6 +63,10 @@ func isBuiltByDefault(e enableable) bool {
 		if m.Properties.TargetType == tgtTypeTarget {
 			return true
 		}
+	case *test:
+		if m.getTarget() == tgtTypeTarget {
+			return true
+		}
 	case *kernelModule:
 		return true
 	}
@@ -51,17 +51,20 @@ func addtoFuncmap(propfnmap map[string]template.FuncMap, propList []string, name
 // This function supports property specific funcmaps for templates,
 // allowing template functions to only be valid for particular
 // properties.
-func applyLateTemplateRecursive(propsVal reflect.Value, stringvalues map[string]string,
-	propfnmap map[string]template.FuncMap) {
+func applyLateTemplateRecursive(propsVal reflect.Value, prefix string, stringvalues map[string]string,
+	propfnmap map[string]template.FuncMap) (errs []templateError) {
 
 	for i := 0; i < propsVal.NumField(); i++ {
 		field := propsVal.Field(i)
 		propName := propsVal.Type().Field(i).Name
+		bpName := templatePropertyName(prefix, propsVal.Type().Field(i))
 
 		switch field.Kind() {
 		case reflect.String:
 			if funcmap, ok := propfnmap[propName]; ok {
-				applyTemplateString(field, stringvalues, funcmap)
+				if err := applyTemplateString(field, stringvalues, funcmap); err != nil {
+					errs = append(errs, templateError{bpName, err})
+				}
 			}
 
 		case reflect.Slice:
@@ -71,7 +74,9 @@ func applyLateTemplateRecursive(propsVal reflect.Value, stringvalues map[string]
 				for j := 0; j < field.Len(); j++ {
 					elem := field.Index(j)
 					if elem.Kind() == reflect.String {
-						applyTemplateString(elem, stringvalues, funcmap)
+						if err := applyTemplateString(elem, stringvalues, funcmap); err != nil {
+							errs = append(errs, templateError{bpName, err})
+						}
 						if elem.String() == "" {
 							emptyStrings = true
 						}
@@ -91,14 +96,20 @@ func applyLateTemplateRecursive(propsVal reflect.Value, stringvalues map[string]
 			if funcmap, ok := propfnmap[propName]; ok {
 				tgtField := reflect.Indirect(field)
 				if tgtField.Kind() == reflect.String {
-					applyTemplateString(tgtField, stringvalues, funcmap)
+					if err := applyTemplateString(tgtField, stringvalues, funcmap); err != nil {
+						errs = append(errs, templateError{bpName, err})
+					}
 				}
 			}
 
 		case reflect.Struct:
-			applyLateTemplateRecursive(field, stringvalues, propfnmap)
+			if !propsVal.Type().Field(i).Anonymous {
+				bpName += "."
+			}
+			errs = append(errs, applyLateTemplateRecursive(field, bpName, stringvalues, propfnmap)...)
 		}
 	}
+	return
 }
 
 // Record non-compiled sources (only relevant for C/C++ compiled
@@ -109,7 +120,7 @@ func (s *SourceProps) initializeNonCompiledSourceMap(mctx blueprint.BaseModuleCo
 	nonCompiledSources := make(map[string]bool)
 	if _, ok := getLibrary(mctx.Module()); ok {
 		for _, src := range s.getSources(mctx) {
-			if utils.IsNotCompilableSource(src) {
+			if _, ok := getCompilerRule(src); utils.IsNotCompilableSource(src) && !ok {
 				nonCompiledSources[src] = false
 			}
 		}
@@ -143,7 +154,7 @@ func setupMatchSources(mctx blueprint.BaseModuleContext,
 
 	nonCompiledSources := sourceProps.initializeNonCompiledSourceMap(mctx)
 	addtoFuncmap(propfnmap, matchSrcProps, "match_srcs",
-		func(arg string) string {
+		func(arg string) (string, error) {
 			return sourceProps.matchSources(mctx, arg, nonCompiledSources)
 		})
 
@@ -152,7 +163,7 @@ func setupMatchSources(mctx blueprint.BaseModuleContext,
 
 // Callback function implementing {{match_srcs}}
 func (s *SourceProps) matchSources(ctx blueprint.BaseModuleContext, arg string,
-	matchedNonCompiledSources map[string]bool) string {
+	matchedNonCompiledSources map[string]bool) (string, error) {
 
 	g := getBackend(ctx)
 
@@ -160,7 +171,7 @@ func (s *SourceProps) matchSources(ctx blueprint.BaseModuleContext, arg string,
 	for _, src := range s.getSources(ctx) {
 		matched, err := pathtools.Match("**/"+arg, src)
 		if err != nil {
-			panic("Error during matching filepath pattern")
+			return "", fmt.Errorf("invalid pattern '%s': %s", arg, err)
 		}
 		if matched {
 			matchedNonCompiledSources[src] = true
@@ -168,18 +179,18 @@ func (s *SourceProps) matchSources(ctx blueprint.BaseModuleContext, arg string,
 		}
 	}
 	if len(matchedSources) == 0 {
-		panic(fmt.Errorf("Could not match '%s' for module '%s'", arg, ctx.ModuleName()))
+		return "", fmt.Errorf("could not match '%s'", arg)
 	}
 
-	return strings.Join(matchedSources, " ")
+	return strings.Join(matchedSources, " "), nil
 }
 
 // Ensure that every non-compiled source has been used by at least one
 // {{match_srcs}} instance.
-func verifyMatchSources(matchedNonCompiledSources map[string]bool) {
-	for src, matched := range matchedNonCompiledSources {
-		if !matched {
-			panic(fmt.Errorf("Non-compiled source %s is not used by match_srcs.", src))
+func verifyMatchSources(ctx blueprint.BaseModuleContext, matchedNonCompiledSources map[string]bool) {
+	for _, src := range utils.SortedKeysBoolMap(matchedNonCompiledSources) {
+		if !matchedNonCompiledSources[src] {
+			propertyErrorf(ctx, "srcs", "non-compiled source %s is not used by match_srcs", src)
 		}
 	}
 }
@@ -247,10 +258,12 @@ func applyLateTemplates(mctx blueprint.BaseModuleContext) {
 		propsVal := reflect.Indirect(reflect.ValueOf(p))
 
 		// Properties have already been expanded, so set stringvalues to nil
-		applyLateTemplateRecursive(propsVal, nil, propfnmap)
+		for _, e := range applyLateTemplateRecursive(propsVal, "", nil, propfnmap) {
+			propertyErrorf(mctx, e.property, "%s", e.err)
+		}
 	}
 
-	verifyMatchSources(nonCompiledSources)
+	verifyMatchSources(mctx, nonCompiledSources)
 }
 
 // This mutator handles late templates
@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/google/blueprint"
 
@@ -80,6 +81,8 @@ type BuildProps struct {
 	Library_version string
 	// Shared library version script
 	Version_script *string
+	// Header to precompile, and include before each C++ source
+	Pch *string
 
 	// The list of shared lib modules that this library depends on.
 	// These are propagated to the closest linking object when specified on static libraries.
@@ -162,6 +165,11 @@ type BuildProps struct {
 
 	StripProps
 	AndroidPGOProps
+	SanitizeProps
+	LtoProps
+	CoverageProps
+	UnityBuildProps
+	SrcsFlagsProps
 
 	TargetType tgtType `blueprint:"mutated"`
 }
@@ -261,6 +269,11 @@ func (l *BuildProps) processPaths(ctx blueprint.BaseModuleContext, g generatorBa
 	prefix := projectModuleDir(ctx)
 
 	l.Export_local_include_dirs = utils.PrefixDirs(l.Export_local_include_dirs, prefix)
+	if l.Pch != nil {
+		*l.Pch = filepath.Join(prefix, *l.Pch)
+	}
+	l.UnityBuildProps.processPaths(ctx)
+	l.SrcsFlagsProps.processPaths(ctx)
 	l.processBuildWrapper(ctx)
 }
 
@@ -290,6 +303,10 @@ type library struct {
 
 		VersionScriptModule *string `blueprint:"mutated"`
 	}
+
+	// Static library dependencies, recorded by resolveDependencySortMutator
+	// for use by the modules linking this one
+	staticLibNode *graph.DependencyNode
 }
 
 var _ propertyExporter = (*library)(nil)
@@ -345,16 +362,20 @@ func (l *library) getInstallableProps() *InstallableProps {
 }
 
 // Return the shortName of dependencies which must be installed alongside the
-// library. Exclude external libraries - these will never be added via
-// install_deps, but may end up in shared_libs.
+// library. Exclude external libraries, unless they are prebuilt libraries
+// being installed - these will never be added via install_deps, but may
+// end up in shared_libs.
 func (l *library) getInstallDepPhonyNames(ctx blueprint.ModuleContext) []string {
 	return getShortNamesForDirectDepsIf(ctx,
 		func(m blueprint.Module) bool {
 			tag := ctx.OtherModuleDependencyTag(m)
-			// External libraries do not have a build target so don't
-			// try to add a dependency on them.
-			if _, ok := m.(*externalLib); ok {
-				return false
+			// Other external libraries do not have a build target so
+			// don't try to add a dependency on them.
+			if el, ok := m.(*externalLib); ok {
+				_, installed := el.getInstallableProps().getInstallPath()
+				if !installed || len(el.outputs()) == 0 {
+					return false
+				}
 			}
 			if tag == installDepTag || tag == sharedDepTag {
 				return true
@@ -433,12 +454,13 @@ func (l *library) altShortName() string {
 }
 
 func (l *library) getEscapeProperties() []*[]string {
-	return []*[]string{
+	return append([]*[]string{
 		&l.Properties.Asflags,
 		&l.Properties.Cflags,
 		&l.Properties.Conlyflags,
 		&l.Properties.Cxxflags,
-		&l.Properties.Ldflags}
+		&l.Properties.Ldflags},
+		l.Properties.SrcsFlagsProps.getEscapeProperties()...)
 }
 
 func (l *library) getSourceProperties() *SourceProps {
@@ -495,8 +517,9 @@ func (l *library) GetGeneratedHeaders(ctx blueprint.ModuleContext) (includeDirs
 				importHeaderDirs = true
 				// Check top level generated header modules for encapsulated modules
 				visitChildren = true
-			} else if tag == staticDepTag || tag == sharedDepTag || tag == reexportLibsTag {
-				/* Try to import generated header dirs from static|shared_libs too:
+			} else if tag == staticDepTag || tag == sharedDepTag || tag == reexportLibsTag ||
+				tag == headerDepTag {
+				/* Try to import generated header dirs from static|shared|header_libs too:
 				 * - The library could be a bob_generate_shared_library or
 				 *   bob_generate_static_library, in which case we need to import
 				 *   any generated header dirs it exports.
@@ -520,6 +543,12 @@ func (l *library) GetGeneratedHeaders(ctx blueprint.ModuleContext) (includeDirs
 				importHeaderDirs = true
 				// Keep walking encapsulated modules indefinitely
 				visitChildren = true
+			} else if tag == headerDepTag && exportsHeaderLib(parent, child.Name()) {
+				// Header libraries re-exported by a library may
+				// export generated headers of their own.
+				importHeaderDirs = true
+				visitChildren = true
+				childMustBeGenerated = false
 			}
 		}
 
@@ -569,19 +598,41 @@ func (l *library) getAllGeneratedSourceModules(ctx blueprint.ModuleContext) (mod
 	return
 }
 
+// Returns whether a library re-exports the headers of a header library
+// to its users, via export_header_libs.
+func exportsHeaderLib(m blueprint.Module, name string) bool {
+	if l, ok := getLibrary(m); ok {
+		return utils.Contains(l.Properties.Export_header_libs, name)
+	}
+	return false
+}
+
 func (l *library) GetExportedVariables(ctx blueprint.ModuleContext) (expLocalIncludes, expIncludes, expCflags []string) {
 	visited := map[string]bool{}
-	ctx.VisitDirectDeps(func(dep blueprint.Module) {
+	mainModule := ctx.Module()
 
-		if !(ctx.OtherModuleDependencyTag(dep) == wholeStaticDepTag ||
-			ctx.OtherModuleDependencyTag(dep) == staticDepTag ||
-			ctx.OtherModuleDependencyTag(dep) == sharedDepTag ||
-			ctx.OtherModuleDependencyTag(dep) == reexportLibsTag) {
-			return
-		} else if _, ok := visited[dep.Name()]; ok {
-			// VisitDirectDeps will visit a module once for each
+	ctx.WalkDeps(func(dep, parent blueprint.Module) bool {
+		tag := ctx.OtherModuleDependencyTag(dep)
+
+		// Use the exported variables of the direct dependencies, and
+		// of any header libraries they re-export via
+		// export_header_libs.
+		if parent == mainModule {
+			if !(tag == wholeStaticDepTag ||
+				tag == staticDepTag ||
+				tag == sharedDepTag ||
+				tag == reexportLibsTag ||
+				tag == headerDepTag) {
+				return false
+			}
+		} else if tag != headerDepTag || !exportsHeaderLib(parent, dep.Name()) {
+			return false
+		}
+
+		if _, ok := visited[dep.Name()]; ok {
+			// WalkDeps will visit a module once for each
 			// dependency. We've already done this module.
-			return
+			return false
 		}
 		visited[dep.Name()] = true
 
@@ -590,6 +641,8 @@ func (l *library) GetExportedVariables(ctx blueprint.ModuleContext) (expLocalInc
 			expIncludes = append(expIncludes, pe.exportIncludeDirs()...)
 			expCflags = append(expCflags, pe.exportCflags()...)
 		}
+
+		return true
 	})
 
 	return
@@ -631,9 +684,9 @@ func (m *library) filesToInstall(ctx blueprint.BaseModuleContext) []string {
 	return m.outputs()
 }
 
-func (l *library) checkField(cond bool, fieldName string) {
+func (l *library) checkField(ctx blueprint.BaseModuleContext, cond bool, fieldName string) {
 	if !cond {
-		panic(fmt.Sprintf("%s has field %s set", l.Name(), fieldName))
+		propertyErrorf(ctx, fieldName, "not supported by this module type")
 	}
 }
 
@@ -790,6 +843,8 @@ func getBinaryOrSharedLib(m blueprint.Module) (*library, bool) {
 		return &sl.library, true
 	} else if b, ok := m.(*binary); ok {
 		return &b.library, true
+	} else if t, ok := m.(*test); ok {
+		return &t.library, true
 	}
 
 	return nil, false
@@ -800,6 +855,8 @@ func getLibrary(m blueprint.Module) (*library, bool) {
 		return bsl, true
 	} else if sl, ok := m.(*staticLibrary); ok {
 		return &sl.library, true
+	} else if hl, ok := m.(*headerLibrary); ok {
+		return &hl.library, true
 	}
 
 	return nil, false
@@ -807,43 +864,71 @@ func getLibrary(m blueprint.Module) (*library, bool) {
 
 func checkLibraryFieldsMutator(mctx blueprint.BottomUpMutatorContext) {
 	m := mctx.Module()
+	if t, ok := m.(*test); ok {
+		m = &t.binary
+	}
 	if b, ok := m.(*binary); ok {
 		props := b.Properties
-		b.checkField(len(props.Export_cflags) == 0, "export_cflags")
-		b.checkField(len(props.Export_include_dirs) == 0, "export_include_dirs")
-		b.checkField(len(props.Export_ldflags) == 0, "export_ldflags")
-		b.checkField(len(props.Export_local_include_dirs) == 0, "export_local_include_dirs")
-		b.checkField(len(props.Reexport_libs) == 0, "reexport_libs")
-		b.checkField(props.Forwarding_shlib == nil, "forwarding_shlib")
+		b.checkField(mctx, len(props.Export_cflags) == 0, "export_cflags")
+		b.checkField(mctx, len(props.Export_include_dirs) == 0, "export_include_dirs")
+		b.checkField(mctx, len(props.Export_ldflags) == 0, "export_ldflags")
+		b.checkField(mctx, len(props.Export_local_include_dirs) == 0, "export_local_include_dirs")
+		b.checkField(mctx, len(props.Reexport_libs) == 0, "reexport_libs")
+		b.checkField(mctx, props.Forwarding_shlib == nil, "forwarding_shlib")
 	} else if sl, ok := m.(*sharedLibrary); ok {
 		props := sl.Properties
-		sl.checkField(len(props.Export_ldflags) == 0, "export_ldflags")
+		sl.checkField(mctx, len(props.Export_ldflags) == 0, "export_ldflags")
 	} else if sl, ok := m.(*staticLibrary); ok {
 		props := sl.Properties
-		sl.checkField(props.Forwarding_shlib == nil, "forwarding_shlib")
-		sl.checkField(props.Version_script == nil, "version_script")
+		sl.checkField(mctx, props.Forwarding_shlib == nil, "forwarding_shlib")
+		sl.checkField(mctx, props.Version_script == nil, "version_script")
+	} else if hl, ok := m.(*headerLibrary); ok {
+		props := hl.Properties
+		hl.checkField(mctx, len(props.Srcs) == 0, "srcs")
+		hl.checkField(mctx, len(props.Generated_sources) == 0, "generated_sources")
+		hl.checkField(mctx, len(props.Static_libs) == 0, "static_libs")
+		hl.checkField(mctx, len(props.Shared_libs) == 0, "shared_libs")
+		hl.checkField(mctx, len(props.Whole_static_libs) == 0, "whole_static_libs")
+		hl.checkField(mctx, len(props.Ldflags) == 0, "ldflags")
+		hl.checkField(mctx, len(props.Export_ldflags) == 0, "export_ldflags")
+		hl.checkField(mctx, len(props.Ldlibs) == 0, "ldlibs")
+		hl.checkField(mctx, props.Forwarding_shlib == nil, "forwarding_shlib")
+		hl.checkField(mctx, props.Version_script == nil, "version_script")
+		hl.checkField(mctx, len(props.getSanitizers()) == 0, "sanitize")
+		hl.checkField(mctx, props.Lto == nil, "lto")
+		hl.checkField(mctx, props.Coverage == nil, "coverage")
+		hl.checkField(mctx, props.Pch == nil, "pch")
+		hl.checkField(mctx, props.Unity_build == nil, "unity_build")
+		for i, group := range props.SrcsFlagsProps.groups() {
+			hl.checkField(mctx, len(group.Srcs) == 0, srcsFlagsBlocks.propertyName(i+1))
+		}
 	}
 }
 
 // Check that each module only reexports libraries that it is actually using.
 func checkReexportLibsMutator(mctx blueprint.TopDownMutatorContext) {
 	if l, ok := getLibrary(mctx.Module()); ok {
+		used := []string{}
 		for _, lib := range l.Properties.Reexport_libs {
-			if !utils.ListsContain(lib,
+			if utils.ListsContain(lib,
 				l.Properties.Shared_libs,
 				l.Properties.Static_libs,
 				l.Properties.Header_libs,
 				l.Properties.Whole_static_libs,
 				l.Properties.Export_header_libs) {
-				panic(fmt.Errorf("%s reexports unused library %s", mctx.ModuleName(), lib))
+				used = append(used, lib)
+			} else {
+				propertyErrorf(mctx, "reexport_libs", "%s is not used by this module", lib)
 			}
 		}
+		// Drop unused libraries so that later mutators can continue
+		l.Properties.Reexport_libs = used
 	}
 }
 
 // Traverse the dependency tree, following all StaticDepTag and WholeStaticDepTag links.
 // Do *not* include modules which are in the tree via any other dependency tag.
-func getLinkableModules(mctx blueprint.TopDownMutatorContext) map[blueprint.Module]bool {
+func getLinkableModules(mctx blueprint.BaseModuleContext) map[blueprint.Module]bool {
 	ret := make(map[blueprint.Module]bool)
 
 	mctx.WalkDeps(func(dep blueprint.Module, parent blueprint.Module) bool {
@@ -864,22 +949,13 @@ func getLinkableModules(mctx blueprint.TopDownMutatorContext) map[blueprint.Modu
 // Check that no libraries are being accidentally linked twice, by having one copy
 // linked explicitly (via static_libs), and another included in a different
 // library via whole_static_libs.
-func checkForMultipleLinking(topLevelModuleName string, staticLibs map[string]bool, insideWholeLibs map[string]string) {
-	duplicateDeps := []string{}
-	for dep := range staticLibs {
-		if _, ok := insideWholeLibs[dep]; ok {
-			duplicateDeps = append(duplicateDeps, dep)
+func checkForMultipleLinking(ctx blueprint.BaseModuleContext, staticLibs map[string]bool, insideWholeLibs map[string]string) {
+	for _, dep := range utils.SortedKeysBoolMap(staticLibs) {
+		if containingLib, ok := insideWholeLibs[dep]; ok {
+			moduleErrorf(ctx, "links with %s multiple times: %s includes it as a whole_static_lib",
+				dep, containingLib)
 		}
 	}
-
-	if len(duplicateDeps) > 0 {
-		msg := fmt.Sprintf("Warning: %s links with the following libraries multiple times:\n", topLevelModuleName)
-		for _, dep := range duplicateDeps {
-			msg += fmt.Sprintf("  * %s, but also %s, which includes %s as a whole_static_lib\n",
-				dep, insideWholeLibs[dep], dep)
-		}
-		panic(msg)
-	}
 }
 
 // While traversing the static library dependency tree, propagate extra properties.
@@ -898,9 +974,9 @@ func propagateOtherExportedProperties(l *library, depLib propertyExporter) {
 	}
 	props.Ldflags = append(props.Ldflags, depLib.exportLdflags()...)
 
-	// Header libraries are *not* propagated here, because they are currently
-	// only supported on Android, which will automatically re-export them just
-	// by adding them to LOCAL_EXPORT_HEADER_LIBRARY_HEADERS.
+	// Header libraries are *not* propagated here, because they have no
+	// link-time properties. Their include dirs and cflags are picked up
+	// at compile time by GetExportedVariables.
 }
 
 func exportLibFlagsMutator(mctx blueprint.TopDownMutatorContext) {
@@ -927,9 +1003,8 @@ func exportLibFlagsMutator(mctx blueprint.TopDownMutatorContext) {
 		if depLib, ok := dep.(*staticLibrary); ok {
 			for _, subLib := range depLib.Properties.Whole_static_libs {
 				if firstContainingLib, ok := insideWholeLibs[subLib]; ok {
-					panic(fmt.Sprintf("%s links with %s and %s, which both contain %s as whole_static_libs",
-						mctx.Module().Name(), firstContainingLib,
-						depLib.Name(), subLib))
+					moduleErrorf(mctx, "links with %s and %s, which both contain %s as whole_static_libs",
+						firstContainingLib, depLib.Name(), subLib)
 				} else {
 					insideWholeLibs[subLib] = depLib.Name()
 				}
@@ -948,7 +1023,8 @@ func exportLibFlagsMutator(mctx blueprint.TopDownMutatorContext) {
 		} else if depLib, ok := dep.(*externalLib); ok {
 			propagateOtherExportedProperties(l, depLib)
 		} else {
-			panic(fmt.Sprintf("%s is not a staticLibrary", dep.Name()))
+			moduleErrorf(mctx, "%s is not a static library", dep.Name())
+			return
 		}
 
 		// Don't add whole_static_lib components to the library list, because their
@@ -959,77 +1035,209 @@ func exportLibFlagsMutator(mctx blueprint.TopDownMutatorContext) {
 		}
 	})
 
-	checkForMultipleLinking(mctx.ModuleName(), allImportedStaticLibs, insideWholeLibs)
-}
-
-type graphMutatorHandler struct {
-	graph graph.Graph
+	checkForMultipleLinking(mctx, allImportedStaticLibs, insideWholeLibs)
 }
 
 const (
 	maxInt = int(^uint(0) >> 1)
 	minInt = -maxInt - 1
+
+	// Node attribute holding the build.bp that defines a library
+	staticLibFileAttribute = "file"
 )
 
-func (handler *graphMutatorHandler) ResolveDependencySortMutator(mctx blueprint.BottomUpMutatorContext) {
+// staticLibDependencyNode returns the node recording the static
+// library dependencies of a module visited from a static_libs or
+// whole_static_libs property. Modules that don't link anything
+// themselves, such as external libraries, are leaves.
+func staticLibDependencyNode(m blueprint.Module) *graph.DependencyNode {
+	if l, ok := getLibrary(m); ok {
+		return l.staticLibNode
+	}
+	if e, ok := m.(enableable); ok && !isEnabled(e) {
+		return nil
+	}
+	return graph.NewDependencyNode(m.Name(), nil, nil)
+}
+
+// The static library dependencies of every enabled library, recorded
+// for each variant before Blueprint adds the dependencies. Blueprint
+// stops at the first dependency cycle, without saying which properties
+// introduced it, so cycles are found and reported here first.
+var staticLibGraphs = map[tgtType]graph.Graph{}
+var staticLibGraphsLock sync.Mutex
+
+// The cycles in staticLibGraphs, found once every library has been
+// recorded
+var staticLibCycles map[tgtType][]graph.Cycle
+var findStaticLibCyclesOnce sync.Once
+
+// Records the static_libs and whole_static_libs of a library defined
+// in file. A library in both is linked whole.
+func addStaticLibDeps(g graph.Graph, name, file string, staticLibs, wholeStaticLibs []string) {
+	g.AddNode(name)
+	g.SetNodeProperty(name, staticLibFileAttribute, file)
+	for _, lib := range staticLibs {
+		g.AddEdge(name, lib)
+		g.SetEdgeColor(name, lib, "blue")
+	}
+	for _, lib := range wholeStaticLibs {
+		g.AddEdge(name, lib)
+		g.SetEdgeColor(name, lib, "red")
+	}
+}
+
+func recordStaticLibsMutator(mctx blueprint.BottomUpMutatorContext) {
+	if e, ok := mctx.Module().(enableable); ok && !isEnabled(e) {
+		return // No dependencies will be added
+	}
+	l, ok := getLibrary(mctx.Module())
+	if !ok {
+		return
+	}
+
+	staticLibGraphsLock.Lock()
+	defer staticLibGraphsLock.Unlock()
+
+	tgt := l.Properties.TargetType
+	if _, ok := staticLibGraphs[tgt]; !ok {
+		staticLibGraphs[tgt] = graph.NewGraph("static_libs_" + string(tgt))
+	}
+	addStaticLibDeps(staticLibGraphs[tgt], mctx.ModuleName(), mctx.BlueprintsFile(),
+		l.Properties.Static_libs, l.Properties.Whole_static_libs)
+}
+
+// Describes a static library dependency cycle, with the definition
+// that introduced each of its edges
+func staticLibCycleMessage(g graph.Graph, cycle graph.Cycle) string {
+	steps := []string{}
+	for i, from := range cycle.Nodes {
+		to := cycle.Nodes[(i+1)%len(cycle.Nodes)]
+		attributes, _ := g.GetNodeAttributes(from)
+		file := attributes[staticLibFileAttribute]
+
+		property := "static_libs"
+		if cycle.Colors[i] == "red" {
+			property = "whole_static_libs"
+		}
+		steps = append(steps, fmt.Sprintf("%s has %s in %s at %s",
+			from, to, property, propertyLocation(file, from, property)))
+	}
+
+	return "static library dependencies contain a cycle:\n    " + strings.Join(steps, "\n    ")
+}
+
+// Reports each static library dependency cycle on the lowest named
+// library in it, so that it is only reported once
+func checkStaticLibCyclesMutator(mctx blueprint.BottomUpMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok {
+		return
+	}
+
+	findStaticLibCyclesOnce.Do(func() {
+		staticLibGraphsLock.Lock()
+		defer staticLibGraphsLock.Unlock()
+
+		staticLibCycles = map[tgtType][]graph.Cycle{}
+		for tgt, g := range staticLibGraphs {
+			staticLibCycles[tgt] = graph.FindCycles(g)
+		}
+	})
+
+	tgt := l.Properties.TargetType
+	for _, cycle := range staticLibCycles[tgt] {
+		if cycle.Nodes[0] == mctx.ModuleName() {
+			moduleErrorf(mctx, "%s", staticLibCycleMessage(staticLibGraphs[tgt], cycle))
+		}
+	}
+}
+
+func resolveDependencySortMutator(mctx blueprint.BottomUpMutatorContext) {
 	mainModule := mctx.Module()
 	if e, ok := mainModule.(enableable); ok {
 		if !isEnabled(e) {
 			return // Not enabled, so not needed
 		}
 	}
-	if _, ok := mainModule.(*defaults); ok {
-		return // ignore bob_defaults
-	}
-
-	mainModuleName := mainModule.Name()
 
-	handler.graph.AddNode(mainModuleName)
-
-	var mainBuild *Build
-	if buildProps, ok := mainModule.(moduleWithBuildProps); ok {
-		mainBuild = buildProps.build()
-	} else {
+	l, ok := getLibrary(mainModule)
+	if !ok {
 		return // ignore not a build
 	}
 
+	mainModuleName := mainModule.Name()
+	mainBuild := l.build()
+
+	// This is a bottom up mutator, so every dependency has already
+	// recorded its own node. Only read those here, so that modules
+	// can be processed in parallel.
+	depNodes := map[string]*graph.DependencyNode{}
+	mctx.VisitDirectDeps(func(dep blueprint.Module) {
+		tag := mctx.OtherModuleDependencyTag(dep)
+		if tag == staticDepTag || tag == wholeStaticDepTag {
+			if node := staticLibDependencyNode(dep); node != nil {
+				depNodes[dep.Name()] = node
+			}
+		}
+	})
+
+	edges := []graph.DependencyEdge{}
 	for _, lib := range mainBuild.Static_libs {
-		if _, err := handler.graph.AddEdgeToExistingNodes(mainModuleName, lib); err != nil {
-			panic(fmt.Errorf("'%s' depends on '%s', but '%s' is either not defined or disabled", mainModuleName, lib, lib))
+		if node, ok := depNodes[lib]; ok {
+			edges = append(edges, graph.DependencyEdge{Target: node, Color: "blue"})
+		} else {
+			propertyErrorf(mctx, "static_libs", "%s is either not defined or disabled", lib)
 		}
-		handler.graph.SetEdgeColor(mainModuleName, lib, "blue")
 	}
 
 	for _, lib := range mainBuild.Whole_static_libs {
-		if _, err := handler.graph.AddEdgeToExistingNodes(mainModuleName, lib); err != nil {
-			panic(fmt.Errorf("'%s' depends on '%s', but '%s' is either not defined or disabled", mainModuleName, lib, lib))
+		if node, ok := depNodes[lib]; ok {
+			edges = append(edges, graph.DependencyEdge{Target: node, Color: "red"})
+		} else {
+			propertyErrorf(mctx, "whole_static_libs", "%s is either not defined or disabled", lib)
 		}
-		handler.graph.SetEdgeColor(mainModuleName, lib, "red")
 	}
 
-	temporaryPaths := map[string][]string{} // For preserving order in declaration
+	l.staticLibNode = graph.NewDependencyNode(mainModuleName, nil, edges)
+
+	// Work on a private copy of everything this module links, so
+	// that the temporary edges below are not seen by other modules.
+	sub := l.staticLibNode.Subgraph()
 
+	// Preserve the declared order where the dependencies allow it
 	for i, previous := range mainBuild.Static_libs {
 		for j := i + 1; j < len(mainBuild.Static_libs); j++ {
 			lib := mainBuild.Static_libs[j]
-			if !handler.graph.IsReachable(lib, previous) {
-				if handler.graph.AddEdge(previous, lib) {
-					temporaryPaths[previous] = append(temporaryPaths[previous], lib)
-					handler.graph.SetEdgeColor(previous, lib, "pink")
+			if !sub.HasNode(previous) || !sub.HasNode(lib) {
+				continue // Already reported
+			}
+			if !sub.IsReachable(lib, previous) {
+				if sub.AddEdge(previous, lib) {
+					sub.SetEdgeColor(previous, lib, "pink")
 				}
 			}
 		}
 	}
 
-	sub := graph.GetSubgraph(handler.graph, mainModuleName)
+	// Cycles in the declared dependencies were reported by
+	// checkStaticLibCyclesMutator, and ordering edges are only added
+	// where they don't close a loop, so the subgraph can be sorted
+	mainBuild.ResolvedStaticLibs = sortStaticLibs(sub, mainModuleName)
 
-	// Remove temporary path
-	for key, list := range temporaryPaths {
-		for _, value := range list {
-			handler.graph.DeleteEdge(key, value)
-		}
-	}
+	extraStaticLibsDependencies := utils.Difference(mainBuild.ResolvedStaticLibs, mainBuild.Static_libs)
+
+	mctx.AddVariationDependencies(nil, staticDepTag, extraStaticLibsDependencies...)
+
+	// This module may now depend on extra shared libraries, inherited from included
+	// static libraries. Add that dependency here.
+	mctx.AddVariationDependencies(nil, sharedDepTag, mainBuild.ExtraSharedLibs...)
+}
 
+// Returns the static libraries in sub in link order, given the
+// subgraph of everything linked by mainModuleName. The subgraph must
+// not contain cycles.
+func sortStaticLibs(sub graph.Graph, mainModuleName string) []string {
 	// The order of static libraries influences performance by
 	// influencing memory layout. Where possible we want libraries
 	// that depend on each other to be as close as possible. Library
@@ -1049,12 +1257,11 @@ func (handler *graphMutatorHandler) ResolveDependencySortMutator(mctx blueprint.
 	// The node priority is calculated as 'A * importance - cost',
 	// where A is an arbitraty scaling factor.
 	//
-	// This is a bottom up mutator, so by the time we get to a binary
-	// (or shared library), this mutator will have run on all their
-	// dependencies and the (shared) graph will be complete (for the
-	// current module).
+	// The cost of every node is found in one pass over the subgraph,
+	// rather than walking down from each node in turn.
+	costs := graph.GetSubgraphNodeCounts(sub)
 	for _, nodeID := range sub.GetNodes() {
-		cost := graph.GetSubgraphNodeCount(sub, nodeID)
+		cost := costs[nodeID]
 		sources, _ := sub.GetSources(nodeID)
 		priority := len(sources)
 		sub.SetNodePriority(nodeID, (10*priority)-cost)
@@ -1067,22 +1274,8 @@ func (handler *graphMutatorHandler) ResolveDependencySortMutator(mctx blueprint.
 	sub.DeleteProxyEdges("red")
 
 	sub2 := graph.GetSubgraph(sub, mainModuleName)
-	sortedStaticLibs, isDAG := graph.TopologicalSort(sub2)
+	sortedStaticLibs, _ := graph.TopologicalSort(sub2)
 
 	// Pop the module itself from the front of the list
-	sortedStaticLibs = sortedStaticLibs[1:]
-
-	if !isDAG {
-		panic("We have detected cycle: " + mainModuleName)
-	} else {
-		mainBuild.ResolvedStaticLibs = sortedStaticLibs
-	}
-
-	extraStaticLibsDependencies := utils.Difference(mainBuild.ResolvedStaticLibs, mainBuild.Static_libs)
-
-	mctx.AddVariationDependencies(nil, staticDepTag, extraStaticLibsDependencies...)
-
-	// This module may now depend on extra shared libraries, inherited from included
-	// static libraries. Add that dependency here.
-	mctx.AddVariationDependencies(nil, sharedDepTag, mainBuild.ExtraSharedLibs...)
+	return sortedStaticLibs[1:]
 }
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/google/blueprint/parser"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ARM-software/bob-build/internal/graph"
+)
+
+// Build the parsed form of a module on the given line, with a single
+// library dependency property on the next line
+func testLibraryDefinition(line int, name, property string) *parser.Module {
+	nameProp := &parser.Property{Name: "name", NamePos: pos(line+1, 5),
+		Value: &parser.String{LiteralPos: pos(line+1, 11), Value: name}}
+	depsProp := &parser.Property{Name: property, NamePos: pos(line+2, 5),
+		Value: &parser.String{LiteralPos: pos(line+2, 20)}}
+
+	return &parser.Module{
+		Type:    "bob_static_library",
+		TypePos: pos(line, 1),
+		Map:     parser.Map{Properties: []*parser.Property{nameProp, depsProp}},
+	}
+}
+
+func Test_staticLibCycleMessage(t *testing.T) {
+	diagnosticsLock.Lock()
+	parsedBlueprints["build.bp"] = &parser.File{Name: "build.bp", Defs: []parser.Definition{
+		testLibraryDefinition(1, "liba", "static_libs"),
+		testLibraryDefinition(5, "libb", "whole_static_libs"),
+		testLibraryDefinition(9, "libc", "static_libs"),
+	}}
+	diagnosticsLock.Unlock()
+	defer func() {
+		diagnosticsLock.Lock()
+		delete(parsedBlueprints, "build.bp")
+		diagnosticsLock.Unlock()
+	}()
+
+	g := graph.NewGraph("Test")
+	addStaticLibDeps(g, "bin", "build.bp", []string{"liba"}, nil)
+	addStaticLibDeps(g, "liba", "build.bp", []string{"libb"}, nil)
+	addStaticLibDeps(g, "libb", "build.bp", nil, []string{"libc"})
+	addStaticLibDeps(g, "libc", "build.bp", []string{"liba"}, nil)
+
+	cycles := graph.FindCycles(g)
+	assert.Equal(t, 1, len(cycles))
+	assert.Equal(t, "static library dependencies contain a cycle:\n"+
+		"    liba has libb in static_libs at build.bp:3\n"+
+		"    libb has libc in whole_static_libs at build.bp:7\n"+
+		"    libc has liba in static_libs at build.bp:11",
+		staticLibCycleMessage(g, cycles[0]))
+}
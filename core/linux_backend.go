@@ -361,6 +361,61 @@ func (g *linuxGenerator) resourceActions(m *resource, ctx blueprint.ModuleContex
 	addPhony(m, ctx, installDeps, false)
 }
 
+func (g *linuxGenerator) externalLibActions(m *externalLib, ctx blueprint.ModuleContext) {
+	// Only prebuilt libraries have anything to install
+	if len(m.outputs()) == 0 {
+		return
+	}
+	installDeps := g.install(m, ctx)
+	addPhony(m, ctx, installDeps, true)
+}
+
+var disabledModuleRule = pctx.StaticRule("disabled_module",
+	blueprint.RuleParams{
+		Command:     "echo $reason >&2; false",
+		Description: "$out",
+	}, "reason")
+
+type disabledModulesSingleton struct{}
+
+// Disabled modules have no build rules, so requesting one would
+// otherwise fail with ninja reporting an unknown target. Add a target
+// for each disabled module which fails, explaining why it is disabled.
+func (s *disabledModulesSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		e, ok := m.(enableable)
+		if !ok || isEnabled(e) {
+			return
+		}
+		p, ok := m.(phonyInterface)
+		if !ok {
+			return
+		}
+
+		reason := p.shortName() + " is " + disabledReason(e.getEnableableProps())
+		ctx.Build(pctx,
+			blueprint.BuildParams{
+				Rule:     disabledModuleRule,
+				Outputs:  []string{p.shortName()},
+				Args:     map[string]string{"reason": proptools.NinjaAndShellEscape(reason)},
+				Optional: true,
+			})
+	})
+}
+
 func (g *linuxGenerator) init(ctx *blueprint.Context, config *bobConfig) {
+	ctx.RegisterSingletonType("check", func() blueprint.Singleton {
+		return &checkSingleton{g}
+	})
+	ctx.RegisterSingletonType("disabled_modules", func() blueprint.Singleton {
+		return &disabledModulesSingleton{}
+	})
+	ctx.RegisterSingletonType("compile_commands", compileCommandsSingletonFactory)
+	if config.Properties.GetBool("coverage") {
+		ctx.RegisterSingletonType("coverage_report", func() blueprint.Singleton {
+			return &coverageReportSingleton{g}
+		})
+	}
+
 	g.toolchainSet.parseConfig(config)
 }
@@ -21,7 +21,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -56,6 +55,30 @@ var cxxRule = pctx.StaticRule("cxx",
 		Description: "$out",
 	}, "cxxcompiler", "cflags", "cxxflags", "build_wrapper", "depfile")
 
+// Rules used by bob_compiler_rule modules, with and without dependency
+// files
+var customCompileRule = pctx.StaticRule("custom_compile",
+	blueprint.RuleParams{
+		Depfile:     "$out.d",
+		Deps:        blueprint.DepsGCC,
+		Command:     "$build_wrapper $tool $flags $in -o $out",
+		Description: "$out",
+	}, "tool", "flags", "build_wrapper")
+
+var customCompileNoDepsRule = pctx.StaticRule("custom_compile_nodeps",
+	blueprint.RuleParams{
+		Command:     "$build_wrapper $tool $flags $in -o $out",
+		Description: "$out",
+	}, "tool", "flags", "build_wrapper")
+
+var pchRule = pctx.StaticRule("pch",
+	blueprint.RuleParams{
+		Depfile:     "$out.d",
+		Deps:        blueprint.DepsGCC,
+		Command:     "$build_wrapper $cxxcompiler -x c++-header $cflags $cxxflags -MMD -MF $depfile $in -o $out",
+		Description: "$out",
+	}, "cxxcompiler", "cflags", "cxxflags", "build_wrapper", "depfile")
+
 func (l *library) ObjDir() string {
 	return filepath.Join("${BuildDir}", string(l.Properties.TargetType), "objects", l.outputName()) + string(os.PathSeparator)
 }
@@ -63,7 +86,7 @@ func (l *library) ObjDir() string {
 // This function has common support to compile objs for static libs, shared libs and binaries.
 func (l *library) CompileObjs(ctx blueprint.ModuleContext) ([]string, []string) {
 	g := getBackend(ctx)
-	srcs := l.GetSrcs(ctx)
+	srcs, unityBatches := l.getUnitySources(ctx, l.GetSrcs(ctx))
 
 	expLocalIncludes, expIncludes, exportedCflags := l.GetExportedVariables(ctx)
 	// There are 2 sets of include dirs - "global" and "local".
@@ -86,55 +109,145 @@ func (l *library) CompileObjs(ctx blueprint.ModuleContext) ([]string, []string)
 	gendirs, orderOnly := l.GetGeneratedHeaders(ctx)
 	includeDirs = append(includeDirs, gendirs...)
 	includeFlags := utils.PrefixAll(includeDirs, "-I")
+	tc := g.getToolchain(l.Properties.TargetType)
+	pgoCflags, pgoProfiles := l.getPgoCflags(ctx, tc)
 	cflagsList := utils.NewStringSlice(l.Properties.Cflags, l.Properties.Export_cflags,
-		exportedCflags, includeFlags)
+		exportedCflags, l.getSanitizerCflags(tc), l.getLtoCflags(tc), pgoCflags, includeFlags)
 
-	tc := g.getToolchain(l.Properties.TargetType)
 	as, astargetflags := tc.getAssembler()
 	cc, cctargetflags := tc.getCCompiler()
 	cxx, cxxtargetflags := tc.getCXXCompiler()
 
-	ctx.Variable(pctx, "asflags", utils.Join(astargetflags, l.Properties.Asflags))
-	ctx.Variable(pctx, "cflags", utils.Join(cflagsList))
-	ctx.Variable(pctx, "conlyflags", utils.Join(cctargetflags, l.Properties.Conlyflags))
-	ctx.Variable(pctx, "cxxflags", utils.Join(cxxtargetflags, l.Properties.Cxxflags))
+	asflags := utils.Join(astargetflags, l.Properties.Asflags)
+	cflags := utils.Join(cflagsList)
+	conlyflags := utils.Join(cctargetflags, l.Properties.Conlyflags)
+	cxxflags := utils.Join(cxxtargetflags, l.Properties.Cxxflags)
+
+	ctx.Variable(pctx, "asflags", asflags)
+	ctx.Variable(pctx, "cflags", cflags)
+	ctx.Variable(pctx, "conlyflags", conlyflags)
+	ctx.Variable(pctx, "cxxflags", cxxflags)
+
+	coverageflags := utils.Join(l.getCoverageCflags(tc))
+	ctx.Variable(pctx, "coverageflags", coverageflags)
+
+	// The precompiled header is built with the flags of the module's
+	// own C++ sources, including coverage instrumentation. Sources
+	// compiled with other flags can't use it.
+	pchflags := ""
+	pchOrderOnly := []string{}
+	pchCoverage := l.instrumentsSource(false)
+	if l.Properties.Pch != nil {
+		pchflags, pchOrderOnly = l.precompileHeader(ctx, tc, cflags, cxxflags, pchCoverage, coverageflags, orderOnly)
+	}
+
+	srcsFlags := l.Properties.getSrcsFlags(ctx)
+
+	// The module flags passed to bob_compiler_rule tools, as Ninja
+	// variables and as recorded in compile_commands.json
+	moduleFlagVariables := map[string]string{
+		"asflags":    "$asflags",
+		"cflags":     "$cflags",
+		"conlyflags": "$conlyflags",
+		"cxxflags":   "$cxxflags",
+	}
+	moduleFlags := map[string]string{
+		"asflags":    asflags,
+		"cflags":     cflags,
+		"conlyflags": conlyflags,
+		"cxxflags":   cxxflags,
+	}
 
 	objectFiles := []string{}
 	nonCompiledDeps := []string{}
 
 	for _, source := range srcs {
 		var rule blueprint.Rule
+		// The compiler and flags used, recorded in compile_commands.json
+		var compiler string
+		var flags []string
+		// Dependencies which must be built before compiling the source
+		srcOrderOnly := orderOnly
+		// The bob_compiler_rule used, when no built-in rule compiles
+		// the source
+		var customRule *compilerRule
 		args := make(map[string]string)
-		switch path.Ext(source) {
-		case ".s":
+		lang := getSourceLanguage(source)
+		switch lang {
+		case langAsm:
 			args["ascompiler"] = as
 			args["asflags"] = "$asflags"
 			rule = asRule
-		case ".S":
+			compiler, flags = as, []string{asflags}
+		case langAsmCpp:
 			// Assembly with .S suffix must be preprocessed by the C compiler
 			fallthrough
-		case ".c":
+		case langC:
 			args["ccompiler"] = cc
 			args["cflags"] = "$cflags"
 			args["conlyflags"] = "$conlyflags"
 			rule = ccRule
-		case ".cc":
-			fallthrough
-		case ".cpp":
+			compiler, flags = cc, []string{"-c", cflags, conlyflags}
+		case langCxx:
 			args["cxxcompiler"] = cxx
 			args["cflags"] = "$cflags"
 			args["cxxflags"] = "$cxxflags"
 			rule = cxxRule
+			compiler, flags = cxx, []string{"-c", cflags, cxxflags}
 		default:
-			nonCompiledDeps = append(nonCompiledDeps, getBackendPathInSourceDir(g, source))
-			continue
+			var ok bool
+			if customRule, ok = getCompilerRule(source); !ok {
+				nonCompiledDeps = append(nonCompiledDeps, getBackendPathInSourceDir(g, source))
+				continue
+			}
+			args["tool"] = *customRule.Properties.Tool
+			rule = customCompileNoDepsRule
+			if customRule.Properties.hasDepfile() {
+				rule = customCompileRule
+			}
+			compiler = *customRule.Properties.Tool
+		}
+
+		// Flags from srcs_flags follow the module's flags, so that they
+		// can override them
+		extra, hasSrcsFlags := srcsFlags[source]
+		if hasSrcsFlags && lang != langNone {
+			if rule == asRule {
+				args["asflags"] += " " + utils.Join(extra.Asflags)
+				flags = append(flags, extra.Asflags...)
+			} else if rule == cxxRule {
+				args["cflags"] += " " + utils.Join(extra.Cflags)
+				args["cxxflags"] += " " + utils.Join(extra.Cxxflags)
+				flags = append(flags, utils.NewStringSlice(extra.Cflags, extra.Cxxflags)...)
+			} else {
+				args["cflags"] += " " + utils.Join(extra.Cflags)
+				args["conlyflags"] += " " + utils.Join(extra.Conlyflags)
+				flags = append(flags, utils.NewStringSlice(extra.Cflags, extra.Conlyflags)...)
+			}
 		}
 
 		buildWrapper, buildWrapperDeps := l.Properties.Build.getBuildWrapperAndDeps(ctx)
 		args["build_wrapper"] = buildWrapper
 
+		buildDir := g.buildDir()
+		inBuildDir := strings.HasPrefix(source, buildDir)
+		batchMembers, isBatch := unityBatches[source]
+		generated := inBuildDir && !isBatch
+
+		// Only instrument C and C++ sources, not preprocessed assembly
+		if (lang == langC || lang == langCxx) && l.instrumentsSource(generated) {
+			args["cflags"] += " $coverageflags"
+			flags = append(flags, coverageflags)
+		}
+
+		if lang == langCxx && pchflags != "" && !hasSrcsFlags && l.instrumentsSource(generated) == pchCoverage {
+			args["cxxflags"] += " " + pchflags
+			flags = append(flags, pchflags)
+			srcOrderOnly = utils.NewStringSlice(orderOnly, pchOrderOnly)
+		}
+
 		var sourceWithoutPrefix string
-		if buildDir := g.buildDir(); strings.HasPrefix(source, buildDir) {
+		if inBuildDir {
 			sourceWithoutPrefix = source[len(buildDir):]
 		} else {
 			sourceWithoutPrefix = source
@@ -142,21 +255,100 @@ func (l *library) CompileObjs(ctx blueprint.ModuleContext) ([]string, []string)
 		}
 		output := l.ObjDir() + sourceWithoutPrefix + ".o"
 
+		if customRule != nil {
+			// The dependency file is named after the output, so the
+			// flags can only be finalised here
+			depfile := output + ".d"
+			args["flags"] = utils.Join(customRule.Properties.getFlags(moduleFlagVariables, depfile))
+			flags = customRule.Properties.getFlags(moduleFlags, depfile)
+		}
+
 		ctx.Build(pctx,
 			blueprint.BuildParams{
 				Rule:      rule,
 				Outputs:   []string{output},
 				Inputs:    []string{source},
+				Implicits: pgoProfiles,
 				Args:      args,
-				OrderOnly: utils.NewStringSlice(orderOnly, buildWrapperDeps),
+				OrderOnly: utils.NewStringSlice(srcOrderOnly, buildWrapperDeps),
 				Optional:  true,
 			})
 		objectFiles = append(objectFiles, output)
+
+		addCompileCommand(source, output, compiler, flags)
+
+		// Tools reading compile_commands.json look up the sources
+		// themselves, so list each source in a batch with its flags
+		for _, member := range batchMembers {
+			addCompileCommand(getBackendPathInSourceDir(g, member), output, compiler, flags)
+		}
 	}
 
 	return objectFiles, nonCompiledDeps
 }
 
+// Add an edge precompiling the module's pch header with the flags of its
+// C++ sources, and return the flags to include it and the files the
+// objects using it must wait for. The precompiled header is an
+// order-only dependency of the C++ objects, because their dependency
+// files list the header it was compiled from.
+func (l *library) precompileHeader(ctx blueprint.ModuleContext, tc toolchain,
+	cflags, cxxflags string, coverage bool, coverageflags string, orderOnly []string) (string, []string) {
+
+	g := getBackend(ctx)
+	header := getBackendPathInSourceDir(g, *l.Properties.Pch)
+	base := filepath.Join(l.ObjDir(), "pch", filepath.Base(header))
+	pch, flags := tc.getPchFlags(base)
+	cxx, _ := tc.getCXXCompiler()
+
+	buildWrapper, buildWrapperDeps := l.Properties.Build.getBuildWrapperAndDeps(ctx)
+
+	pchCflags := "$cflags"
+	compileFlags := []string{"-x", "c++-header", cflags}
+	if coverage {
+		pchCflags += " $coverageflags"
+		compileFlags = append(compileFlags, coverageflags)
+	}
+	compileFlags = append(compileFlags, cxxflags)
+
+	ctx.Build(pctx,
+		blueprint.BuildParams{
+			Rule:    pchRule,
+			Outputs: []string{pch},
+			Inputs:  []string{header},
+			Args: map[string]string{
+				"cxxcompiler":   cxx,
+				"cflags":        pchCflags,
+				"cxxflags":      "$cxxflags",
+				"build_wrapper": buildWrapper,
+			},
+			OrderOnly: utils.NewStringSlice(orderOnly, buildWrapperDeps),
+			Optional:  true,
+		})
+
+	addCompileCommand(header, pch, cxx, compileFlags)
+
+	deps := []string{pch}
+
+	// Compilers which find the precompiled header next to the header
+	// they include fall back to that header when the precompiled
+	// header can't be used, so put a copy of it there. Quoted includes
+	// in the copy are looked up in the original header's directory.
+	if utils.Contains(flags, base) {
+		flags = append(flags, "-iquote", filepath.Dir(header))
+		ctx.Build(pctx,
+			blueprint.BuildParams{
+				Rule:     copyRule,
+				Outputs:  []string{base},
+				Inputs:   []string{header},
+				Optional: true,
+			})
+		deps = append(deps, base)
+	}
+
+	return utils.Join(flags), deps
+}
+
 // Returns all the source files for a C/C++ library. This includes any sources that are generated.
 func (l *library) GetSrcs(ctx blueprint.ModuleContext) []string {
 	srcs := l.Properties.getSources(ctx)
@@ -207,10 +399,11 @@ func (l *library) GetStaticLibs(ctx blueprint.ModuleContext) []string {
 			libs = append(libs, sl.outputs()...)
 		} else if sl, ok := dep.(*generateStaticLibrary); ok {
 			libs = append(libs, sl.outputs()...)
-		} else if _, ok := dep.(*externalLib); ok {
+		} else if el, ok := dep.(*externalLib); ok {
 			// External static libraries are added to the link using the flags
 			// exported by their ldlibs and ldflags properties, rather than by
-			// specifying the filename here.
+			// specifying the filename here, unless they are prebuilt.
+			libs = append(libs, el.outputs()...)
 		} else {
 			panic(errors.New(ctx.OtherModuleName(dep) + " is not a static library"))
 		}
@@ -223,9 +416,9 @@ func (l *library) GetStaticLibs(ctx blueprint.ModuleContext) []string {
 // Note that we need to remove the old library, else we will not remove the old object files
 var staticLibraryRule = pctx.StaticRule("static_library",
 	blueprint.RuleParams{
-		Command:     "rm -f $out && $build_wrapper $ar -rcs $out $in",
+		Command:     "rm -f $out && $build_wrapper $ar $arflags $out $in",
 		Description: "$out",
-	}, "ar", "build_wrapper")
+	}, "ar", "arflags", "build_wrapper")
 
 var _ = pctx.StaticVariable("whole_static_tool", "${BobScriptsDir}/whole_static.py")
 var wholeStaticLibraryRule = pctx.StaticRule("whole_static_library",
@@ -246,7 +439,10 @@ func (g *linuxGenerator) staticActions(m *staticLibrary, ctx blueprint.ModuleCon
 	buildWrapper, buildWrapperDeps := m.Properties.Build.getBuildWrapperAndDeps(ctx)
 
 	tc := g.getToolchain(m.Properties.TargetType)
-	arBinary, _ := tc.getArchiver()
+	arBinary, arFlags := tc.getArchiver()
+	if m.Properties.getLtoMode() != "" {
+		arBinary, arFlags = tc.getLtoArchiver()
+	}
 
 	args := map[string]string{
 		"ar":            arBinary,
@@ -257,8 +453,11 @@ func (g *linuxGenerator) staticActions(m *staticLibrary, ctx blueprint.ModuleCon
 	implicits := wholeStaticLibs
 
 	if len(wholeStaticLibs) > 0 {
+		// whole_static.py runs the archiver with its own flags
 		rule = wholeStaticLibraryRule
 		args["whole_static_libs"] = strings.Join(wholeStaticLibs, " ")
+	} else {
+		args["arflags"] = utils.Join(arFlags)
 	}
 
 	// The archiver rules do not allow adding arguments that the user can
@@ -280,6 +479,13 @@ func (g *linuxGenerator) staticActions(m *staticLibrary, ctx blueprint.ModuleCon
 	addPhony(m, ctx, installDeps, !isBuiltByDefault(m))
 }
 
+func (g *linuxGenerator) headerActions(m *headerLibrary, ctx blueprint.ModuleContext) {
+	// There is nothing to build, but the phony target generates any
+	// headers the library uses or exports
+	_, generatedHeaders := m.GetGeneratedHeaders(ctx)
+	addPhony(m, ctx, generatedHeaders, !isBuiltByDefault(m))
+}
+
 // This section contains functions that are common for shared libraries and executables.
 
 // Convert a path to a library into a compiler flag.
@@ -301,10 +507,11 @@ func (g *linuxGenerator) getSharedLibLinkPaths(ctx blueprint.ModuleContext) (lib
 		func(m blueprint.Module) {
 			if t, ok := m.(targetableModule); ok {
 				libs = append(libs, g.getSharedLibLinkPath(t))
-			} else if _, ok := m.(*externalLib); ok {
+			} else if el, ok := m.(*externalLib); ok {
 				// Don't try and guess the path to external libraries,
-				// and as they are outside of the build we don't need to
-				// add a dependency on them anyway.
+				// but depend on prebuilt ones so that we relink when
+				// they change.
+				libs = append(libs, el.outputs()...)
 			} else {
 				panic(errors.New(ctx.OtherModuleName(m) + " doesn't support targets"))
 			}
@@ -318,10 +525,11 @@ func (g *linuxGenerator) getSharedLibTocPaths(ctx blueprint.ModuleContext) (libs
 		func(m blueprint.Module) {
 			if l, ok := m.(sharedLibProducer); ok {
 				libs = append(libs, g.getSharedLibTocPath(l))
-			} else if _, ok := m.(*externalLib); ok {
+			} else if el, ok := m.(*externalLib); ok {
 				// Don't try and guess the path to external libraries,
-				// and as they are outside of the build we don't need to
-				// add a dependency on them anyway.
+				// but depend on prebuilt ones so that we relink when
+				// they change.
+				libs = append(libs, el.outputs()...)
 			} else {
 				panic(errors.New(ctx.OtherModuleName(m) + " doesn't produce a shared library"))
 			}
@@ -365,6 +573,8 @@ func (l *library) getSharedLibFlags(ctx blueprint.ModuleContext) (ldlibs []strin
 					libPaths = utils.AppendIfUnique(libPaths, installPath)
 				}
 			} else if el, ok := m.(*externalLib); ok {
+				// Prebuilt libraries are linked using their path
+				ldlibs = append(ldlibs, el.outputs()...)
 				ldlibs = append(ldlibs, el.exportLdlibs()...)
 				ldflags = append(ldflags, el.exportLdflags()...)
 			} else {
@@ -408,6 +618,8 @@ func (g *linuxGenerator) getCommonLibArgs(l *library, ctx blueprint.ModuleContex
 	}
 
 	sharedLibLdlibs, sharedLibLdflags := l.getSharedLibFlags(ctx)
+	buildLdflags := utils.NewStringSlice(l.getSanitizerLdflags(tc), l.getLtoLdflags(tc),
+		l.getCoverageLdflags(tc), l.getPgoLdflags(ctx, tc))
 
 	linker := tc.getLinker().getTool()
 	tcLdflags := tc.getLinker().getFlags()
@@ -425,7 +637,7 @@ func (g *linuxGenerator) getCommonLibArgs(l *library, ctx blueprint.ModuleContex
 	sharedLibDir := g.sharedLibsDir(l.Properties.TargetType)
 	args := map[string]string{
 		"build_wrapper":   buildWrapper,
-		"ldflags":         utils.Join(tcLdflags, ldflags, sharedLibLdflags),
+		"ldflags":         utils.Join(tcLdflags, ldflags, buildLdflags, sharedLibLdflags),
 		"linker":          linker,
 		"shared_libs_dir": sharedLibDir,
 		"shared_libs_flags": utils.Join(append(sharedLibLdlibs,
@@ -598,3 +810,87 @@ func (g *linuxGenerator) binaryActions(m *binary, ctx blueprint.ModuleContext) {
 	installDeps := g.install(m, ctx)
 	addPhony(m, ctx, installDeps, optional)
 }
+
+var testRule = pctx.StaticRule("test",
+	blueprint.RuleParams{
+		Command:     "rm -f $out; $ld_library_path$timeout$wrapper$in $args && touch $out",
+		Description: "test $in",
+	}, "args", "ld_library_path", "timeout", "wrapper")
+
+// Returns the command used to run tests of the given variant, and
+// whether they can be run at all. Host tests are run directly. Target
+// tests can only be run through TARGET_TEST_WRAPPER, such as an
+// emulator, as the target may not be the build machine.
+func getTestWrapper(ctx configProvider, tgt tgtType) (string, bool) {
+	if tgt == tgtTypeHost {
+		return "", true
+	}
+	wrapper := getConfig(ctx).Properties.GetString("target_test_wrapper")
+	return wrapper, wrapper != ""
+}
+
+// Location of the stamp file written when a test passes
+func (g *linuxGenerator) testStampFile(m *test) string {
+	return filepath.Join("${BuildDir}", string(m.getTarget()), "test", m.outputName()+".passed")
+}
+
+func (g *linuxGenerator) testActions(m *test, ctx blueprint.ModuleContext) {
+	g.binaryActions(&m.binary, ctx)
+
+	wrapper, runnable := getTestWrapper(ctx, m.getTarget())
+	if !runnable {
+		return
+	}
+
+	args := map[string]string{
+		"args":            strings.Join(m.Properties.Test_args, " "),
+		"ld_library_path": "LD_LIBRARY_PATH=" + g.sharedLibsDir(m.getTarget()) + ":$$LD_LIBRARY_PATH ",
+		"timeout":         "",
+		"wrapper":         "",
+	}
+	if wrapper != "" {
+		args["wrapper"] = wrapper + " "
+	}
+	if m.Properties.Timeout != nil {
+		args["timeout"] = "timeout " + strconv.FormatInt(*m.Properties.Timeout, 10) + " "
+	}
+
+	// The test is re-run whenever the executable or any of its data
+	// files change. Only a passing run writes the stamp file, so a
+	// failing test will be run again on the next invocation.
+	ctx.Build(pctx,
+		blueprint.BuildParams{
+			Rule:      testRule,
+			Outputs:   []string{g.testStampFile(m)},
+			Inputs:    m.outputs(),
+			Implicits: getBackendPathsInSourceDir(g, m.Properties.Test_data),
+			Args:      args,
+			Optional:  true,
+		})
+}
+
+type checkSingleton struct {
+	g *linuxGenerator
+}
+
+// Add a `check` phony target, which builds and runs every enabled test
+// that can be run
+func (s *checkSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	stamps := []string{}
+
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		if t, ok := m.(*test); ok && isEnabled(t) {
+			if _, runnable := getTestWrapper(ctx, t.getTarget()); runnable {
+				stamps = append(stamps, s.g.testStampFile(t))
+			}
+		}
+	})
+
+	ctx.Build(pctx,
+		blueprint.BuildParams{
+			Rule:     blueprint.Phony,
+			Inputs:   stamps,
+			Outputs:  []string{"check"},
+			Optional: true,
+		})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/fileutils"
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// compileCommand is a single entry in a JSON compilation database, as
+// consumed by clang tooling and IDEs.
+type compileCommand struct {
+	Directory string `json:"directory"`
+	File      string `json:"file"`
+	Command   string `json:"command"`
+	Output    string `json:"output"`
+}
+
+var (
+	// Module build actions are generated in parallel, so protect the
+	// list of compile commands with a lock.
+	compileCommandsLock sync.Mutex
+	compileCommands     []compileCommand
+)
+
+// Record how a source file is compiled. Paths may still contain references
+// to ${SrcDir} and ${BuildDir}; these are expanded when the compilation
+// database is written.
+func addCompileCommand(source, output, compiler string, flags []string) {
+	cmd := utils.Join([]string{compiler}, flags, []string{source, "-o", output})
+
+	compileCommandsLock.Lock()
+	defer compileCommandsLock.Unlock()
+
+	compileCommands = append(compileCommands,
+		compileCommand{File: source, Command: cmd, Output: output})
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+	return abs
+}
+
+type compileCommandsSingleton struct{}
+
+// Write compile_commands.json into the build directory, containing an
+// entry for each source file compiled by an enabled module.
+func (s *compileCommandsSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+
+	// Undo the ninja escaping of the flags as well as expanding the
+	// package variables, so the commands can be passed to a shell.
+	expander := strings.NewReplacer(
+		"${SrcDir}", absPath(getSourceDir()),
+		"${BuildDir}", absPath(getBuildDir()),
+		"${BobScriptsDir}", absPath(getBobScriptsDir()),
+		"$$", "$")
+
+	compileCommandsLock.Lock()
+	defer compileCommandsLock.Unlock()
+
+	entries := make([]compileCommand, len(compileCommands))
+	for i, cc := range compileCommands {
+		entries[i] = compileCommand{
+			Directory: workDir,
+			File:      expander.Replace(cc.File),
+			Command:   expander.Replace(cc.Command),
+			Output:    expander.Replace(cc.Output),
+		}
+	}
+
+	// Keep the output stable, regardless of the order modules were
+	// processed in, so the file is only rewritten when it changes.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Output < entries[j].Output
+	})
+
+	text, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+
+	sb := &strings.Builder{}
+	sb.Write(text)
+	sb.WriteString("\n")
+
+	err = fileutils.WriteIfChanged(getPathInBuildDir("compile_commands.json"), sb)
+	if err != nil {
+		utils.Exit(1, err.Error())
+	}
+}
+
+func compileCommandsSingletonFactory() blueprint.Singleton {
+	return &compileCommandsSingleton{}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+var _ = pctx.StaticVariable("coverageReport", "${BobScriptsDir}/coverage_report.py")
+
+// The output is never written, so the report is recreated every time
+// it is requested, picking up the data from the latest runs.
+var coverageReportRule = pctx.StaticRule("coverage_report",
+	blueprint.RuleParams{
+		Command: "${coverageReport} $tool_args --output-dir $output_dir " +
+			"--profile-dir $profile_dir $object_dirs $in",
+		Description: "$out",
+	}, "tool_args", "output_dir", "profile_dir", "object_dirs")
+
+type coverageReportSingleton struct {
+	g *linuxGenerator
+}
+
+// Add a `coverage_report` phony target, which merges the coverage data
+// written by the instrumented modules into a report for each target
+// type that has any.
+func (s *coverageReportSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	objectDirs := map[tgtType][]string{}
+	linked := map[tgtType][]string{}
+
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		l, ok := getLibrary(m)
+		if !ok || !isEnabled(l) {
+			return
+		}
+		tgt := l.Properties.TargetType
+		if l.Properties.CoverageInstrumented {
+			objectDirs[tgt] = append(objectDirs[tgt], l.ObjDir())
+		}
+		if l.Properties.LinkCoverage {
+			linked[tgt] = append(linked[tgt], m.(phonyInterface).outputs()...)
+		}
+	})
+
+	reports := []string{}
+	for _, tgt := range []tgtType{tgtTypeHost, tgtTypeTarget} {
+		if len(objectDirs[tgt]) == 0 {
+			continue
+		}
+		tc := s.g.getToolchain(tgt)
+		report := "coverage_report_" + string(tgt)
+
+		ctx.Build(pctx,
+			blueprint.BuildParams{
+				Rule:    coverageReportRule,
+				Outputs: []string{report},
+				Inputs:  linked[tgt],
+				Args: map[string]string{
+					"tool_args":   utils.Join(tc.getCoverageReportFlags()),
+					"output_dir":  coverageReportDir(tgt),
+					"profile_dir": coverageProfileDir(tgt),
+					"object_dirs": utils.Join(utils.PrefixAll(objectDirs[tgt], "--object-dir ")),
+				},
+				Optional: true,
+			})
+		reports = append(reports, report)
+	}
+
+	ctx.Build(pctx,
+		blueprint.BuildParams{
+			Rule:     blueprint.Phony,
+			Inputs:   reports,
+			Outputs:  []string{"coverage_report"},
+			Optional: true,
+		})
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sync"
+
+	"github.com/google/blueprint"
+)
+
+// LtoProps defines properties used to build with link-time optimization
+type LtoProps struct {
+	// The link-time optimization mode: "full", "thin" or "none". When
+	// set on a binary or shared library, this is also used by the
+	// static libraries it links, unless they set it themselves.
+	Lto *string
+
+	// The strongest mode used by the modules linking this static
+	// library
+	InheritedLto string `blueprint:"mutated"`
+	// The mode used to link a binary or shared library. This takes
+	// into account the static libraries it links, which may be built
+	// for LTO because another module links them.
+	LinkLto string `blueprint:"mutated"`
+}
+
+const (
+	ltoModeFull = "full"
+	ltoModeThin = "thin"
+	ltoModeNone = "none"
+)
+
+// Orders the modes, so that when a static library is linked into
+// modules with different modes, it uses the strongest. Full LTO
+// objects can be linked with thin ones.
+var ltoModeRank = map[string]int{
+	"":          0,
+	ltoModeThin: 1,
+	ltoModeFull: 2,
+}
+
+// Protects InheritedLto, which is set on a static library by every
+// module that links it
+var ltoLock sync.Mutex
+
+// Returns the stronger of two modes
+func strongestLtoMode(a, b string) string {
+	if ltoModeRank[b] > ltoModeRank[a] {
+		return b
+	}
+	return a
+}
+
+// Returns the mode to compile this module's objects with, or "" if it
+// does not use LTO
+func (props *LtoProps) getLtoMode() string {
+	if props.Lto != nil {
+		if *props.Lto == ltoModeNone {
+			return ""
+		}
+		return *props.Lto
+	}
+	return props.InheritedLto
+}
+
+// Returns the flags needed to compile this module's objects
+func (l *library) getLtoCflags(tc toolchain) []string {
+	mode := l.Properties.getLtoMode()
+	if mode == "" {
+		return []string{}
+	}
+	// Unsupported modes were reported by checkLtoMutator
+	cflags, _ := tc.getLtoCompileFlags(mode)
+	return cflags
+}
+
+// Returns the flags needed to link a binary or shared library
+func (l *library) getLtoLdflags(tc toolchain) []string {
+	if l.Properties.LinkLto == "" {
+		return []string{}
+	}
+	return tc.getLinker().getLtoFlags(l.Properties.LinkLto)
+}
+
+// Validate the lto property, and pass the mode used by each binary and
+// shared library on to the static libraries it links.
+func ltoMutator(mctx blueprint.TopDownMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	if lto := l.Properties.Lto; lto != nil {
+		if *lto != ltoModeFull && *lto != ltoModeThin && *lto != ltoModeNone {
+			propertyErrorf(mctx, "lto", "must be one of \"full\", \"thin\" or \"none\", not \"%s\"", *lto)
+			l.Properties.Lto = nil
+			return
+		}
+	}
+
+	if _, ok := getBinaryOrSharedLib(mctx.Module()); !ok {
+		return
+	}
+
+	mode := l.Properties.getLtoMode()
+	if mode == "" {
+		return
+	}
+
+	modulesToVisit := getLinkableModules(mctx)
+	mctx.VisitDepsDepthFirst(func(dep blueprint.Module) {
+		if _, ok := modulesToVisit[dep]; !ok {
+			return
+		}
+		if depLib, ok := dep.(*staticLibrary); ok {
+			ltoLock.Lock()
+			defer ltoLock.Unlock()
+
+			props := &depLib.Properties.LtoProps
+			props.InheritedLto = strongestLtoMode(props.InheritedLto, mode)
+		}
+	})
+}
+
+// Choose the mode to link each binary and shared library with. The
+// linker must be able to read the objects in every static library it
+// links, so use the strongest mode of any of them.
+func ltoLinkMutator(mctx blueprint.TopDownMutatorContext) {
+	l, ok := getBinaryOrSharedLib(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	mode := l.Properties.getLtoMode()
+
+	modulesToVisit := getLinkableModules(mctx)
+	mctx.VisitDepsDepthFirst(func(dep blueprint.Module) {
+		if _, ok := modulesToVisit[dep]; !ok {
+			return
+		}
+		if depLib, ok := dep.(*staticLibrary); ok {
+			mode = strongestLtoMode(mode, depLib.Properties.getLtoMode())
+		}
+	})
+
+	l.Properties.LinkLto = mode
+}
+
+// Check that the toolchain used by each module supports the LTO mode it
+// asks for. Static libraries which inherit their mode use the same
+// toolchain as the module they inherit it from, so only the module
+// which set it is reported. This is not run on Android, where the
+// Android build system chooses the flags.
+func checkLtoMutator(mctx blueprint.BottomUpMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) || l.Properties.Lto == nil {
+		return
+	}
+
+	mode := l.Properties.getLtoMode()
+	if mode == "" {
+		return
+	}
+
+	tc := getBackend(mctx).getToolchain(l.Properties.TargetType)
+	if _, err := tc.getLtoCompileFlags(mode); err != nil {
+		propertyErrorf(mctx, "lto", "%s", err)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getLtoMode(t *testing.T) {
+	props := LtoProps{}
+	assert.Equal(t, "", props.getLtoMode())
+
+	props.InheritedLto = strongestLtoMode(props.InheritedLto, ltoModeThin)
+	assert.Equal(t, ltoModeThin, props.getLtoMode())
+
+	props.InheritedLto = strongestLtoMode(props.InheritedLto, ltoModeFull)
+	props.InheritedLto = strongestLtoMode(props.InheritedLto, ltoModeThin)
+	assert.Equal(t, ltoModeFull, props.getLtoMode(), "The strongest inherited mode should be used")
+
+	props.Lto = proptools.StringPtr(ltoModeNone)
+	assert.Equal(t, "", props.getLtoMode(), "The module's own setting should override the inherited mode")
+
+	props.Lto = proptools.StringPtr(ltoModeThin)
+	assert.Equal(t, ltoModeThin, props.getLtoMode())
+}
+
+func Test_getLtoCompileFlags(t *testing.T) {
+	flags, err := toolchainClangCommon{}.getLtoCompileFlags(ltoModeThin)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-flto=thin"}, flags)
+
+	flags, err = toolchainGnuCommon{}.getLtoCompileFlags(ltoModeFull)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-flto"}, flags)
+
+	_, err = toolchainGnuCommon{}.getLtoCompileFlags(ltoModeThin)
+	assert.Error(t, err, "GCC should not support thin LTO")
+
+	_, err = toolchainArmClang{}.getLtoCompileFlags(ltoModeFull)
+	assert.Error(t, err, "Arm Compiler should not support LTO")
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// numberedBlocks describes a fixed number of property blocks named
+// <name>_1 to <name>_<count>. Blueprint cannot unpack lists of maps, so
+// these are used where a module needs a list of blocks.
+type numberedBlocks struct {
+	name  string
+	count int
+}
+
+// propertyName returns the name of the Nth block, counting from 1, as
+// used in build.bp files
+func (b numberedBlocks) propertyName(n int) string {
+	return fmt.Sprintf("%s_%d", b.name, n)
+}
+
+// fieldName returns the name of the struct field holding the Nth block
+func (b numberedBlocks) fieldName(n int) string {
+	return featurePropertyName(b.propertyName(n))
+}
+
+// hasFieldName returns whether a struct field name is used by one of the
+// blocks
+func (b numberedBlocks) hasFieldName(name string) bool {
+	for n := 1; n <= b.count; n++ {
+		if name == b.fieldName(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns a struct field of the given type for each block, for use
+// in generated property structures
+func (b numberedBlocks) fields(blockType reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, b.count)
+	for n := 1; n <= b.count; n++ {
+		fields[n-1] = reflect.StructField{Name: b.fieldName(n), Type: blockType}
+	}
+	return fields
+}
+
+// values returns the field holding each block in a struct, in order
+func (b numberedBlocks) values(v reflect.Value) []reflect.Value {
+	values := make([]reflect.Value, b.count)
+	for n := 1; n <= b.count; n++ {
+		values[n-1] = v.FieldByName(b.fieldName(n))
+		if !values[n-1].IsValid() {
+			panic(fmt.Sprintf("%s has no field %s", v.Type(), b.fieldName(n)))
+		}
+	}
+	return values
+}
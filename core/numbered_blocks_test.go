@@ -0,0 +1,48 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testNumberedProps struct {
+	Block_1 string
+	Block_2 string
+}
+
+func Test_numberedBlocks(t *testing.T) {
+	blocks := numberedBlocks{"block", 2}
+
+	assert.Equal(t, "block_2", blocks.propertyName(2))
+	assert.Equal(t, "Block_2", blocks.fieldName(2))
+	assert.True(t, blocks.hasFieldName("Block_1"))
+	assert.False(t, blocks.hasFieldName("Block_3"))
+
+	fields := blocks.fields(reflect.TypeOf(""))
+	assert.Equal(t, 2, len(fields))
+	assert.Equal(t, "Block_1", fields[0].Name)
+
+	props := testNumberedProps{"a", "b"}
+	values := blocks.values(reflect.ValueOf(&props).Elem())
+	assert.Equal(t, "a", values[0].Interface())
+	assert.Equal(t, "b", values[1].Interface())
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// Profile-guided optimization on Linux uses the properties in
+// AndroidPGOProps. A module uses PGO when it sets `pgo.profile_file`.
+// When the PGO_GENERATE option is enabled, these modules are
+// instrumented to write a profile. Otherwise they are optimized with
+// the profile, if it exists.
+
+// Returns whether this module uses profile-guided optimization
+func (props *AndroidPGOProps) usesPgo() bool {
+	return props.Pgo.Profile_file != nil
+}
+
+// Returns whether the profile should be used to optimize the module
+func (props *AndroidPGOProps) pgoProfileUseEnabled() bool {
+	return props.usesPgo() &&
+		(props.Pgo.Enable_profile_use == nil || *props.Pgo.Enable_profile_use)
+}
+
+// Returns whether modules using PGO are being instrumented
+func isPgoGenerateEnabled(ctx configProvider) bool {
+	return getConfig(ctx).Properties.GetBool("pgo_generate")
+}
+
+// Returns the directory instrumented programs write their profiles to.
+// This must be absolute, as the programs may be run from anywhere.
+func pgoProfileDir(tgt tgtType) string {
+	return filepath.Join(absPath(getBuildDir()), "pgo", string(tgt))
+}
+
+// Returns the path of the profile this module is optimized with, relative
+// to the source directory, or "" if it should not be used. As on
+// Android, a missing profile disables the optimization, so that modules
+// can be built before a profile has been collected.
+//
+// The build is regenerated when the profile changes. A missing profile
+// is reported, and the build is regenerated when the closest existing
+// directory above it changes, so that a profile collected later is
+// picked up.
+func (l *library) getPgoProfile(ctx blueprint.ModuleContext) string {
+	props := &l.Properties.AndroidPGOProps
+	if !props.pgoProfileUseEnabled() || isPgoGenerateEnabled(ctx) {
+		return ""
+	}
+
+	profile := filepath.Join(projectModuleDir(ctx), *props.Pgo.Profile_file)
+	if _, err := os.Stat(getPathInSourceDir(profile)); err != nil {
+		dir := filepath.Dir(getPathInSourceDir(profile))
+		for {
+			if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		ctx.AddNinjaFileDeps(dir)
+		propertyWarningf(ctx, "pgo.profile_file",
+			"profile %s does not exist, so profile-guided optimization is not used", profile)
+		return ""
+	}
+
+	ctx.AddNinjaFileDeps(getPathInSourceDir(profile))
+	return profile
+}
+
+// Returns the .gcda files in a GCC profile directory, relative to it,
+// and the directories searched
+func findGcdaFiles(profileDir string) (files, dirs []string) {
+	filepath.Walk(profileDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		} else if filepath.Ext(path) == ".gcda" {
+			rel, _ := filepath.Rel(profileDir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return
+}
+
+// Returns the files read when optimizing with a profile, relative to the
+// source directory. GCC profiles are directories of .gcda files, and
+// Ninja only tracks the modification time of a directory, so each
+// .gcda file is listed. The build is regenerated when a directory in
+// the profile changes, so that new .gcda files are picked up.
+func pgoProfileFiles(ctx blueprint.ModuleContext, profile string) []string {
+	info, err := os.Stat(getPathInSourceDir(profile))
+	if err != nil || !info.IsDir() {
+		return []string{profile}
+	}
+
+	files, dirs := findGcdaFiles(getPathInSourceDir(profile))
+	ctx.AddNinjaFileDeps(dirs...)
+	return utils.PrefixDirs(files, profile)
+}
+
+// Returns the flags needed to compile this module with profile-guided
+// optimization, along with the profiles they read
+func (l *library) getPgoCflags(ctx blueprint.ModuleContext, tc toolchain) (cflags, profiles []string) {
+	props := &l.Properties.AndroidPGOProps
+	if !props.usesPgo() {
+		return []string{}, []string{}
+	}
+
+	// Unsupported toolchains were reported by checkPgoMutator
+	if isPgoGenerateEnabled(ctx) {
+		cflags, _, _ = tc.getPgoGenerateFlags(pgoProfileDir(l.Properties.TargetType))
+	} else if profile := l.getPgoProfile(ctx); profile != "" {
+		cflags, _ = tc.getPgoUseFlags(getBackendPathInSourceDir(getBackend(ctx), profile))
+		for _, file := range pgoProfileFiles(ctx, profile) {
+			profiles = append(profiles, getBackendPathInSourceDir(getBackend(ctx), file))
+		}
+	} else {
+		return []string{}, []string{}
+	}
+
+	return utils.NewStringSlice(cflags, props.Pgo.Cflags), profiles
+}
+
+// Returns the flags needed to link the profiling runtime into a binary
+// or shared library, when it or any static library it links is
+// instrumented
+func (l *library) getPgoLdflags(ctx blueprint.ModuleContext, tc toolchain) []string {
+	if !isPgoGenerateEnabled(ctx) {
+		return []string{}
+	}
+
+	// Static libraries linked indirectly, through other static
+	// libraries, need the profiling runtime too
+	instrumented := l.Properties.AndroidPGOProps.usesPgo()
+	modulesToVisit := getLinkableModules(ctx)
+	ctx.VisitDepsDepthFirst(func(dep blueprint.Module) {
+		if _, ok := modulesToVisit[dep]; !ok {
+			return
+		}
+		if sl, ok := dep.(*staticLibrary); ok && sl.Properties.AndroidPGOProps.usesPgo() {
+			instrumented = true
+		}
+	})
+
+	if !instrumented {
+		return []string{}
+	}
+	_, ldflags, _ := tc.getPgoGenerateFlags(pgoProfileDir(l.Properties.TargetType))
+	return ldflags
+}
+
+// Check that the toolchain used by each module supports profile-guided
+// optimization. This is only run on Linux; on Android, the Android
+// build system chooses the flags.
+func checkPgoMutator(mctx blueprint.BottomUpMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) || !l.Properties.AndroidPGOProps.usesPgo() {
+		return
+	}
+
+	var err error
+	tc := getBackend(mctx).getToolchain(l.Properties.TargetType)
+	if isPgoGenerateEnabled(mctx) {
+		_, _, err = tc.getPgoGenerateFlags(pgoProfileDir(l.Properties.TargetType))
+	} else {
+		_, err = tc.getPgoUseFlags(*l.Properties.Pgo.Profile_file)
+	}
+	if err != nil {
+		propertyErrorf(mctx, "pgo", "%s", err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pgoProfileUseEnabled(t *testing.T) {
+	props := AndroidPGOProps{}
+	assert.False(t, props.usesPgo())
+	assert.False(t, props.pgoProfileUseEnabled())
+
+	props.Pgo.Profile_file = proptools.StringPtr("app.profdata")
+	assert.True(t, props.usesPgo())
+	assert.True(t, props.pgoProfileUseEnabled(), "The profile should be used by default")
+
+	props.Pgo.Enable_profile_use = proptools.BoolPtr(false)
+	assert.True(t, props.usesPgo())
+	assert.False(t, props.pgoProfileUseEnabled())
+}
+
+func Test_getPgoFlags(t *testing.T) {
+	cflags, ldflags, err := toolchainGnuCommon{}.getPgoGenerateFlags("/out/pgo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-fprofile-generate=/out/pgo"}, cflags)
+	assert.Equal(t, []string{"-fprofile-generate=/out/pgo"}, ldflags)
+
+	cflags, err = toolchainGnuCommon{}.getPgoUseFlags("profiles")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-fprofile-use=profiles", "-fprofile-correction"}, cflags)
+
+	cflags, ldflags, err = toolchainClangCommon{}.getPgoGenerateFlags("/out/pgo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-fprofile-generate=/out/pgo"}, cflags)
+	assert.Equal(t, []string{"-fprofile-generate=/out/pgo"}, ldflags)
+
+	cflags, err = toolchainClangCommon{}.getPgoUseFlags("app.profdata")
+	assert.NoError(t, err)
+	assert.Equal(t, "-fprofile-use=app.profdata", cflags[0])
+
+	_, err = toolchainArmClang{}.getPgoUseFlags("app.profdata")
+	assert.Error(t, err, "Arm Compiler should not support PGO")
+}
+
+func Test_findGcdaFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bob_pgo")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	for _, file := range []string{"main.gcda", "src/foo.gcda", "src/foo.gcno"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte{}, 0644))
+	}
+
+	files, dirs := findGcdaFiles(dir)
+	assert.Equal(t, []string{"main.gcda", "src/foo.gcda"}, files)
+	assert.Equal(t, []string{dir, filepath.Join(dir, "src")}, dirs)
+}
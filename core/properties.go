@@ -47,6 +47,11 @@ func defaultApplierMutator(mctx blueprint.TopDownMutatorContext) {
 
 	visited := map[string]bool{}
 
+	// Track whether the enabled property has been set, to find out
+	// which defaults set it
+	enableableProps := enableablePropsOf(mctx.Module())
+	enabledSet := enableableProps == nil || enableableProps.Enabled != nil
+
 	mctx.WalkDeps(func(dep blueprint.Module, parent blueprint.Module) bool {
 		if mctx.OtherModuleDependencyTag(dep) == defaultDepTag {
 			//print("Visiting " + mctx.OtherModuleName(dep) + " for dependency " + mctx.ModuleName() + "\n")
@@ -78,6 +83,13 @@ func defaultApplierMutator(mctx blueprint.TopDownMutatorContext) {
 				}
 			}
 
+			if !enabledSet && enableableProps.Enabled != nil {
+				enabledSet = true
+				if !*enableableProps.Enabled {
+					recordDisabledByDefaults(mctx, enableableProps, def)
+				}
+			}
+
 			return true // This return value indicates if we want to continue visiting children.
 		}
 		return false
@@ -130,6 +142,8 @@ func templateApplierMutator(mctx blueprint.TopDownMutatorContext) {
 		// need to apply templates with the core set, as well as
 		// host-specific and target-specific sets (where applicable).
 		props := append([]interface{}{}, m.featurableProperties()...)
+		// Prefix of each property set's names in build.bp
+		prefixes := make([]string, len(props))
 
 		if ts, ok := module.(targetSpecificLibrary); ok {
 			host := ts.getTargetSpecific(tgtTypeHost)
@@ -137,10 +151,13 @@ func templateApplierMutator(mctx blueprint.TopDownMutatorContext) {
 
 			props = append(props, host.getTargetSpecificProps())
 			props = append(props, target.getTargetSpecificProps())
+			prefixes = append(prefixes, string(tgtTypeHost)+".", string(tgtTypeTarget)+".")
 		}
 
-		for _, p := range props {
-			ApplyTemplate(p, cfgProps)
+		for i, p := range props {
+			for _, e := range ApplyTemplate(p, cfgProps) {
+				propertyErrorf(mctx, prefixes[i]+e.property, "%s", e.err)
+			}
 		}
 	}
 }
@@ -149,6 +166,8 @@ func templateApplierMutator(mctx blueprint.TopDownMutatorContext) {
 type propmap struct {
 	dst []interface{}
 	src *Features
+	// Prefix of the property names in build.bp
+	prefix string
 }
 
 // Applies feature specific properties within each module
@@ -162,7 +181,7 @@ func featureApplierMutator(mctx blueprint.TopDownMutatorContext) {
 		// FeatureApplier mutator is run first. We need to flatten the
 		// feature specific properties in the core set, and where
 		// supported, the host-specific and target-specific set.
-		var props = []propmap{propmap{m.featurableProperties(), m.features()}}
+		var props = []propmap{propmap{m.featurableProperties(), m.features(), ""}}
 
 		// Apply features in target-specific properties.
 		// This should happen for all modules which support host:{} and target:{}
@@ -171,8 +190,8 @@ func featureApplierMutator(mctx blueprint.TopDownMutatorContext) {
 			target := ts.getTargetSpecific(tgtTypeTarget)
 
 			var tgtprops = []propmap{
-				propmap{[]interface{}{host.getTargetSpecificProps()}, &host.Features},
-				propmap{[]interface{}{target.getTargetSpecificProps()}, &target.Features},
+				propmap{[]interface{}{host.getTargetSpecificProps()}, &host.Features, string(tgtTypeHost) + "."},
+				propmap{[]interface{}{target.getTargetSpecificProps()}, &target.Features, string(tgtTypeTarget) + "."},
 			}
 			props = append(props, tgtprops...)
 
@@ -186,11 +205,13 @@ func featureApplierMutator(mctx blueprint.TopDownMutatorContext) {
 			err := prop.src.AppendProps(prop.dst, cfgProps)
 			if err != nil {
 				if propertyErr, ok := err.(*proptools.ExtendPropertyError); ok {
-					mctx.PropertyErrorf(propertyErr.Property, "%s", propertyErr.Err.Error())
+					propertyErrorf(mctx, prop.prefix+propertyErr.Property, "%s", propertyErr.Err)
 				} else {
 					panic(err)
 				}
 			}
 		}
+
+		recordDisabledByFeatures(mctx, m, cfgProps)
 	}
 }
@@ -0,0 +1,335 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/graph"
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+var (
+	queryModules string
+	queryVariant string
+	queryOut     string
+	whyDisabled  string
+	whyDepends   string
+	whyShortest  bool
+	whyMaxPaths  int
+)
+
+func init() {
+	flag.StringVar(&queryModules, "query-modules", "",
+		"Comma separated list of modules to print the resolved properties of")
+	flag.StringVar(&queryVariant, "query-variant", "",
+		"Only print the given variant (host or target) of query-modules")
+	flag.StringVar(&queryOut, "query-out", "",
+		"Output file name for query results. Defaults to stdout")
+	flag.StringVar(&whyDisabled, "why-disabled", "",
+		"Print why each variant of the given module is disabled")
+	flag.StringVar(&whyDepends, "why-depends", "",
+		"Print every dependency path between two modules, given as <from>,<to>")
+	flag.BoolVar(&whyShortest, "why-shortest", false,
+		"Only print the shortest path found by -why-depends")
+	flag.IntVar(&whyMaxPaths, "why-max-paths", 100,
+		"Maximum number of paths printed by -why-depends for each pair of variants, or 0 for no limit")
+}
+
+// The properties of a module after all mutators have run. Host and
+// target specific properties have already been merged into the main
+// properties, so are not included.
+type queryBuildProps struct {
+	CommonProps
+	BuildProps
+}
+
+// A queryResult describes the final state of one variant of a module
+type queryResult struct {
+	Module      string           `json:"module"`
+	Variant     tgtType          `json:"variant,omitempty"`
+	Enabled     bool             `json:"enabled"`
+	Required    bool             `json:"required"`
+	Reason      string           `json:"disabled_reason,omitempty"`
+	InstallPath *string          `json:"install_path,omitempty"`
+	Build       *queryBuildProps `json:"build,omitempty"`
+}
+
+type queryHandler struct {
+	modules []string
+	variant tgtType
+	out     string
+	// Print why modules are disabled, rather than all their properties
+	whyDisabled bool
+	// The modules to print the dependency paths between, and the
+	// dependencies of every module, labelled with their tags
+	whyDepends []string
+	shortest   bool
+	maxPaths   int
+	deps       graph.Graph
+
+	lock    sync.Mutex
+	results []queryResult
+}
+
+func initQueryHandler() *queryHandler {
+	if len(queryModules) < 1 && len(whyDisabled) < 1 && len(whyDepends) < 1 {
+		return nil
+	}
+
+	if queryVariant != "" && queryVariant != string(tgtTypeHost) && queryVariant != string(tgtTypeTarget) {
+		utils.Exit(1, "Invalid -query-variant '"+queryVariant+"', must be host or target")
+	}
+
+	handler := &queryHandler{
+		variant: tgtType(queryVariant),
+		out:     queryOut,
+	}
+
+	if len(whyDisabled) > 0 {
+		handler.modules = []string{whyDisabled}
+		handler.whyDisabled = true
+	} else if len(whyDepends) > 0 {
+		handler.whyDepends = utils.Trim(strings.Split(whyDepends, ","))
+		if len(handler.whyDepends) != 2 {
+			utils.Exit(1, "Invalid -why-depends '"+whyDepends+"', must be <from>,<to>")
+		}
+		handler.shortest = whyShortest
+		handler.maxPaths = whyMaxPaths
+		handler.deps = graph.NewGraph("why-depends")
+	} else {
+		handler.modules = utils.Trim(strings.Split(queryModules, ","))
+	}
+
+	return handler
+}
+
+func (handler *queryHandler) queryMutator(mctx blueprint.BottomUpMutatorContext) {
+	module := mctx.Module()
+	if handler.deps != nil {
+		handler.recordDependencies(mctx)
+		return
+	}
+	if !utils.Contains(handler.modules, module.Name()) {
+		return
+	}
+
+	result := queryResult{Module: module.Name()}
+
+	if t, ok := module.(interface{ getTarget() tgtType }); ok {
+		result.Variant = t.getTarget()
+	}
+	if handler.variant != "" && result.Variant != handler.variant {
+		return
+	}
+
+	if e, ok := module.(enableable); ok {
+		result.Enabled = isEnabled(e)
+		result.Required = isRequired(e)
+		if !result.Enabled {
+			result.Reason = disabledReason(e.getEnableableProps())
+		}
+	}
+
+	if i, ok := module.(installable); ok {
+		if path, ok := i.getInstallableProps().getInstallPath(); ok {
+			result.InstallPath = &path
+		}
+	}
+
+	if b, ok := module.(moduleWithBuildProps); ok {
+		build := b.build()
+		result.Build = &queryBuildProps{build.CommonProps, build.BuildProps}
+	}
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	handler.results = append(handler.results, result)
+}
+
+// Names a node of the dependency graph after the module and its variant
+func queryNodeName(m blueprint.Module) string {
+	if t, ok := m.(interface{ getTarget() tgtType }); ok && t.getTarget() != "" {
+		return m.Name() + " (" + string(t.getTarget()) + ")"
+	}
+	return m.Name()
+}
+
+// Adds the module's direct dependencies to the dependency graph. Each
+// edge is labelled with the tags of all the dependencies between the
+// two modules.
+func (handler *queryHandler) recordDependencies(mctx blueprint.BottomUpMutatorContext) {
+	targets := []string{}
+	tags := map[string][]string{}
+
+	mctx.VisitDirectDeps(func(dep blueprint.Module) {
+		target := queryNodeName(dep)
+		tag := "other"
+		if t, ok := mctx.OtherModuleDependencyTag(dep).(dependencyTag); ok {
+			tag = t.name
+		}
+
+		if _, ok := tags[target]; !ok {
+			targets = append(targets, target)
+		}
+		tags[target] = utils.AppendIfUnique(tags[target], tag)
+	})
+	sort.Strings(targets)
+
+	// Each module only adds its own edges, and the graph does its
+	// own locking
+	source := queryNodeName(mctx.Module())
+	handler.deps.AddNode(source)
+	for _, target := range targets {
+		handler.deps.AddEdge(source, target)
+		handler.deps.SetEdgeProperty(source, target, "label", strings.Join(tags[target], ","))
+	}
+}
+
+func (handler *queryHandler) writeResults() {
+	sort.Slice(handler.results, func(i, j int) bool {
+		if handler.results[i].Module != handler.results[j].Module {
+			return handler.results[i].Module < handler.results[j].Module
+		}
+		return handler.results[i].Variant < handler.results[j].Variant
+	})
+
+	var text []byte
+	if handler.whyDisabled {
+		text = handler.whyDisabledText()
+	} else if handler.deps != nil {
+		text = handler.whyDependsText()
+	} else {
+		var err error
+		text, err = json.MarshalIndent(handler.results, "", "    ")
+		if err != nil {
+			utils.Exit(1, err.Error())
+		}
+		text = append(text, '\n')
+	}
+
+	if handler.out == "" {
+		os.Stdout.Write(text)
+	} else if err := ioutil.WriteFile(handler.out, text, 0644); err != nil {
+		utils.Exit(1, err.Error())
+	}
+}
+
+// Describes whether each result is enabled, one line per variant
+func (handler *queryHandler) whyDisabledText() []byte {
+	if len(handler.results) == 0 {
+		utils.Exit(1, "Unknown module "+strings.Join(handler.modules, ", "))
+	}
+
+	var sb strings.Builder
+	for _, result := range handler.results {
+		sb.WriteString(result.Module)
+		if result.Variant != "" {
+			sb.WriteString(" (" + string(result.Variant) + ")")
+		}
+		if result.Enabled {
+			sb.WriteString(": enabled\n")
+		} else {
+			sb.WriteString(": " + result.Reason + "\n")
+		}
+	}
+	return []byte(sb.String())
+}
+
+// Returns the nodes of the dependency graph for every variant of a module
+func (handler *queryHandler) queryNodeVariants(module string) []string {
+	variants := []string{}
+	for _, id := range handler.deps.GetNodes() {
+		if id == module || strings.HasPrefix(id, module+" (") {
+			variants = append(variants, id)
+		}
+	}
+	sort.Strings(variants)
+	return variants
+}
+
+// Describes the dependency paths between the two modules, one line
+// per path, e.g. "a (target) -[static]-> b (target)"
+func (handler *queryHandler) whyDependsText() []byte {
+	from, to := handler.whyDepends[0], handler.whyDepends[1]
+	sources := handler.queryNodeVariants(from)
+	if len(sources) == 0 {
+		utils.Exit(1, "Unknown module "+from)
+	}
+	destinations := handler.queryNodeVariants(to)
+	if len(destinations) == 0 {
+		utils.Exit(1, "Unknown module "+to)
+	}
+
+	var sb strings.Builder
+	for _, source := range sources {
+		for _, destination := range destinations {
+			var paths [][]string
+			if handler.shortest {
+				if path := graph.GetShortestPath(handler.deps, source, destination); path != nil {
+					paths = append(paths, path)
+				}
+			} else {
+				var complete bool
+				paths, complete = graph.GetPaths(handler.deps, source, destination, handler.maxPaths)
+				if !complete {
+					fmt.Fprintf(os.Stderr, "warning: only the first %d paths from %s to %s are printed, "+
+						"use -why-max-paths to print more or -why-shortest to print the shortest\n",
+						handler.maxPaths, source, destination)
+				}
+			}
+
+			for _, path := range paths {
+				sb.WriteString(path[0])
+				for i := 1; i < len(path); i++ {
+					attributes, _ := handler.deps.GetEdgeAttributes(path[i-1], path[i])
+					sb.WriteString(" -[" + attributes["label"] + "]-> " + path[i])
+				}
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	if sb.Len() == 0 {
+		sb.WriteString(from + " does not depend on " + to + "\n")
+	}
+	return []byte(sb.String())
+}
+
+type querySingleton struct {
+	handler *queryHandler
+}
+
+func (m *querySingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	m.handler.writeResults()
+	os.Exit(0)
+}
+
+func (handler *queryHandler) querySingletonFactory() blueprint.Singleton {
+	return &querySingleton{handler}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// SanitizeProps defines properties used to build with the compiler's
+// runtime sanitizers.
+type SanitizeProps struct {
+	Sanitize struct {
+		// Enable AddressSanitizer, which detects out-of-bounds
+		// accesses and use-after-free
+		Address *bool
+		// Enable UndefinedBehaviorSanitizer
+		Undefined *bool
+		// Enable ThreadSanitizer, which detects data races
+		Thread *bool
+		// Enable MemorySanitizer, which detects reads of
+		// uninitialized memory
+		Memory *bool
+		// Other sanitizers to enable, named as in -fsanitize=
+		Misc []string
+	}
+
+	// The sanitizers used by this module and by the static libraries
+	// linked into it. Only set on binaries and shared libraries.
+	LinkSanitizers []string `blueprint:"mutated"`
+}
+
+// Sanitizers which cannot be used in the same program
+var incompatibleSanitizers = [][2]string{
+	{"address", "thread"},
+	{"address", "memory"},
+	{"thread", "memory"},
+}
+
+// Returns the sanitizers enabled on this module, named as in
+// -fsanitize=
+func (props *SanitizeProps) getSanitizers() (sanitizers []string) {
+	s := &props.Sanitize
+	if proptools.Bool(s.Address) {
+		sanitizers = append(sanitizers, "address")
+	}
+	if proptools.Bool(s.Undefined) {
+		sanitizers = append(sanitizers, "undefined")
+	}
+	if proptools.Bool(s.Thread) {
+		sanitizers = append(sanitizers, "thread")
+	}
+	if proptools.Bool(s.Memory) {
+		sanitizers = append(sanitizers, "memory")
+	}
+	return utils.AppendUnique(sanitizers, s.Misc)
+}
+
+// Returns the flags needed to compile this module with its sanitizers
+func (l *library) getSanitizerCflags(tc toolchain) []string {
+	sanitizers := l.Properties.getSanitizers()
+	if len(sanitizers) == 0 {
+		return []string{}
+	}
+	// Unsupported sanitizers were reported by checkSanitizersMutator
+	cflags, _, _ := tc.getSanitizerFlags(sanitizers)
+	return cflags
+}
+
+// Returns the flags needed to link this module with the sanitizers
+// used by it and the static libraries it links
+func (l *library) getSanitizerLdflags(tc toolchain) []string {
+	if len(l.Properties.LinkSanitizers) == 0 {
+		return []string{}
+	}
+	_, ldflags, _ := tc.getSanitizerFlags(l.Properties.LinkSanitizers)
+	return ldflags
+}
+
+// Returns the flags to enable sanitizers with a GCC compatible compiler
+// driver. Passing -fsanitize= when linking makes the driver link the
+// sanitizer runtime libraries.
+func fsanitizeFlags(sanitizers []string) (cflags, ldflags []string) {
+	flag := "-fsanitize=" + strings.Join(sanitizers, ",")
+	return []string{flag, "-fno-omit-frame-pointer"}, []string{flag}
+}
+
+// Check that the toolchain used by each module supports the sanitizers
+// it enables. This is not run on Android, where the Android build
+// system chooses the flags.
+func checkSanitizersMutator(mctx blueprint.BottomUpMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	sanitizers := l.Properties.getSanitizers()
+	if len(sanitizers) == 0 {
+		return
+	}
+
+	tc := getBackend(mctx).getToolchain(l.Properties.TargetType)
+	if _, _, err := tc.getSanitizerFlags(sanitizers); err != nil {
+		propertyErrorf(mctx, "sanitize", "%s", err)
+	}
+}
+
+// Collect the sanitizers used by the static libraries linked into each
+// binary and shared library, so that the sanitizer runtimes are linked
+// even when only a static library enables a sanitizer. This follows the
+// same dependencies as exportLibFlagsMutator.
+func sanitizeMutator(mctx blueprint.TopDownMutatorContext) {
+	l, ok := getBinaryOrSharedLib(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	// Map between a sanitizer and the first module found to use it
+	users := map[string]string{}
+	sanitizers := []string{}
+	addSanitizers := func(m blueprint.Module, props *SanitizeProps) {
+		for _, sanitizer := range props.getSanitizers() {
+			if _, ok := users[sanitizer]; !ok {
+				users[sanitizer] = m.Name()
+				sanitizers = append(sanitizers, sanitizer)
+			}
+		}
+	}
+
+	addSanitizers(mctx.Module(), &l.Properties.SanitizeProps)
+
+	modulesToVisit := getLinkableModules(mctx)
+	mctx.VisitDepsDepthFirst(func(dep blueprint.Module) {
+		if _, ok := modulesToVisit[dep]; !ok {
+			return
+		}
+		if depLib, ok := dep.(*staticLibrary); ok && isEnabled(depLib) {
+			addSanitizers(dep, &depLib.Properties.SanitizeProps)
+		}
+	})
+
+	for _, pair := range incompatibleSanitizers {
+		first, firstOk := users[pair[0]]
+		second, secondOk := users[pair[1]]
+		if firstOk && secondOk {
+			moduleErrorf(mctx, "cannot link code using the %s sanitizer (from %s) with code using the %s sanitizer (from %s)",
+				pair[0], first, pair[1], second)
+		}
+	}
+
+	l.Properties.LinkSanitizers = sanitizers
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getSanitizers(t *testing.T) {
+	props := SanitizeProps{}
+	assert.Equal(t, []string(nil), props.getSanitizers())
+
+	props.Sanitize.Thread = proptools.BoolPtr(true)
+	props.Sanitize.Address = proptools.BoolPtr(false)
+	props.Sanitize.Undefined = proptools.BoolPtr(true)
+	props.Sanitize.Misc = []string{"integer", "undefined"}
+
+	assert.Equal(t, []string{"undefined", "thread", "integer"}, props.getSanitizers())
+}
+
+func Test_getSanitizerFlags(t *testing.T) {
+	sanitizers := []string{"address", "undefined"}
+
+	for _, tc := range []toolchain{toolchainGnuCommon{}, toolchainClangCommon{}} {
+		cflags, ldflags, err := tc.getSanitizerFlags(sanitizers)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"-fsanitize=address,undefined", "-fno-omit-frame-pointer"}, cflags)
+		assert.Equal(t, []string{"-fsanitize=address,undefined"}, ldflags)
+	}
+
+	_, _, err := toolchainGnuCommon{}.getSanitizerFlags([]string{"memory"})
+	assert.Error(t, err, "GCC should not support the memory sanitizer")
+
+	_, _, err = toolchainClangCommon{}.getSanitizerFlags([]string{"memory"})
+	assert.NoError(t, err)
+
+	_, _, err = toolchainArmClang{}.getSanitizerFlags(sanitizers)
+	assert.Error(t, err, "Arm Compiler should not support sanitizers")
+}
@@ -105,6 +105,10 @@ func supportedVariantsMutator(mctx blueprint.TopDownMutatorContext) {
 		}
 		return false
 	})
+
+	if t, ok := sp.(*test); ok {
+		t.applyHostOnly()
+	}
 }
 
 func tgtToString(tgts []tgtType) []string {
@@ -121,6 +125,10 @@ func splitterMutator(mctx blueprint.BottomUpMutatorContext) {
 		variants := tgtToString(s.supportedVariants())
 		if len(variants) == 0 {
 			s.disable()
+			if props := enableablePropsOf(mctx.Module()); props != nil {
+				props.DisabledReason = "disabled because neither host nor target is supported, in " +
+					definitionLocation(mctx, "")
+			}
 		} else {
 			modules := mctx.CreateVariations(variants...)
 			for i, v := range variants {
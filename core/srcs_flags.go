@@ -0,0 +1,140 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// SrcsFlagsGroup holds flags which are only used to compile some of a
+// module's sources
+type SrcsFlagsGroup struct {
+	// The sources to add the flags to. Wildcards can be used.
+	Srcs []string
+	// Flags used for assembly compilation
+	Asflags []string
+	// Flags used for C and C++ compilation
+	Cflags []string
+	// Flags used for C compilation
+	Conlyflags []string
+	// Flags used for C++ compilation
+	Cxxflags []string
+}
+
+// The srcs_flags_N groups available in each module
+var srcsFlagsBlocks = numberedBlocks{"srcs_flags", 8}
+
+// SrcsFlagsProps holds the srcs_flags_N groups. The fields must match
+// srcsFlagsBlocks, as Blueprint needs them declared to set them in
+// features, templates and defaults.
+type SrcsFlagsProps struct {
+	Srcs_flags_1 SrcsFlagsGroup
+	Srcs_flags_2 SrcsFlagsGroup
+	Srcs_flags_3 SrcsFlagsGroup
+	Srcs_flags_4 SrcsFlagsGroup
+	Srcs_flags_5 SrcsFlagsGroup
+	Srcs_flags_6 SrcsFlagsGroup
+	Srcs_flags_7 SrcsFlagsGroup
+	Srcs_flags_8 SrcsFlagsGroup
+}
+
+// Returns the groups, in order
+func (props *SrcsFlagsProps) groups() []*SrcsFlagsGroup {
+	groups := []*SrcsFlagsGroup{}
+	for _, field := range srcsFlagsBlocks.values(reflect.ValueOf(props).Elem()) {
+		groups = append(groups, field.Addr().Interface().(*SrcsFlagsGroup))
+	}
+	return groups
+}
+
+func (props *SrcsFlagsProps) processPaths(ctx blueprint.BaseModuleContext) {
+	prefix := projectModuleDir(ctx)
+	for _, group := range props.groups() {
+		group.Srcs = utils.PrefixDirs(group.Srcs, prefix)
+	}
+}
+
+// Returns the flag properties of every group, so that they can be
+// escaped along with the module's other flags
+func (props *SrcsFlagsProps) getEscapeProperties() []*[]string {
+	escapeProps := []*[]string{}
+	for _, group := range props.groups() {
+		escapeProps = append(escapeProps,
+			&group.Asflags, &group.Cflags, &group.Conlyflags, &group.Cxxflags)
+	}
+	return escapeProps
+}
+
+// Returns the extra flags used to compile each source matched by a
+// group. When a source is matched by more than one group, the flags of
+// each group are used, in order. The Srcs field of the returned groups
+// is not set.
+func (props *SrcsFlagsProps) getSrcsFlags(ctx blueprint.BaseModuleContext) map[string]*SrcsFlagsGroup {
+	srcsFlags := map[string]*SrcsFlagsGroup{}
+	for _, group := range props.groups() {
+		for _, src := range glob(ctx, group.Srcs, []string{}) {
+			flags, ok := srcsFlags[src]
+			if !ok {
+				flags = &SrcsFlagsGroup{}
+				srcsFlags[src] = flags
+			}
+			flags.Asflags = append(flags.Asflags, group.Asflags...)
+			flags.Cflags = append(flags.Cflags, group.Cflags...)
+			flags.Conlyflags = append(flags.Conlyflags, group.Conlyflags...)
+			flags.Cxxflags = append(flags.Cxxflags, group.Cxxflags...)
+		}
+	}
+	return srcsFlags
+}
+
+// Returns the flags used to compile a source, for backends which only
+// support one list of flags per source
+func (group *SrcsFlagsGroup) compileFlags(src string) []string {
+	switch getSourceLanguage(src) {
+	case langAsm:
+		return group.Asflags
+	case langAsmCpp, langC:
+		return utils.NewStringSlice(group.Cflags, group.Conlyflags)
+	case langCxx:
+		return utils.NewStringSlice(group.Cflags, group.Cxxflags)
+	}
+	return []string{}
+}
+
+// Check that each group only names sources the module compiles, so
+// that a typo does not silently drop the flags
+func checkSrcsFlagsMutator(mctx blueprint.BottomUpMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	srcs := l.Properties.getSources(mctx)
+	for i, group := range l.Properties.SrcsFlagsProps.groups() {
+		unknown := utils.Difference(glob(mctx, group.Srcs, []string{}), srcs)
+		if len(unknown) > 0 {
+			propertyErrorf(mctx, srcsFlagsBlocks.propertyName(i+1)+".srcs",
+				"not in the module's srcs: %s", strings.Join(unknown, ", "))
+		}
+	}
+}
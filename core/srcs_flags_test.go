@@ -0,0 +1,52 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_srcsFlagsCompileFlags(t *testing.T) {
+	group := SrcsFlagsGroup{
+		Asflags:    []string{"-DAS"},
+		Cflags:     []string{"-O3"},
+		Conlyflags: []string{"-std=c99"},
+		Cxxflags:   []string{"-fno-rtti"},
+	}
+
+	assert.Equal(t, []string{"-DAS"}, group.compileFlags("src/a.s"))
+	assert.Equal(t, []string{"-O3", "-std=c99"}, group.compileFlags("src/a.S"))
+	assert.Equal(t, []string{"-O3", "-std=c99"}, group.compileFlags("src/a.c"))
+	assert.Equal(t, []string{"-O3", "-fno-rtti"}, group.compileFlags("src/a.cc"))
+	assert.Equal(t, []string{"-O3", "-fno-rtti"}, group.compileFlags("src/a.cpp"))
+	assert.Equal(t, []string{}, group.compileFlags("src/a.h"))
+}
+
+func Test_srcsFlagsGroups(t *testing.T) {
+	props := SrcsFlagsProps{}
+	groups := props.groups()
+
+	assert.Equal(t, srcsFlagsBlocks.count, len(groups))
+	assert.Equal(t, srcsFlagsBlocks.count, reflect.TypeOf(props).NumField(),
+		"Every field should be one of the numbered groups")
+	assert.True(t, groups[0] == &props.Srcs_flags_1)
+	assert.True(t, groups[7] == &props.Srcs_flags_8)
+}
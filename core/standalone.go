@@ -28,8 +28,6 @@ import (
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/bootstrap"
-
-	"github.com/ARM-software/bob-build/internal/graph"
 )
 
 var (
@@ -89,10 +87,14 @@ func Main() {
 	builder_ninja := config.Properties.GetBool("builder_ninja")
 	builder_android_bp := config.Properties.GetBool("builder_android_bp")
 	builder_android_make := config.Properties.GetBool("builder_android_make")
+	builder_cmake := config.Properties.GetBool("builder_cmake")
+	builder_bazel := config.Properties.GetBool("builder_bazel")
 
 	// Depend on the config file
 	pctx.AddNinjaFileDeps(configJSONFile, getPathInBuildDir(".env.hash"))
 
+	initDiagnostics()
+
 	var ctx = blueprint.NewContext()
 
 	registerModuleTypes(func(name string, mf factoryWithConfig) {
@@ -177,7 +179,18 @@ func Main() {
 	ctx.RegisterBottomUpMutator(splitterMutatorName, splitterMutator).Parallel()
 	ctx.RegisterTopDownMutator("target", targetMutator).Parallel()
 	ctx.RegisterBottomUpMutator("process_paths", pathMutator).Parallel()
+	if builder_ninja || builder_cmake || builder_bazel {
+		// External libraries are resolved by Android itself
+		ctx.RegisterBottomUpMutator("pkg_config", pkgConfigMutator).Parallel()
+	}
 	ctx.RegisterTopDownMutator("default_applier", defaultApplierMutator).Parallel()
+	ctx.RegisterBottomUpMutator("compiler_rules", compilerRulesMutator).Parallel()
+	ctx.RegisterBottomUpMutator("record_static_libs", recordStaticLibsMutator).Parallel()
+	ctx.RegisterBottomUpMutator("check_static_lib_cycles", checkStaticLibCyclesMutator).Parallel()
+	// Report problems with module definitions before adding
+	// dependencies, as a missing dependency stops Blueprint at the end
+	// of the depender mutator.
+	ctx.RegisterBottomUpMutator("report_early_diagnostics", reportDiagnosticsMutator).Parallel()
 	ctx.RegisterBottomUpMutator("depender", dependerMutator).Parallel()
 	ctx.RegisterBottomUpMutator("alias", aliasMutator).Parallel()
 	ctx.RegisterBottomUpMutator("generated", generatedDependerMutator).Parallel()
@@ -189,9 +202,31 @@ func Main() {
 	} else {
 
 		ctx.RegisterTopDownMutator("export_lib_flags", exportLibFlagsMutator).Parallel()
-		dependencyGraphHandler := graphMutatorHandler{graph.NewGraph("All")}
+		ctx.RegisterTopDownMutator("sanitize", sanitizeMutator).Parallel()
+		if builder_ninja || builder_cmake || builder_bazel {
+			// On Android, the sanitizer flags are chosen by the
+			// Android build system
+			ctx.RegisterBottomUpMutator("check_sanitizers", checkSanitizersMutator).Parallel()
+		}
+		ctx.RegisterTopDownMutator("lto", ltoMutator).Parallel()
+		ctx.RegisterTopDownMutator("lto_link", ltoLinkMutator).Parallel()
+		if builder_ninja || builder_cmake || builder_bazel {
+			ctx.RegisterBottomUpMutator("check_lto", checkLtoMutator).Parallel()
+		}
+		ctx.RegisterBottomUpMutator("check_srcs_flags", checkSrcsFlagsMutator).Parallel()
+		if builder_ninja {
+			// These are only supported by the Linux backend. On
+			// Android, the PGO flags are chosen by the Android build
+			// system.
+			ctx.RegisterBottomUpMutator("check_pgo", checkPgoMutator).Parallel()
+			ctx.RegisterBottomUpMutator("check_unity_build", checkUnityBuildMutator).Parallel()
+		}
+		if builder_ninja && config.Properties.GetBool("coverage") {
+			ctx.RegisterTopDownMutator("coverage", coverageMutator).Parallel()
+			ctx.RegisterTopDownMutator("coverage_link", coverageLinkMutator).Parallel()
+		}
 		ctx.RegisterBottomUpMutator("sort_resolved_static_libs",
-			dependencyGraphHandler.ResolveDependencySortMutator) // This can't be parallel
+			resolveDependencySortMutator).Parallel()
 		ctx.RegisterTopDownMutator("find_required_modules",
 			findRequiredModulesMutator).Parallel()
 		ctx.RegisterBottomUpMutator("check_disabled_modules",
@@ -204,12 +239,26 @@ func Main() {
 			applyReexportLibsDependenciesMutator).Parallel()
 		ctx.RegisterTopDownMutator("install_group_mutator", installGroupMutator).Parallel()
 		ctx.RegisterTopDownMutator("debug_info_mutator", debugInfoMutator).Parallel()
-		if !builder_android_bp {
-			// The android_bp backend's escape function is a no-op,
-			// so optimize by skipping the mutator
+		if !builder_android_bp && !builder_cmake {
+			// The android_bp and cmake backends' escape functions are
+			// no-ops, so optimize by skipping the mutator
 			ctx.RegisterTopDownMutator("escape_mutator", escapeMutator).Parallel()
 		}
+		// Collect the flags used by {{add_if_supported}}, so the
+		// compiler can check them in batches
+		ctx.RegisterBottomUpMutator("collect_compiler_flags", collectCompilerFlagsMutator).Parallel()
 		ctx.RegisterTopDownMutator("late_template_mutator", lateTemplateMutator).Parallel()
+		// Save the flags the compilers support for the next run
+		ctx.RegisterSingletonType("flag_cache", flagCacheSingletonFactory)
+	}
+	ctx.RegisterBottomUpMutator("report_diagnostics", reportDiagnosticsMutator).Parallel()
+
+	if handler := initQueryHandler(); handler != nil {
+		// Only query after the full set of mutators has been run
+		ctx.RegisterBottomUpMutator("query", handler.queryMutator).Parallel()
+		// Singleton to print the results and stop without
+		// generating the build
+		ctx.RegisterSingletonType("query_singleton", handler.querySingletonFactory)
 	}
 
 	if builder_ninja {
@@ -218,6 +267,10 @@ func Main() {
 		config.Generator = &androidBpGenerator{}
 	} else if builder_android_make {
 		config.Generator = &androidMkGenerator{}
+	} else if builder_cmake {
+		config.Generator = &cmakeGenerator{}
+	} else if builder_bazel {
+		config.Generator = &bazelGenerator{}
 	} else {
 		panic(errors.New("unknown builder backend"))
 	}
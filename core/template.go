@@ -19,13 +19,23 @@ package core
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
 	"text/template"
+
+	"github.com/google/blueprint/proptools"
 )
 
-func applyTemplateString(elem reflect.Value, stringvalues map[string]string, funcmap map[string]interface{}) {
+// A templateError records a property whose template could not be
+// expanded
+type templateError struct {
+	property string
+	err      error
+}
+
+func applyTemplateString(elem reflect.Value, stringvalues map[string]string, funcmap map[string]interface{}) error {
 	if elem.Kind() != reflect.String {
 		panic("elem is not a string")
 	}
@@ -36,45 +46,66 @@ func applyTemplateString(elem reflect.Value, stringvalues map[string]string, fun
 
 	tmpl, err := t.Parse(elem.String())
 	if err != nil {
-		panic("Error parsing string '" + elem.String() + "': " + err.Error())
+		return fmt.Errorf("error parsing string '%s': %s", elem.String(), err.Error())
 	}
 	buf := new(bytes.Buffer)
 	err = tmpl.Execute(buf, stringvalues)
 	if err != nil {
-		panic("Error executing string '" + elem.String() + "': " + err.Error())
+		return fmt.Errorf("error executing string '%s': %s", elem.String(), err.Error())
 	}
 	elem.SetString(buf.String())
+	return nil
 }
 
-func applyTemplateRecursive(propsVal reflect.Value,
-	stringvalues map[string]string, funcmap map[string]interface{}) {
+// Returns the name of a property as it appears in build.bp. Embedded
+// structures do not add a level to the name.
+func templatePropertyName(prefix string, field reflect.StructField) string {
+	if field.Anonymous {
+		return prefix
+	}
+	return prefix + proptools.PropertyNameForField(field.Name)
+}
+
+func applyTemplateRecursive(propsVal reflect.Value, prefix string,
+	stringvalues map[string]string, funcmap map[string]interface{}) (errs []templateError) {
 
 	for i := 0; i < propsVal.NumField(); i++ {
 		field := propsVal.Field(i)
+		propName := templatePropertyName(prefix, propsVal.Type().Field(i))
 
 		switch field.Kind() {
 		case reflect.String:
-			applyTemplateString(field, stringvalues, funcmap)
+			if err := applyTemplateString(field, stringvalues, funcmap); err != nil {
+				errs = append(errs, templateError{propName, err})
+			}
 
 		case reflect.Slice:
 			// Array of strings
 			for j := 0; j < field.Len(); j++ {
 				elem := field.Index(j)
 				if elem.Kind() == reflect.String {
-					applyTemplateString(elem, stringvalues, funcmap)
+					if err := applyTemplateString(elem, stringvalues, funcmap); err != nil {
+						errs = append(errs, templateError{propName, err})
+					}
 				}
 			}
 
 		case reflect.Ptr:
 			tgtField := reflect.Indirect(field)
 			if tgtField.Kind() == reflect.String {
-				applyTemplateString(tgtField, stringvalues, funcmap)
+				if err := applyTemplateString(tgtField, stringvalues, funcmap); err != nil {
+					errs = append(errs, templateError{propName, err})
+				}
 			}
 
 		case reflect.Struct:
-			applyTemplateRecursive(field, stringvalues, funcmap)
+			if !propsVal.Type().Field(i).Anonymous {
+				propName += "."
+			}
+			errs = append(errs, applyTemplateRecursive(field, propName, stringvalues, funcmap)...)
 		}
 	}
+	return
 }
 
 func regMatch(rule string, input string) bool {
@@ -96,8 +127,9 @@ func filter_compiler_flags(flag string) string {
 }
 
 // ApplyTemplate writes configuration values (from properties) into the string
-// properties in props. This is done recursively.
-func ApplyTemplate(props interface{}, properties *configProperties) {
+// properties in props. This is done recursively. Any properties which could
+// not be expanded are returned, named relative to props.
+func ApplyTemplate(props interface{}, properties *configProperties) []templateError {
 	stringvalues := properties.StringMap()
 	funcmap := make(map[string]interface{})
 	funcmap["to_upper"] = strings.ToUpper
@@ -109,5 +141,5 @@ func ApplyTemplate(props interface{}, properties *configProperties) {
 	funcmap["add_if_supported"] = filter_compiler_flags
 	propsVal := reflect.Indirect(reflect.ValueOf(props))
 
-	applyTemplateRecursive(propsVal, stringvalues, funcmap)
+	return applyTemplateRecursive(propsVal, "", stringvalues, funcmap)
 }
@@ -175,3 +175,30 @@ func TestApplyTemplateNested(t *testing.T) {
 	assert.Equalf(t, "alpha1", refA, "refA incorrect")
 	assert.Equalf(t, "beta0", refB, "refB incorrect")
 }
+
+// Check that templates which fail to expand are reported with the
+// property name, and left unchanged
+func TestApplyTemplateErrors(t *testing.T) {
+	config := setupTestConfig(map[string]string{
+		"a": "alpha",
+	})
+
+	arrB := []string{"{{.a}}", "{{.missing}}"}
+
+	props := testNestedProperties{
+		testProperties{StrA: "{{.a", StrB: "{{.a}}"},
+		testProperties{StrArray: arrB},
+	}
+
+	errs := ApplyTemplate(&props, config)
+
+	if assert.Len(t, errs, 2) {
+		assert.Equal(t, "a.strA", errs[0].property)
+		assert.Equal(t, "b.strArray", errs[1].property)
+	}
+
+	assert.Equalf(t, "{{.a", props.A.StrA, "A.StrA incorrect")
+	assert.Equalf(t, "alpha", props.A.StrB, "A.StrB incorrect")
+	assert.Equalf(t, "alpha", arrB[0], "arrB[0] incorrect")
+	assert.Equalf(t, "{{.missing}}", arrB[1], "arrB[1] incorrect")
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2018-2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// TestProps defines the properties specific to bob_test modules
+type TestProps struct {
+	// Arguments passed to the test executable when it is run
+	Test_args []string
+	// Files required by the test at runtime, relative to the build.bp.
+	// Changes to these files cause the test to be re-run.
+	Test_data []string
+	// Maximum time, in seconds, the test is allowed to run for
+	Timeout *int64
+	// Only build and run the test on the host
+	Host_only *bool
+}
+
+// A test is a binary which can be run as part of the `check` target
+type test struct {
+	binary
+
+	Properties struct {
+		TestProps
+	}
+}
+
+// Verify that the following interfaces are implemented
+var _ linkableModule = (*test)(nil)
+var _ splittable = (*test)(nil)
+var _ pathProcessor = (*test)(nil)
+var _ propertyEscapeInterface = (*test)(nil)
+
+func (m *test) isHostOnly() bool {
+	return proptools.Bool(m.Properties.Host_only)
+}
+
+// Restrict a host_only test to the host variant. This is called by
+// supportedVariantsMutator once the defaults have been applied, so that
+// supportedVariants() and shortName() agree with the variants that
+// actually get created.
+func (m *test) applyHostOnly() {
+	if m.isHostOnly() {
+		props := m.getSplittableProps()
+		props.Host_supported = proptools.BoolPtr(true)
+		props.Target_supported = proptools.BoolPtr(false)
+	}
+}
+
+func (m *test) getEscapeProperties() []*[]string {
+	return append(m.binary.getEscapeProperties(), &m.Properties.Test_args)
+}
+
+func (m *test) processPaths(ctx blueprint.BaseModuleContext, g generatorBackend) {
+	m.binary.processPaths(ctx, g)
+
+	m.Properties.Test_data = utils.PrefixDirs(m.Properties.Test_data, projectModuleDir(ctx))
+}
+
+func (m *test) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	if isEnabled(m) {
+		getBackend(ctx).testActions(m, ctx)
+	}
+}
+
+func testFactory(config *bobConfig) (blueprint.Module, []interface{}) {
+	module := &test{}
+	_, props := module.LibraryFactory(config, module)
+	return module, append(props, &module.Properties)
+}
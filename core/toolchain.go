@@ -24,7 +24,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"github.com/ARM-software/bob-build/internal/utils"
 )
@@ -42,6 +41,7 @@ type linker interface {
 	keepSharedLibraryTransitivity() string
 	dropSharedLibraryTransitivity() string
 	getForwardingLibFlags() string
+	getLtoFlags(mode string) []string
 }
 
 type defaultLinker struct {
@@ -82,6 +82,10 @@ func (l defaultLinker) getForwardingLibFlags() string {
 	return "-fuse-ld=bfd"
 }
 
+func (l defaultLinker) getLtoFlags(mode string) []string {
+	return ltoFlags(mode)
+}
+
 func (l defaultLinker) setRpathLink(path string) string {
 	return "-Wl,-rpath-link," + path
 }
@@ -117,6 +121,8 @@ func newDefaultLinker(tool string, flags, libs []string) (linker defaultLinker)
 }
 
 type toolchain interface {
+	// Returns the archiver, and the flags used to create a static
+	// library from objects
 	getArchiver() (tool string, flags []string)
 	getAssembler() (tool string, flags []string)
 	getCCompiler() (tool string, flags []string)
@@ -125,6 +131,84 @@ type toolchain interface {
 	getStripFlags() []string
 	getLibraryTocFlags() []string
 	checkFlagIsSupported(language, flag string) bool
+	// Returns the flags needed to compile and link with the given
+	// sanitizers, or an error if any of them are not supported
+	getSanitizerFlags(sanitizers []string) (cflags, ldflags []string, err error)
+	// Returns the flags needed to compile objects for link-time
+	// optimization, or an error if the mode is not supported
+	getLtoCompileFlags(mode string) ([]string, error)
+	// Returns an archiver which can index objects compiled for
+	// link-time optimization
+	getLtoArchiver() (tool string, flags []string)
+	// Returns the flags needed to compile and link code instrumented
+	// for coverage, or an error if this is not supported. profileDir
+	// is where instrumented programs write their profiles, if the
+	// compiler does not write them next to the objects.
+	getCoverageFlags(profileDir string) (cflags, ldflags []string, err error)
+	// Returns the arguments telling coverage_report.py how to read the
+	// coverage data
+	getCoverageReportFlags() []string
+	// Returns the flags needed to compile and link code instrumented
+	// to write a profile into profileDir for profile-guided
+	// optimization, or an error if this is not supported
+	getPgoGenerateFlags(profileDir string) (cflags, ldflags []string, err error)
+	// Returns the flags needed to compile code optimized with a
+	// profile, or an error if this is not supported
+	getPgoUseFlags(profile string) ([]string, error)
+	// Returns the file to write a precompiled header to, given its path
+	// without an extension, and the flags to include it
+	getPchFlags(base string) (pch string, flags []string)
+}
+
+// Returns the precompiled header path and flags for Clang based
+// compilers, which need the precompiled header to be named explicitly
+func includePchFlags(base string) (string, []string) {
+	pch := base + ".pch"
+	return pch, []string{"-include-pch", pch}
+}
+
+// Returns the flags for Clang's source-based code coverage
+func profileInstrFlags(profileDir string) (cflags, ldflags []string) {
+	flag := "-fprofile-instr-generate=" + filepath.Join(profileDir, "%m.profraw")
+	return []string{flag, "-fcoverage-mapping"}, []string{flag}
+}
+
+// Returns the flags for Clang to instrument code for profile-guided
+// optimization. The raw profiles written to profileDir must be merged
+// with llvm-profdata before they can be used.
+func profileGenerateFlags(profileDir string) (cflags, ldflags []string) {
+	flags := []string{"-fprofile-generate=" + profileDir}
+	return flags, flags
+}
+
+// Returns the flags for Clang to optimize code with a merged profile.
+// Code which has changed since the profile was collected is not
+// optimized, rather than causing warnings.
+func profileUseFlags(profile string) []string {
+	return []string{
+		"-fprofile-use=" + profile,
+		"-Wno-profile-instr-out-of-date",
+		"-Wno-profile-instr-unprofiled",
+	}
+}
+
+// Returns the arguments telling coverage_report.py to merge profiles
+// written by Clang
+func llvmCoverageReportFlags(profdataBinary, covBinary string) []string {
+	return []string{
+		"--format", "llvm",
+		"--llvm-profdata-tool", profdataBinary,
+		"--llvm-cov-tool", covBinary,
+	}
+}
+
+// Returns the flags to select a link-time optimization mode with a GCC
+// compatible compiler driver
+func ltoFlags(mode string) []string {
+	if mode == ltoModeThin {
+		return []string{"-flto=thin"}
+	}
+	return []string{"-flto"}
 }
 
 func lookPathSecond(toolUnqualified string, firstHit string) (string, error) {
@@ -222,70 +306,6 @@ func getFileNameDir(tc toolchain, basename string) (dirs []string) {
 	return
 }
 
-// Type for caching the supported flags of a compiler
-// Cache maps flags+compiler+language to an boolean:
-//    false - not supported
-//    true  - supported
-type flagSupportedCache struct {
-	m    map[string]bool
-	lock sync.RWMutex
-}
-
-func newFlagCache() (cache *flagSupportedCache) {
-	cache = &flagSupportedCache{}
-	cache.m = make(map[string]bool)
-	return
-}
-
-// Check that a toolchain's compiler for 'language' supports the given 'flag'
-func (cache *flagSupportedCache) checkFlag(tc toolchain, language, flag string) bool {
-	compiler := ""
-	flags := []string{}
-	switch language {
-	case "c++":
-		compiler, flags = tc.getCXXCompiler()
-	case "c":
-		compiler, flags = tc.getCCompiler()
-	default:
-		// No other language currently supported
-		return false
-	}
-
-	// The search key is "<flag>/<compiler>/<language>"
-	key := strings.Join([]string{flag, compiler, language}, "/")
-
-	cache.lock.RLock()
-	supported, ok := cache.m[key]
-	cache.lock.RUnlock()
-	if ok {
-		return supported
-	}
-
-	// We have not seen the flag before, check it by running the compiler with the flag
-	// Add a '-Werror' to make sure that the compiler exits with an error code if the
-	// flag is unknown. If the flag starts with '-Wno-' remove the 'no-' part so that
-	// we can test the actual flag. This is to work around the fact that gcc is silent
-	// about '-Wno-<flag_name>' flags it doesn't recognise until you actually compile a file
-	saneFlag := strings.Replace(flag, "-Wno-", "-W", 1)
-	testFlags := utils.NewStringSlice(flags, []string{"-x", language, "-c", os.DevNull, "-o", os.DevNull, "-Werror", saneFlag})
-	testFlags = utils.Remove(testFlags, "")
-	cmd := exec.Command(compiler, testFlags...)
-	_, err := cmd.CombinedOutput()
-	if err == nil {
-		cache.lock.Lock()
-		cache.m[key] = true
-		cache.lock.Unlock()
-		return true
-	}
-
-	// Compiler did not recognise the flag
-	cache.lock.Lock()
-	cache.m[key] = false
-	cache.lock.Unlock()
-
-	return false
-}
-
 type toolchainGnu interface {
 	toolchain
 	getBinDirs() []string
@@ -306,6 +326,8 @@ type toolchainGnuCommon struct {
 	ldflags       []string // Linker flags, including anything required for C++
 	binDir        string
 	flagCache     *flagSupportedCache
+	ltoArBinary   string
+	gcovBinary    string
 }
 
 type toolchainGnuNative struct {
@@ -317,7 +339,7 @@ type toolchainGnuCross struct {
 }
 
 func (tc toolchainGnuCommon) getArchiver() (string, []string) {
-	return tc.arBinary, []string{}
+	return tc.arBinary, []string{"-rcs"}
 }
 
 func (tc toolchainGnuCommon) getAssembler() (string, []string) {
@@ -358,6 +380,61 @@ func (tc toolchainGnuCommon) checkFlagIsSupported(language, flag string) bool {
 	return tc.flagCache.checkFlag(tc, language, flag)
 }
 
+// GCC links the sanitizer runtimes as shared libraries, so sanitized
+// shared libraries do not need to be loaded by a sanitized binary.
+func (tc toolchainGnuCommon) getSanitizerFlags(sanitizers []string) ([]string, []string, error) {
+	if utils.Contains(sanitizers, "memory") {
+		return nil, nil, errors.New("the memory sanitizer is not supported by GCC")
+	}
+	cflags, ldflags := fsanitizeFlags(sanitizers)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainGnuCommon) getLtoCompileFlags(mode string) ([]string, error) {
+	if mode == ltoModeThin {
+		return nil, errors.New("thin LTO is not supported by GCC, use \"full\"")
+	}
+	return ltoFlags(mode), nil
+}
+
+func (tc toolchainGnuCommon) getLtoArchiver() (string, []string) {
+	return tc.ltoArBinary, []string{"-rcs"}
+}
+
+// GCC writes the coverage data of each object next to it, so the
+// profile directory is not used
+func (tc toolchainGnuCommon) getCoverageFlags(profileDir string) ([]string, []string, error) {
+	return []string{"--coverage"}, []string{"--coverage"}, nil
+}
+
+func (tc toolchainGnuCommon) getCoverageReportFlags() []string {
+	return []string{
+		"--format", "gcov",
+		"--gcov-tool", tc.gcovBinary,
+	}
+}
+
+// GCC writes a profile for each object into profileDir, named after the
+// object's path
+func (tc toolchainGnuCommon) getPgoGenerateFlags(profileDir string) ([]string, []string, error) {
+	flags := []string{"-fprofile-generate=" + profileDir}
+	return flags, flags, nil
+}
+
+// GCC looks for a precompiled header next to each header it includes,
+// so include the copy of the header the precompiled header was named
+// after. -Winvalid-pch explains why the copy is used instead if the
+// precompiled header cannot be used.
+func (tc toolchainGnuCommon) getPchFlags(base string) (string, []string) {
+	return base + ".gch", []string{"-Winvalid-pch", "-include", base}
+}
+
+// GCC reads the profile of each object from a directory, and profiles
+// of multi-threaded programs may be inconsistent
+func (tc toolchainGnuCommon) getPgoUseFlags(profile string) ([]string, error) {
+	return []string{"-fprofile-use=" + profile, "-fprofile-correction"}, nil
+}
+
 // The libstdc++ headers shipped with GCC toolchains are stored, relative to
 // the `prefix-gcc` binary's location, in `../$ARCH/include/c++/$VERSION` and
 // `../$ARCH/include/c++/$VERSION/$ARCH`. This function returns $ARCH. This is
@@ -438,10 +515,12 @@ func newToolchainGnuCommon(config *bobConfig, tgt tgtType) (tc toolchainGnuCommo
 	props := config.Properties
 	tc.prefix = props.GetString(string(tgt) + "_gnu_prefix")
 	tc.arBinary = props.GetString(string(tgt) + "_ar_binary")
+	tc.ltoArBinary = props.GetString(string(tgt) + "_lto_ar_binary")
 	tc.asBinary = tc.prefix + props.GetString("as_binary")
 
 	tc.objcopyBinary = props.GetString(string(tgt) + "_objcopy_binary")
 	tc.objdumpBinary = props.GetString(string(tgt) + "_objdump_binary")
+	tc.gcovBinary = props.GetString(string(tgt) + "_gcov_binary")
 
 	tc.gccBinary = tc.prefix + props.GetString(string(tgt)+"_gnu_cc_binary")
 	tc.gxxBinary = tc.prefix + props.GetString(string(tgt)+"_gnu_cxx_binary")
@@ -476,11 +555,14 @@ func newToolchainGnuCross(config *bobConfig) (tc toolchainGnuCross) {
 type toolchainClangCommon struct {
 	// Options read from the config:
 	arBinary       string
+	ltoArBinary    string
 	asBinary       string
 	objcopyBinary  string
 	objdumpBinary  string
 	clangBinary    string
 	clangxxBinary  string
+	profdataBinary string
+	llvmCovBinary  string
 	linker         linker
 	prefix         string
 	useGnuBinutils bool
@@ -511,7 +593,7 @@ func (tc toolchainClangCommon) getArchiver() (string, []string) {
 	if tc.useGnuBinutils {
 		return tc.gnu.getArchiver()
 	}
-	return tc.arBinary, []string{}
+	return tc.arBinary, []string{"-rcs"}
 }
 
 func (tc toolchainClangCommon) getAssembler() (string, []string) {
@@ -551,6 +633,45 @@ func (tc toolchainClangCommon) checkFlagIsSupported(language, flag string) bool
 	return tc.flagCache.checkFlag(tc, language, flag)
 }
 
+// Clang links the sanitizer runtimes statically into binaries, and
+// leaves their symbols undefined in shared libraries.
+func (tc toolchainClangCommon) getSanitizerFlags(sanitizers []string) ([]string, []string, error) {
+	cflags, ldflags := fsanitizeFlags(sanitizers)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainClangCommon) getLtoCompileFlags(mode string) ([]string, error) {
+	return ltoFlags(mode), nil
+}
+
+// LLVM bitcode can only be indexed by LLVM's archiver, so this is used
+// even when the GNU binutils are
+func (tc toolchainClangCommon) getLtoArchiver() (string, []string) {
+	return tc.ltoArBinary, []string{"-rcs"}
+}
+
+func (tc toolchainClangCommon) getCoverageFlags(profileDir string) ([]string, []string, error) {
+	cflags, ldflags := profileInstrFlags(profileDir)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainClangCommon) getCoverageReportFlags() []string {
+	return llvmCoverageReportFlags(tc.profdataBinary, tc.llvmCovBinary)
+}
+
+func (tc toolchainClangCommon) getPgoGenerateFlags(profileDir string) ([]string, []string, error) {
+	cflags, ldflags := profileGenerateFlags(profileDir)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainClangCommon) getPgoUseFlags(profile string) ([]string, error) {
+	return profileUseFlags(profile), nil
+}
+
+func (tc toolchainClangCommon) getPchFlags(base string) (string, []string) {
+	return includePchFlags(base)
+}
+
 func newToolchainClangCommon(config *bobConfig, tgt tgtType) (tc toolchainClangCommon) {
 	props := config.Properties
 	tc.prefix = props.GetString(string(tgt) + "_clang_prefix")
@@ -558,6 +679,7 @@ func newToolchainClangCommon(config *bobConfig, tgt tgtType) (tc toolchainClangC
 	// This assumes arBinary and asBinary are either in the path, or the same directory as clang.
 	// This is not necessarily the case. This will need to be updated when we support clang on linux without a GNU toolchain.
 	tc.arBinary = props.GetString(string(tgt) + "_ar_binary")
+	tc.ltoArBinary = props.GetString(string(tgt) + "_lto_ar_binary")
 	tc.asBinary = tc.prefix + props.GetString("as_binary")
 
 	tc.objcopyBinary = props.GetString(string(tgt) + "_objcopy_binary")
@@ -566,6 +688,9 @@ func newToolchainClangCommon(config *bobConfig, tgt tgtType) (tc toolchainClangC
 	tc.clangBinary = tc.prefix + props.GetString(string(tgt)+"_clang_cc_binary")
 	tc.clangxxBinary = tc.prefix + props.GetString(string(tgt)+"_clang_cxx_binary")
 
+	tc.profdataBinary = props.GetString(string(tgt) + "_llvm_profdata_binary")
+	tc.llvmCovBinary = props.GetString(string(tgt) + "_llvm_cov_binary")
+
 	tc.target = props.GetString(string(tgt) + "_clang_triple")
 
 	if tc.target != "" {
@@ -697,7 +822,7 @@ type toolchainArmClangCross struct {
 }
 
 func (tc toolchainArmClang) getArchiver() (string, []string) {
-	return tc.arBinary, []string{}
+	return tc.arBinary, []string{"-rcs"}
 }
 
 func (tc toolchainArmClang) getAssembler() (string, []string) {
@@ -734,6 +859,40 @@ func (tc toolchainArmClang) checkFlagIsSupported(language, flag string) bool {
 	return tc.flagCache.checkFlag(tc, language, flag)
 }
 
+func (tc toolchainArmClang) getSanitizerFlags(sanitizers []string) ([]string, []string, error) {
+	return nil, nil, errors.New("sanitizers are not supported by Arm Compiler")
+}
+
+// Arm Compiler's LTO requires armlink, rather than the compiler driver,
+// to be used for linking
+func (tc toolchainArmClang) getLtoCompileFlags(mode string) ([]string, error) {
+	return nil, errors.New("LTO is not supported with Arm Compiler")
+}
+
+func (tc toolchainArmClang) getLtoArchiver() (string, []string) {
+	return tc.getArchiver()
+}
+
+func (tc toolchainArmClang) getCoverageFlags(profileDir string) ([]string, []string, error) {
+	return nil, nil, errors.New("coverage is not supported with Arm Compiler")
+}
+
+func (tc toolchainArmClang) getCoverageReportFlags() []string {
+	return []string{}
+}
+
+func (tc toolchainArmClang) getPgoGenerateFlags(profileDir string) ([]string, []string, error) {
+	return nil, nil, errors.New("profile-guided optimization is not supported with Arm Compiler")
+}
+
+func (tc toolchainArmClang) getPgoUseFlags(profile string) ([]string, error) {
+	return nil, errors.New("profile-guided optimization is not supported with Arm Compiler")
+}
+
+func (tc toolchainArmClang) getPchFlags(base string) (string, []string) {
+	return includePchFlags(base)
+}
+
 func newToolchainArmClangCommon(config *bobConfig, tgt tgtType) (tc toolchainArmClang) {
 	props := config.Properties
 	tc.prefix = props.GetString(string(tgt) + "_gnu_prefix")
@@ -815,6 +974,10 @@ func (l xcodeLinker) getForwardingLibFlags() string {
 	return ""
 }
 
+func (l xcodeLinker) getLtoFlags(mode string) []string {
+	return ltoFlags(mode)
+}
+
 func newXcodeLinker(tool string, flags, libs []string) (linker xcodeLinker) {
 	linker.tool = tool
 	linker.flags = flags
@@ -823,18 +986,20 @@ func newXcodeLinker(tool string, flags, libs []string) (linker xcodeLinker) {
 }
 
 type toolchainXcode struct {
-	arBinary    string
-	asBinary    string
-	dsymBinary  string
-	stripBinary string
-	otoolBinary string
-	nmBinary    string
-	ccBinary    string
-	cxxBinary   string
-	linker      linker
-	prefix      string
-	target      string
-	flagCache   *flagSupportedCache
+	arBinary       string
+	asBinary       string
+	dsymBinary     string
+	stripBinary    string
+	otoolBinary    string
+	nmBinary       string
+	ccBinary       string
+	cxxBinary      string
+	profdataBinary string
+	llvmCovBinary  string
+	linker         linker
+	prefix         string
+	target         string
+	flagCache      *flagSupportedCache
 
 	cflags  []string
 	ldflags []string
@@ -849,7 +1014,7 @@ type toolchainXcodeCross struct {
 }
 
 func (tc toolchainXcode) getArchiver() (string, []string) {
-	return tc.arBinary, []string{}
+	return tc.arBinary, []string{"-rcs"}
 }
 
 func (tc toolchainXcode) getAssembler() (string, []string) {
@@ -888,6 +1053,45 @@ func (tc toolchainXcode) checkFlagIsSupported(language, flag string) bool {
 	return tc.flagCache.checkFlag(tc, language, flag)
 }
 
+func (tc toolchainXcode) getSanitizerFlags(sanitizers []string) ([]string, []string, error) {
+	if utils.Contains(sanitizers, "memory") {
+		return nil, nil, errors.New("the memory sanitizer is not supported by Xcode")
+	}
+	cflags, ldflags := fsanitizeFlags(sanitizers)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainXcode) getLtoCompileFlags(mode string) ([]string, error) {
+	return ltoFlags(mode), nil
+}
+
+// Xcode's archiver uses libLTO to index bitcode
+func (tc toolchainXcode) getLtoArchiver() (string, []string) {
+	return tc.getArchiver()
+}
+
+func (tc toolchainXcode) getCoverageFlags(profileDir string) ([]string, []string, error) {
+	cflags, ldflags := profileInstrFlags(profileDir)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainXcode) getCoverageReportFlags() []string {
+	return llvmCoverageReportFlags(tc.profdataBinary, tc.llvmCovBinary)
+}
+
+func (tc toolchainXcode) getPgoGenerateFlags(profileDir string) ([]string, []string, error) {
+	cflags, ldflags := profileGenerateFlags(profileDir)
+	return cflags, ldflags, nil
+}
+
+func (tc toolchainXcode) getPgoUseFlags(profile string) ([]string, error) {
+	return profileUseFlags(profile), nil
+}
+
+func (tc toolchainXcode) getPchFlags(base string) (string, []string) {
+	return includePchFlags(base)
+}
+
 func newToolchainXcodeCommon(config *bobConfig, tgt tgtType) (tc toolchainXcode) {
 	props := config.Properties
 	tc.prefix = props.GetString(string(tgt) + "_xcode_prefix")
@@ -901,6 +1105,9 @@ func newToolchainXcodeCommon(config *bobConfig, tgt tgtType) (tc toolchainXcode)
 	tc.ccBinary = tc.prefix + props.GetString(string(tgt)+"_clang_cc_binary")
 	tc.cxxBinary = tc.prefix + props.GetString(string(tgt)+"_clang_cxx_binary")
 
+	tc.profdataBinary = props.GetString(string(tgt) + "_llvm_profdata_binary")
+	tc.llvmCovBinary = props.GetString(string(tgt) + "_llvm_cov_binary")
+
 	tc.target = props.GetString(string(tgt) + "_xcode_triple")
 
 	if tc.target != "" {
@@ -947,6 +1154,8 @@ func (tcs *toolchainSet) parseConfig(config *bobConfig) {
 		tcs.target = newToolchainArmClangCross(config)
 	} else if props.GetBool("target_toolchain_xcode") {
 		tcs.target = newToolchainXcodeCross(config)
+	} else if props.GetBool("target_toolchain_generic") {
+		tcs.target = newToolchainGenericCross(config)
 	} else {
 		panic(errors.New("no usable target compiler toolchain configured"))
 	}
@@ -959,6 +1168,8 @@ func (tcs *toolchainSet) parseConfig(config *bobConfig) {
 		tcs.host = newToolchainArmClangNative(config)
 	} else if props.GetBool("host_toolchain_xcode") {
 		tcs.host = newToolchainXcodeNative(config)
+	} else if props.GetBool("host_toolchain_generic") {
+		tcs.host = newToolchainGenericNative(config)
 	} else {
 		panic(errors.New("no usable host compiler toolchain configured"))
 	}
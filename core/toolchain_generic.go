@@ -0,0 +1,341 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A tool described by a generic toolchain file, with the flags always
+// passed to it
+type genericTool struct {
+	Tool  string   `json:"tool"`
+	Flags []string `json:"flags"`
+}
+
+// The linker of a generic toolchain. The flag idioms are templates, in
+// which `${path}` is replaced with the path, and `${libs}` with the
+// libraries the flags apply to. Idioms which are not set are not
+// supported, and expand to nothing.
+type genericLinkerDesc struct {
+	genericTool
+	Libs []string `json:"libs"`
+
+	AsNeeded              string `json:"as_needed"`
+	NoAsNeeded            string `json:"no_as_needed"`
+	CopyDtNeeded          string `json:"copy_dt_needed"`
+	NoCopyDtNeeded        string `json:"no_copy_dt_needed"`
+	ForwardingLib         string `json:"forwarding_lib"`
+	RpathLink             string `json:"rpath_link"`
+	VersionScript         string `json:"version_script"`
+	Rpath                 string `json:"rpath"`
+	WholeArchive          string `json:"whole_archive"`
+	RpathSeparator        string `json:"rpath_separator"`
+	WholeArchiveSeparator string `json:"whole_archive_separator"`
+}
+
+// genericToolchainDesc is the contents of a generic toolchain file,
+// describing a compiler family which Bob does not otherwise support
+type genericToolchainDesc struct {
+	Archiver     genericTool       `json:"archiver"`
+	Assembler    genericTool       `json:"assembler"`
+	CCompiler    genericTool       `json:"c_compiler"`
+	CXXCompiler  genericTool       `json:"cxx_compiler"`
+	Linker       genericLinkerDesc `json:"linker"`
+	StripFlags   []string          `json:"strip_flags"`
+	TocFlags     []string          `json:"toc_flags"`
+	PchExtension string            `json:"pch_extension"`
+	PchFlags     []string          `json:"pch_flags"`
+}
+
+// toolchainGeneric implements both the toolchain and linker interfaces
+// from a genericToolchainDesc
+type toolchainGeneric struct {
+	desc      genericToolchainDesc
+	flagCache *flagSupportedCache
+}
+
+type toolchainGenericNative struct {
+	toolchainGeneric
+}
+
+type toolchainGenericCross struct {
+	toolchainGeneric
+}
+
+// Returns the tool's flags, never returning nil so that the result can
+// be used like the other toolchains'
+func (t genericTool) get() (string, []string) {
+	if t.Flags == nil {
+		return t.Tool, []string{}
+	}
+	return t.Tool, t.Flags
+}
+
+// Replace the `${name}` references in an idiom
+func expandGenericIdiom(idiom string, values map[string]string) string {
+	for name, value := range values {
+		idiom = strings.Replace(idiom, "${"+name+"}", value, -1)
+	}
+	return idiom
+}
+
+// The default archiver flags match ar
+func (tc toolchainGeneric) getArchiver() (string, []string) {
+	tool, flags := tc.desc.Archiver.get()
+	if tc.desc.Archiver.Flags == nil {
+		flags = []string{"-rcs"}
+	}
+	return tool, flags
+}
+
+func (tc toolchainGeneric) getAssembler() (string, []string) {
+	return tc.desc.Assembler.get()
+}
+
+func (tc toolchainGeneric) getCCompiler() (string, []string) {
+	return tc.desc.CCompiler.get()
+}
+
+func (tc toolchainGeneric) getCXXCompiler() (string, []string) {
+	return tc.desc.CXXCompiler.get()
+}
+
+func (tc toolchainGeneric) getLinker() linker {
+	return tc
+}
+
+func (tc toolchainGeneric) getStripFlags() []string {
+	return tc.desc.StripFlags
+}
+
+func (tc toolchainGeneric) getLibraryTocFlags() []string {
+	return tc.desc.TocFlags
+}
+
+func (tc toolchainGeneric) checkFlagIsSupported(language, flag string) bool {
+	return tc.flagCache.checkFlag(tc, language, flag)
+}
+
+func (tc toolchainGeneric) getSanitizerFlags(sanitizers []string) ([]string, []string, error) {
+	return nil, nil, errors.New("sanitizers are not supported by generic toolchains")
+}
+
+func (tc toolchainGeneric) getLtoCompileFlags(mode string) ([]string, error) {
+	return nil, errors.New("LTO is not supported by generic toolchains")
+}
+
+func (tc toolchainGeneric) getLtoArchiver() (string, []string) {
+	return tc.getArchiver()
+}
+
+func (tc toolchainGeneric) getCoverageFlags(profileDir string) ([]string, []string, error) {
+	return nil, nil, errors.New("coverage is not supported by generic toolchains")
+}
+
+func (tc toolchainGeneric) getCoverageReportFlags() []string {
+	return []string{}
+}
+
+func (tc toolchainGeneric) getPgoGenerateFlags(profileDir string) ([]string, []string, error) {
+	return nil, nil, errors.New("profile-guided optimization is not supported by generic toolchains")
+}
+
+func (tc toolchainGeneric) getPgoUseFlags(profile string) ([]string, error) {
+	return nil, errors.New("profile-guided optimization is not supported by generic toolchains")
+}
+
+// The precompiled header flags are templates, in which `${header}` is
+// replaced with the header, and `${pch}` with the precompiled header.
+// Without them, Clang's flags are used.
+func (tc toolchainGeneric) getPchFlags(base string) (string, []string) {
+	if tc.desc.PchFlags == nil {
+		return includePchFlags(base)
+	}
+
+	pch := base + tc.desc.PchExtension
+	values := map[string]string{"header": base, "pch": pch}
+	flags := []string{}
+	for _, flag := range tc.desc.PchFlags {
+		flags = append(flags, expandGenericIdiom(flag, values))
+	}
+	return pch, flags
+}
+
+//// Support linker
+
+func (tc toolchainGeneric) getTool() string {
+	return tc.desc.Linker.Tool
+}
+
+func (tc toolchainGeneric) getFlags() []string {
+	_, flags := tc.desc.Linker.get()
+	return flags
+}
+
+func (tc toolchainGeneric) getLibs() []string {
+	if tc.desc.Linker.Libs == nil {
+		return []string{}
+	}
+	return tc.desc.Linker.Libs
+}
+
+func (tc toolchainGeneric) keepUnusedDependencies() string {
+	return tc.desc.Linker.NoAsNeeded
+}
+
+func (tc toolchainGeneric) dropUnusedDependencies() string {
+	return tc.desc.Linker.AsNeeded
+}
+
+func (tc toolchainGeneric) keepSharedLibraryTransitivity() string {
+	return tc.desc.Linker.CopyDtNeeded
+}
+
+func (tc toolchainGeneric) dropSharedLibraryTransitivity() string {
+	return tc.desc.Linker.NoCopyDtNeeded
+}
+
+func (tc toolchainGeneric) getForwardingLibFlags() string {
+	return tc.desc.Linker.ForwardingLib
+}
+
+// LTO is rejected by getLtoCompileFlags, so no link flags are needed
+func (tc toolchainGeneric) getLtoFlags(mode string) []string {
+	return []string{}
+}
+
+func (tc toolchainGeneric) setRpathLink(path string) string {
+	return expandGenericIdiom(tc.desc.Linker.RpathLink, map[string]string{"path": path})
+}
+
+func (tc toolchainGeneric) setVersionScript(path string) string {
+	return expandGenericIdiom(tc.desc.Linker.VersionScript, map[string]string{"path": path})
+}
+
+// The rpath idiom is used for each path, joined by rpath_separator,
+// which defaults to a space
+func (tc toolchainGeneric) setRpath(paths []string) string {
+	if len(paths) == 0 || tc.desc.Linker.Rpath == "" {
+		return ""
+	}
+	sep := tc.desc.Linker.RpathSeparator
+	if sep == "" {
+		sep = " "
+	}
+	flags := []string{}
+	for _, path := range paths {
+		flags = append(flags, expandGenericIdiom(tc.desc.Linker.Rpath, map[string]string{"path": path}))
+	}
+	return strings.Join(flags, sep)
+}
+
+// The whole_archive idiom is used once for all the libraries, which
+// are joined by whole_archive_separator, defaulting to a space. It must
+// reference `${libs}`.
+func (tc toolchainGeneric) linkWholeArchives(libs []string) string {
+	if len(libs) == 0 {
+		return ""
+	}
+	sep := tc.desc.Linker.WholeArchiveSeparator
+	if sep == "" {
+		sep = " "
+	}
+	return expandGenericIdiom(tc.desc.Linker.WholeArchive,
+		map[string]string{"libs": strings.Join(libs, sep)})
+}
+
+// Check that the tools needed to build anything are described
+func (desc *genericToolchainDesc) validate() error {
+	missing := []string{}
+	for name, tool := range map[string]string{
+		"archiver":     desc.Archiver.Tool,
+		"assembler":    desc.Assembler.Tool,
+		"c_compiler":   desc.CCompiler.Tool,
+		"cxx_compiler": desc.CXXCompiler.Tool,
+		"linker":       desc.Linker.Tool,
+	} {
+		if tool == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("no tool set for %s", strings.Join(missing, ", "))
+	}
+	if desc.Linker.WholeArchive != "" && !strings.Contains(desc.Linker.WholeArchive, "${libs}") {
+		return errors.New("linker.whole_archive does not reference ${libs}")
+	}
+	return nil
+}
+
+// Parse a generic toolchain description
+func parseGenericToolchain(data []byte) (desc genericToolchainDesc, err error) {
+	if err = json.Unmarshal(data, &desc); err != nil {
+		return
+	}
+	err = desc.validate()
+	return
+}
+
+// Load the generic toolchain described by the file named in the
+// `<tgt>_generic_toolchain_file` option. Relative paths are relative
+// to the source directory.
+func newToolchainGenericCommon(config *bobConfig, tgt tgtType) (tc toolchainGeneric) {
+	file := config.Properties.GetString(string(tgt) + "_generic_toolchain_file")
+	if file == "" {
+		panic(fmt.Errorf("%s_GENERIC_TOOLCHAIN_FILE is not set", strings.ToUpper(string(tgt))))
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(getSourceDir(), file)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	tc.desc, err = parseGenericToolchain(data)
+	if err != nil {
+		panic(fmt.Errorf("%s: %s", file, err))
+	}
+
+	// Regenerate the build when the description changes
+	pctx.AddNinjaFileDeps(file)
+	tc.flagCache = newFlagCache()
+
+	return
+}
+
+func newToolchainGenericNative(config *bobConfig) (tc toolchainGenericNative) {
+	tc.toolchainGeneric = newToolchainGenericCommon(config, tgtTypeHost)
+	return
+}
+
+func newToolchainGenericCross(config *bobConfig) (tc toolchainGenericCross) {
+	tc.toolchainGeneric = newToolchainGenericCommon(config, tgtTypeTarget)
+	return
+}
+
+var _ toolchain = toolchainGeneric{}
+var _ linker = toolchainGeneric{}
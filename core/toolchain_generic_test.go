@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testGenericToolchain = `{
+    "archiver":     { "tool": "vendor-ar" },
+    "assembler":    { "tool": "vendor-as" },
+    "c_compiler":   { "tool": "vendor-cc", "flags": ["--cpu=a53"] },
+    "cxx_compiler": { "tool": "vendor-c++" },
+    "linker": {
+        "tool": "vendor-ld",
+        "as_needed": "--as-needed",
+        "rpath_link": "-L${path}",
+        "rpath": "-rpath=${path}",
+        "rpath_separator": ",",
+        "whole_archive": "--whole ${libs} --no-whole"
+    },
+    "pch_extension": ".pch",
+    "pch_flags": ["--use-pch=${pch}", "--header=${header}"]
+}`
+
+func Test_genericToolchain(t *testing.T) {
+	desc, err := parseGenericToolchain([]byte(testGenericToolchain))
+	assert.Nil(t, err)
+	tc := toolchainGeneric{desc: desc}
+
+	tool, flags := tc.getArchiver()
+	assert.Equal(t, "vendor-ar", tool)
+	assert.Equal(t, []string{"-rcs"}, flags, "Archiver flags should default to ar's")
+
+	tool, flags = tc.getCCompiler()
+	assert.Equal(t, "vendor-cc", tool)
+	assert.Equal(t, []string{"--cpu=a53"}, flags)
+
+	_, flags = tc.getCXXCompiler()
+	assert.Equal(t, []string{}, flags)
+
+	l := tc.getLinker()
+	assert.Equal(t, "vendor-ld", l.getTool())
+	assert.Equal(t, "--as-needed", l.dropUnusedDependencies())
+	assert.Equal(t, "", l.keepUnusedDependencies(), "Idioms which are not set expand to nothing")
+	assert.Equal(t, "-Llib", l.setRpathLink("lib"))
+	assert.Equal(t, "", l.setVersionScript("exports.map"))
+	assert.Equal(t, "-rpath=a,-rpath=b", l.setRpath([]string{"a", "b"}))
+	assert.Equal(t, "", l.setRpath([]string{}))
+	assert.Equal(t, "--whole a.a b.a --no-whole", l.linkWholeArchives([]string{"a.a", "b.a"}))
+	assert.Equal(t, "", l.linkWholeArchives([]string{}))
+
+	pch, flags := tc.getPchFlags("obj/pch/common.h")
+	assert.Equal(t, "obj/pch/common.h.pch", pch)
+	assert.Equal(t, []string{"--use-pch=obj/pch/common.h.pch", "--header=obj/pch/common.h"}, flags)
+
+	_, _, err = tc.getSanitizerFlags([]string{"address"})
+	assert.NotNil(t, err)
+}
+
+func Test_genericToolchainValidation(t *testing.T) {
+	_, err := parseGenericToolchain([]byte(`{ "c_compiler": { "tool": "cc" } }`))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "no tool set for archiver, assembler, cxx_compiler, linker", err.Error())
+	}
+
+	_, err = parseGenericToolchain([]byte(`{
+	    "archiver": { "tool": "ar" }, "assembler": { "tool": "as" },
+	    "c_compiler": { "tool": "cc" }, "cxx_compiler": { "tool": "c++" },
+	    "linker": { "tool": "c++", "whole_archive": "--whole-archive" }
+	}`))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "linker.whole_archive does not reference ${libs}", err.Error())
+	}
+
+	_, err = parseGenericToolchain([]byte(`{ "archiver": `))
+	assert.NotNil(t, err)
+}
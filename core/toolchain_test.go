@@ -0,0 +1,36 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getPchFlags(t *testing.T) {
+	pch, flags := toolchainGnuCommon{}.getPchFlags("obj/pch/common.h")
+	assert.Equal(t, "obj/pch/common.h.gch", pch)
+	assert.Equal(t, []string{"-Winvalid-pch", "-include", "obj/pch/common.h"}, flags)
+
+	for _, tc := range []toolchain{toolchainClangCommon{}, toolchainXcode{}, toolchainArmClang{}} {
+		pch, flags = tc.getPchFlags("obj/pch/common.h")
+		assert.Equal(t, "obj/pch/common.h.pch", pch)
+		assert.Equal(t, []string{"-include-pch", "obj/pch/common.h.pch"}, flags)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"github.com/ARM-software/bob-build/internal/fileutils"
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// UnityBuildProps defines properties used to compile a module's sources
+// in batches, reducing the time spent parsing the same headers
+type UnityBuildProps struct {
+	// Compile the module's sources in batches, each including several
+	// of them. C and C++ sources are batched separately.
+	Unity_build *bool
+	// The maximum number of sources in each batch
+	Unity_batch_size *int64
+	// Sources which must be compiled on their own, for example because
+	// they define static symbols with the same names as other sources.
+	// Wildcards can be used.
+	Unity_exclude_srcs []string
+}
+
+const defaultUnityBatchSize = 8
+
+func (props *UnityBuildProps) processPaths(ctx blueprint.BaseModuleContext) {
+	props.Unity_exclude_srcs = utils.PrefixDirs(props.Unity_exclude_srcs, projectModuleDir(ctx))
+}
+
+func (props *UnityBuildProps) getBatchSize() int {
+	if props.Unity_batch_size == nil {
+		return defaultUnityBatchSize
+	}
+	return int(*props.Unity_batch_size)
+}
+
+// A language whose sources can be batched, and the extension of its
+// batch files
+type unityLanguage struct {
+	name     string
+	lang     sourceLanguage
+	batchExt string
+}
+
+var unityLanguages = []unityLanguage{
+	{"c", langC, ".c"},
+	{"cxx", langCxx, ".cpp"},
+}
+
+// Returns the directory this module's batch files are written to,
+// relative to the build directory
+func (l *library) unityDir() string {
+	return filepath.Join(string(l.Properties.TargetType), "unity", l.outputName())
+}
+
+// Returns the contents of a batch file, including each of the sources
+func unityBatchContents(srcs []string) *strings.Builder {
+	sb := &strings.Builder{}
+	for _, src := range srcs {
+		fmt.Fprintf(sb, "#include \"%s\"\n", absPath(getPathInSourceDir(src)))
+	}
+	return sb
+}
+
+// Split the sources into batches, no larger than the batch size. The
+// sources are sorted first, so that adding a source only changes the
+// batches following it.
+func splitUnityBatches(srcs []string, size int) (batches [][]string) {
+	srcs = append([]string{}, srcs...)
+	sort.Strings(srcs)
+	for len(srcs) > size {
+		batches = append(batches, srcs[:size])
+		srcs = srcs[size:]
+	}
+	if len(srcs) > 0 {
+		batches = append(batches, srcs)
+	}
+	return
+}
+
+// Remove batch files left in a module's unity directory by an earlier
+// build with more batches
+func removeStaleUnityBatches(dir string, batchFiles []string) error {
+	existing, err := filepath.Glob(filepath.Join(dir, "unity_*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		if !utils.Contains(batchFiles, filepath.Base(file)) {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Replace the C and C++ sources of a module which uses unity_build with
+// batch files including them. Generated sources, sources in
+// unity_exclude_srcs and sources with their own flags in srcs_flags are
+// returned unchanged. The batch files are written
+// while the build is being generated, and are only rewritten when the
+// sources they include change, so that only their objects are rebuilt.
+//
+// Returns the sources to compile, and the sources included by each batch
+// file among them.
+func (l *library) getUnitySources(ctx blueprint.ModuleContext, srcs []string) ([]string, map[string][]string) {
+	props := &l.Properties.UnityBuildProps
+	if !proptools.Bool(props.Unity_build) {
+		return srcs, map[string][]string{}
+	}
+
+	g := getBackend(ctx)
+	excludes := glob(ctx, props.Unity_exclude_srcs, []string{})
+	for src := range l.Properties.getSrcsFlags(ctx) {
+		excludes = append(excludes, src)
+	}
+	toBatch := map[string][]string{}
+	remaining := []string{}
+
+	for _, src := range srcs {
+		batched := false
+		if !strings.HasPrefix(src, g.buildDir()) && !utils.Contains(excludes, src) {
+			for _, lang := range unityLanguages {
+				if getSourceLanguage(src) == lang.lang {
+					toBatch[lang.name] = append(toBatch[lang.name], src)
+					batched = true
+					break
+				}
+			}
+		}
+		if !batched {
+			remaining = append(remaining, src)
+		}
+	}
+
+	dir := getPathInBuildDir(l.unityDir())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		utils.Exit(1, err.Error())
+	}
+
+	names := []string{}
+	batchFiles := []string{}
+	members := map[string][]string{}
+	for _, lang := range unityLanguages {
+		for i, batch := range splitUnityBatches(toBatch[lang.name], props.getBatchSize()) {
+			name := fmt.Sprintf("unity_%s_%d%s", lang.name, i, lang.batchExt)
+			err := fileutils.WriteIfChanged(filepath.Join(dir, name), unityBatchContents(batch))
+			if err != nil {
+				utils.Exit(1, err.Error())
+			}
+			names = append(names, name)
+			batchFile := filepath.Join(g.buildDir(), l.unityDir(), name)
+			batchFiles = append(batchFiles, batchFile)
+			members[batchFile] = batch
+		}
+	}
+
+	if err := removeStaleUnityBatches(dir, names); err != nil {
+		utils.Exit(1, err.Error())
+	}
+
+	return append(remaining, batchFiles...), members
+}
+
+// Check the unity_build properties are valid
+func checkUnityBuildMutator(mctx blueprint.BottomUpMutatorContext) {
+	l, ok := getLibrary(mctx.Module())
+	if !ok || !isEnabled(l) {
+		return
+	}
+
+	if size := l.Properties.Unity_batch_size; size != nil && *size < 1 {
+		propertyErrorf(mctx, "unity_batch_size", "must be at least 1, not %d", *size)
+		l.Properties.Unity_batch_size = nil
+	}
+}
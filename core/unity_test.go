@@ -0,0 +1,62 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitUnityBatches(t *testing.T) {
+	assert.Equal(t, [][]string(nil), splitUnityBatches([]string{}, 2))
+
+	srcs := []string{"e.cpp", "a.cpp", "d.cpp", "b.cpp", "c.cpp"}
+	assert.Equal(t, [][]string{{"a.cpp", "b.cpp"}, {"c.cpp", "d.cpp"}, {"e.cpp"}},
+		splitUnityBatches(srcs, 2))
+	assert.Equal(t, []string{"e.cpp", "a.cpp", "d.cpp", "b.cpp", "c.cpp"}, srcs,
+		"The caller's sources should not be reordered")
+
+	assert.Equal(t, [][]string{{"a.cpp", "b.cpp", "c.cpp", "d.cpp", "e.cpp"}},
+		splitUnityBatches(srcs, 8))
+}
+
+func Test_removeStaleUnityBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bob_unity")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"unity_c_0.c", "unity_cxx_0.cpp", "unity_cxx_1.cpp", "other.txt"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte{}, 0644))
+	}
+
+	assert.NoError(t, removeStaleUnityBatches(dir, []string{"unity_cxx_0.cpp"}))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Equal(t, []string{"other.txt", "unity_cxx_0.cpp"}, names)
+}
@@ -0,0 +1,481 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package condition evaluates boolean expressions over configuration
+// values, such as `debug && !android` or `arch == "arm64" || jobs > 4`.
+//
+// Expressions support the operators `&&`, `||` and `!`, parentheses,
+// and comparisons (`==`, `!=`, `<`, `<=`, `>`, `>=`) of integers or
+// strings. Operands are config option names, integers, strings quoted
+// with either `"` or `'`, and `true` and `false`.
+package condition
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Lookup returns the value of a config option, which may be a bool,
+// string, int, int64 or json.Number. The second return value is false
+// if the option does not exist.
+type Lookup func(name string) (interface{}, bool)
+
+// Expr is a parsed expression
+type Expr interface {
+	eval(lookup Lookup) (interface{}, error)
+	String() string
+}
+
+// Parse parses an expression
+func Parse(s string) (Expr, error) {
+	p := &parser{input: s}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return e, nil
+}
+
+// Eval evaluates a parsed expression, which must have a boolean result
+func Eval(e Expr, lookup Lookup) (bool, error) {
+	v, err := e.eval(lookup)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is not a boolean", e)
+	}
+	return b, nil
+}
+
+// Evaluate parses and evaluates an expression
+func Evaluate(s string, lookup Lookup) (bool, error) {
+	e, err := Parse(s)
+	if err != nil {
+		return false, err
+	}
+	return Eval(e, lookup)
+}
+
+//// Tokenizer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// Value of string literals, with quotes and escapes removed
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return "'" + t.text + "'"
+}
+
+type parser struct {
+	input string
+	pos   int
+	tok   token
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at column %d of \"%s\"", fmt.Sprintf(format, args...), p.tok.pos+1, p.input)
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentChar(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+// Reads the next token into p.tok
+func (p *parser) next() error {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos >= len(p.input) {
+		p.tok.kind = tokEOF
+		return nil
+	}
+
+	c := rune(p.input[p.pos])
+	switch {
+	case isIdentStart(c):
+		for p.pos < len(p.input) && isIdentChar(rune(p.input[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind = tokIdent
+
+	case unicode.IsDigit(c) || (c == '-' && p.pos+1 < len(p.input) && unicode.IsDigit(rune(p.input[p.pos+1]))):
+		p.pos++
+		for p.pos < len(p.input) && unicode.IsDigit(rune(p.input[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind = tokInt
+
+	case c == '"' || c == '\'':
+		var sb strings.Builder
+		p.pos++
+		for {
+			if p.pos >= len(p.input) {
+				return p.errorf("unterminated string")
+			}
+			ch := p.input[p.pos]
+			if ch == byte(c) {
+				p.pos++
+				break
+			}
+			if ch == '\\' && p.pos+1 < len(p.input) {
+				p.pos++
+				ch = p.input[p.pos]
+			}
+			sb.WriteByte(ch)
+			p.pos++
+		}
+		p.tok.kind = tokString
+		p.tok.value = sb.String()
+
+	default:
+		for _, op := range operators {
+			if strings.HasPrefix(p.input[p.pos:], op) {
+				p.pos += len(op)
+				p.tok.kind = tokOp
+				break
+			}
+		}
+		if p.tok.kind != tokOp {
+			p.tok.text = string(c)
+			return p.errorf("unexpected character '%c'", c)
+		}
+	}
+
+	p.tok.text = p.input[start:p.pos]
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+//// Parser
+//
+// or         := and { "||" and }
+// and        := not { "&&" not }
+// not        := "!" not | comparison
+// comparison := primary [ compare-op primary ]
+// primary    := "(" or ")" | ident | int | string
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{"||", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{"&&", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.isOp("!") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{e}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOps = []string{"==", "!=", "<", "<=", ">", ">="}
+
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range comparisonOps {
+		if p.isOp(op) {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return &comparison{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (e Expr, err error) {
+	switch p.tok.kind {
+	case tokOp:
+		if !p.isOp("(") {
+			return nil, p.errorf("unexpected %s", p.tok)
+		}
+		if err = p.next(); err != nil {
+			return
+		}
+		if e, err = p.parseOr(); err != nil {
+			return
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf("expected ')' but found %s", p.tok)
+		}
+		e = &paren{e}
+
+	case tokIdent:
+		switch p.tok.text {
+		case "true":
+			e = &literal{true, p.tok.text}
+		case "false":
+			e = &literal{false, p.tok.text}
+		default:
+			e = &option{p.tok.text}
+		}
+
+	case tokInt:
+		value, err := strconv.ParseInt(p.tok.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %s", p.tok)
+		}
+		e = &literal{value, p.tok.text}
+
+	case tokString:
+		e = &literal{p.tok.value, p.tok.text}
+
+	default:
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+
+	return e, p.next()
+}
+
+//// Expression nodes
+
+type literal struct {
+	value interface{}
+	text  string
+}
+
+func (e *literal) eval(Lookup) (interface{}, error) { return e.value, nil }
+func (e *literal) String() string                   { return e.text }
+
+type option struct {
+	name string
+}
+
+func (e *option) eval(lookup Lookup) (interface{}, error) {
+	value, ok := lookup(e.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown config option %s", e.name)
+	}
+
+	switch v := value.(type) {
+	case bool, string, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("config option %s is not an integer: %s", e.name, v)
+		}
+		return i, nil
+	}
+	return nil, fmt.Errorf("config option %s has unsupported type %T", e.name, value)
+}
+
+func (e *option) String() string { return e.name }
+
+type paren struct {
+	e Expr
+}
+
+func (e *paren) eval(lookup Lookup) (interface{}, error) { return e.e.eval(lookup) }
+func (e *paren) String() string                          { return "(" + e.e.String() + ")" }
+
+type not struct {
+	e Expr
+}
+
+func (e *not) eval(lookup Lookup) (interface{}, error) {
+	b, err := Eval(e.e, lookup)
+	return !b, err
+}
+
+func (e *not) String() string { return "!" + e.e.String() }
+
+type logical struct {
+	op          string
+	left, right Expr
+}
+
+func (e *logical) eval(lookup Lookup) (interface{}, error) {
+	left, err := Eval(e.left, lookup)
+	if err != nil {
+		return nil, err
+	}
+	// Short circuit, so that options which only exist in some
+	// configurations can be guarded
+	if (e.op == "&&" && !left) || (e.op == "||" && left) {
+		return left, nil
+	}
+	return Eval(e.right, lookup)
+}
+
+func (e *logical) String() string {
+	return e.left.String() + " " + e.op + " " + e.right.String()
+}
+
+type comparison struct {
+	op          string
+	left, right Expr
+}
+
+func (e *comparison) eval(lookup Lookup) (interface{}, error) {
+	left, err := e.left.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			return nil, e.mismatch(left, right)
+		}
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, e.mismatch(left, right)
+		}
+		cmp = strings.Compare(l, r)
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, e.mismatch(left, right)
+		}
+		if e.op != "==" && e.op != "!=" {
+			return nil, fmt.Errorf("cannot use %s on booleans in %s", e.op, e)
+		}
+		if l != r {
+			cmp = 1
+		}
+	}
+
+	switch e.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func (e *comparison) mismatch(left, right interface{}) error {
+	return fmt.Errorf("cannot compare %s (%s) with %s (%s)", e.left, typeName(left), e.right, typeName(right))
+}
+
+func (e *comparison) String() string {
+	return e.left.String() + " " + e.op + " " + e.right.String()
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	}
+	return "string"
+}
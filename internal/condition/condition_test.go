@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package condition
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = map[string]interface{}{
+	"debug":   true,
+	"android": false,
+	"arch":    "arm64",
+	"jobs":    json.Number("8"),
+	"level":   3,
+	"list":    []string{"a"},
+}
+
+func lookup(name string) (interface{}, bool) {
+	v, ok := testConfig[name]
+	return v, ok
+}
+
+func check(t *testing.T, expected bool, expr string) {
+	result, err := Evaluate(expr, lookup)
+	if assert.NoError(t, err, expr) {
+		assert.Equal(t, expected, result, expr)
+	}
+}
+
+func Test_Evaluate(t *testing.T) {
+	check(t, true, "debug")
+	check(t, false, "android")
+	check(t, true, "debug && !android")
+	check(t, true, "android || debug")
+	check(t, false, "!(debug || android)")
+	check(t, true, "!!debug")
+
+	// && binds more tightly than ||
+	check(t, true, "debug || android && false")
+	check(t, false, "(debug || android) && false")
+
+	check(t, true, `arch == "arm64"`)
+	check(t, true, "arch != 'x86'")
+	check(t, true, `arch > "arm"`)
+	check(t, true, "jobs > 4 && jobs <= 8")
+	check(t, false, "jobs >= 9")
+	check(t, true, "level < 4 && level == 3")
+	check(t, true, "level > -1")
+	check(t, true, "debug == true && android != true")
+
+	// The right hand side isn't evaluated when the result is known
+	check(t, false, "android && missing")
+	check(t, true, "debug || missing")
+}
+
+func Test_EvaluateErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"debug &&",
+		"debug & android",
+		"(debug",
+		"debug)",
+		"'arm64",
+		"missing",
+		"arch",
+		"jobs == 'arm64'",
+		"debug < android",
+		"list",
+		"debug android",
+		"== 3",
+	} {
+		_, err := Evaluate(expr, lookup)
+		assert.Error(t, err, expr)
+	}
+}
+
+func Test_String(t *testing.T) {
+	e, err := Parse(`debug&&!( arch=="arm64" ||jobs>4)`)
+	assert.NoError(t, err)
+	assert.Equal(t, `debug && !(arch == "arm64" || jobs > 4)`, e.String())
+}
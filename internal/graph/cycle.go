@@ -0,0 +1,163 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"sort"
+)
+
+// Cycle is a closed path through a graph. Edge i goes from Nodes[i] to
+// Nodes[i+1], and the last edge goes from the last node back to
+// Nodes[0]. Colors[i] is the color of edge i, without quotes.
+type Cycle struct {
+	Nodes  []string
+	Colors []string
+}
+
+// FindCycles returns a cycle from every strongly connected component
+// of the graph that has one. Each cycle is the shortest one starting
+// from the lowest named node of its component, and the cycles are
+// ordered by that node, so the result does not depend on map order.
+// An empty result means the graph is a DAG.
+func FindCycles(g Graph) []Cycle {
+	nodes := g.GetNodes()
+	sort.Strings(nodes)
+
+	cycles := []Cycle{}
+	for _, component := range stronglyConnectedComponents(g, nodes) {
+		sort.Strings(component)
+		start := component[0]
+		if len(component) == 1 && !g.HasEdge(start, start) {
+			continue
+		}
+
+		members := map[string]bool{}
+		for _, id := range component {
+			members[id] = true
+		}
+		cycles = append(cycles, shortestCycle(g, start, members))
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].Nodes[0] < cycles[j].Nodes[0]
+	})
+
+	return cycles
+}
+
+// Tarjan's algorithm: https://en.wikipedia.org/wiki/Tarjan%27s_strongly_connected_components_algorithm
+func stronglyConnectedComponents(g Graph, nodes []string) [][]string {
+	index := map[string]int{}
+	lowLink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	components := [][]string{}
+
+	var connect func(id string)
+	connect = func(id string) {
+		index[id] = len(index)
+		lowLink[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		targets, _ := g.GetTargets(id)
+		for _, target := range targets {
+			if _, visited := index[target]; !visited {
+				connect(target)
+				if lowLink[target] < lowLink[id] {
+					lowLink[id] = lowLink[target]
+				}
+			} else if onStack[target] && index[target] < lowLink[id] {
+				lowLink[id] = index[target]
+			}
+		}
+
+		if lowLink[id] == index[id] {
+			component := []string{}
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == id {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+
+	for _, id := range nodes {
+		if _, visited := index[id]; !visited {
+			connect(id)
+		}
+	}
+
+	return components
+}
+
+// Breadth first search from start back to itself, only following
+// edges between members of its strongly connected component.
+func shortestCycle(g Graph, start string, members map[string]bool) Cycle {
+	parent := map[string]string{}
+	queue := []string{start}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		targets, _ := g.GetTargets(id)
+		for _, target := range targets {
+			if !members[target] {
+				continue
+			}
+			if target == start {
+				return newCycle(g, start, id, parent)
+			}
+			if _, seen := parent[target]; !seen {
+				parent[target] = id
+				queue = append(queue, target)
+			}
+		}
+	}
+
+	// Unreachable for a component containing a cycle
+	return Cycle{}
+}
+
+// Builds the cycle ending with the edge last -> start by following
+// the parents recorded during the search.
+func newCycle(g Graph, start, last string, parent map[string]string) Cycle {
+	path := []string{last}
+	for id := last; id != start; {
+		id = parent[id]
+		path = append(path, id)
+	}
+
+	cycle := Cycle{}
+	for i := len(path) - 1; i >= 0; i-- {
+		cycle.Nodes = append(cycle.Nodes, path[i])
+	}
+	for i, id := range cycle.Nodes {
+		next := cycle.Nodes[(i+1)%len(cycle.Nodes)]
+		attributes, _ := g.GetEdgeAttributes(id, next)
+		cycle.Colors = append(cycle.Colors, attributeValue(attributes["color"]))
+	}
+
+	return cycle
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func addColoredEdge(g Graph, source, target, color string) {
+	g.AddEdge(source, target)
+	g.SetEdgeColor(source, target, color)
+}
+
+func TestShould_find_no_cycles_When_graph_is_DAG(t *testing.T) {
+	testGraph := NewGraph("Test")
+	addColoredEdge(testGraph, "A", "B", "blue")
+	addColoredEdge(testGraph, "A", "C", "red")
+	addColoredEdge(testGraph, "B", "C", "blue")
+
+	assert.Equal(t, []Cycle{}, FindCycles(testGraph))
+}
+
+func TestShould_return_ordered_cycle_When_graph_has_cycle(t *testing.T) {
+	testGraph := NewGraph("Test")
+	addColoredEdge(testGraph, "main", "B", "blue")
+	addColoredEdge(testGraph, "B", "C", "red")
+	addColoredEdge(testGraph, "C", "D", "blue")
+	addColoredEdge(testGraph, "D", "B", "pink")
+	addColoredEdge(testGraph, "C", "B", "blue")
+
+	// The shortest cycle through the lowest named node is reported
+	assert.Equal(t, []Cycle{
+		{Nodes: []string{"B", "C"}, Colors: []string{"red", "blue"}},
+	}, FindCycles(testGraph))
+}
+
+func TestShould_return_each_cycle_When_graph_has_several(t *testing.T) {
+	testGraph := NewGraph("Test")
+	addColoredEdge(testGraph, "X", "Y", "blue")
+	addColoredEdge(testGraph, "Y", "Z", "blue")
+	addColoredEdge(testGraph, "Z", "X", "red")
+	addColoredEdge(testGraph, "Z", "A", "blue")
+	addColoredEdge(testGraph, "A", "A", "pink")
+
+	assert.Equal(t, []Cycle{
+		{Nodes: []string{"A"}, Colors: []string{"pink"}},
+		{Nodes: []string{"X", "Y", "Z"}, Colors: []string{"blue", "blue", "red"}},
+	}, FindCycles(testGraph))
+}
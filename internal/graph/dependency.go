@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+// DependencyEdge is an outgoing edge of a DependencyNode.
+type DependencyEdge struct {
+	Target *DependencyNode
+	Color  string
+}
+
+// DependencyNode records a node together with its outgoing edges. It
+// is never modified once created, so nodes can be shared between
+// goroutines, and a node can be built from the nodes of its
+// dependencies without any global state.
+type DependencyNode struct {
+	id         string
+	attributes Attributes
+	edges      []DependencyEdge
+}
+
+// NewDependencyNode creates a node with the given attributes and
+// outgoing edges. As with AddEdge followed by SetEdgeColor, a repeated
+// target keeps the position of its first edge and takes the color of
+// its last one.
+func NewDependencyNode(id string, attributes Attributes, edges []DependencyEdge) *DependencyNode {
+	n := &DependencyNode{id: id, attributes: Attributes{}}
+	for key, value := range attributes {
+		n.attributes[key] = value
+	}
+	position := map[string]int{}
+
+	for _, edge := range edges {
+		if i, ok := position[edge.Target.id]; ok {
+			n.edges[i].Color = edge.Color
+			continue
+		}
+		position[edge.Target.id] = len(n.edges)
+		n.edges = append(n.edges, edge)
+	}
+
+	return n
+}
+
+func (n *DependencyNode) ID() string {
+	return n.id
+}
+
+// Subgraph returns a new graph holding n and every node reachable from
+// it. Nodes and edges are added in the same order as GetSubgraph would
+// add them, so the result can be sorted in exactly the same way.
+func (n *DependencyNode) Subgraph() Graph {
+	sub := NewGraph(n.id)
+	visited := map[*DependencyNode]bool{}
+
+	var walk func(node *DependencyNode)
+	walk = func(node *DependencyNode) {
+		visited[node] = true
+		for key, value := range node.attributes {
+			sub.SetNodeProperty(node.id, key, value)
+		}
+		sub.AddNode(node.id)
+
+		for _, edge := range node.edges {
+			if sub.AddEdge(node.id, edge.Target.id) {
+				if edge.Color != "" {
+					sub.SetEdgeColor(node.id, edge.Target.id, edge.Color)
+				}
+			}
+			if !visited[edge.Target] {
+				walk(edge.Target)
+			}
+		}
+	}
+	walk(n)
+
+	return sub
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testModule describes a module in a generated dependency tree. Deps
+// always refer to modules earlier in the list, so the list is in
+// bottom up order.
+type testModule struct {
+	name  string
+	deps  []int
+	whole []bool
+}
+
+func generateModules(count, maxDeps, window int) []testModule {
+	r := rand.New(rand.NewSource(1))
+	modules := make([]testModule, count)
+
+	for i := range modules {
+		modules[i].name = "lib" + strconv.Itoa(i)
+		if i == 0 {
+			continue
+		}
+		lowest := i - window
+		if lowest < 0 {
+			lowest = 0
+		}
+		for d := r.Intn(maxDeps + 1); d > 0; d-- {
+			modules[i].deps = append(modules[i].deps, lowest+r.Intn(i-lowest))
+			modules[i].whole = append(modules[i].whole, r.Intn(8) == 0)
+		}
+	}
+
+	return modules
+}
+
+func edgeColor(whole bool) string {
+	if whole {
+		return "red"
+	}
+	return "blue"
+}
+
+// Records every module in one shared graph, as done before modules
+// were given their own DependencyNode.
+func addToSharedGraph(g Graph, m testModule, modules []testModule) {
+	g.AddNode(m.name)
+	for i, dep := range m.deps {
+		g.AddEdge(m.name, modules[dep].name)
+		g.SetEdgeColor(m.name, modules[dep].name, edgeColor(m.whole[i]))
+	}
+}
+
+func newDependencyNodes(modules []testModule) []*DependencyNode {
+	nodes := make([]*DependencyNode, len(modules))
+	for i, m := range modules {
+		edges := []DependencyEdge{}
+		for j, dep := range m.deps {
+			edges = append(edges, DependencyEdge{Target: nodes[dep], Color: edgeColor(m.whole[j])})
+		}
+		nodes[i] = NewDependencyNode(m.name, nil, edges)
+	}
+	return nodes
+}
+
+func assertSameGraph(t *testing.T, expected, actual Graph) {
+	assert.ElementsMatch(t, expected.GetNodes(), actual.GetNodes())
+	for _, id := range expected.GetNodes() {
+		expectedTargets, _ := expected.GetTargets(id)
+		actualTargets, _ := actual.GetTargets(id)
+		assert.Equal(t, expectedTargets, actualTargets, id)
+
+		for _, target := range expectedTargets {
+			expectedAttributes, _ := expected.GetEdgeAttributes(id, target)
+			actualAttributes, _ := actual.GetEdgeAttributes(id, target)
+			assert.Equal(t, expectedAttributes, actualAttributes, id+" -> "+target)
+		}
+	}
+}
+
+func TestShould_keep_first_position_and_last_color_When_dependency_repeated(t *testing.T) {
+	a := NewDependencyNode("A", nil, nil)
+	b := NewDependencyNode("B", nil, nil)
+	c := NewDependencyNode("C", nil, []DependencyEdge{
+		{Target: a, Color: "blue"},
+		{Target: b, Color: "blue"},
+		{Target: a, Color: "red"},
+	})
+
+	sub := c.Subgraph()
+	targets, _ := sub.GetTargets("C")
+	assert.Equal(t, []string{"A", "B"}, targets)
+
+	attributes, _ := sub.GetEdgeAttributes("C", "A")
+	assert.Equal(t, "\"red\"", attributes["color"])
+}
+
+func TestShould_match_shared_subgraph_When_built_from_dependency_nodes(t *testing.T) {
+	modules := generateModules(200, 4, 30)
+	shared := NewGraph("All")
+	nodes := newDependencyNodes(modules)
+
+	for i, m := range modules {
+		addToSharedGraph(shared, m, modules)
+		assertSameGraph(t, GetSubgraph(shared, m.name), nodes[i].Subgraph())
+	}
+}
+
+func TestShould_count_subgraph_nodes_When_memoised(t *testing.T) {
+	modules := generateModules(200, 4, 30)
+	nodes := newDependencyNodes(modules)
+	sub := nodes[len(nodes)-1].Subgraph()
+
+	counts := GetSubgraphNodeCounts(sub)
+	assert.Equal(t, sub.GetNodeCount(), len(counts))
+	for _, id := range sub.GetNodes() {
+		assert.Equal(t, GetSubgraphNodeCount(sub, id), counts[id], id)
+	}
+}
+
+func TestShould_count_subgraph_nodes_When_graph_has_cycle(t *testing.T) {
+	testGraph := NewGraph("Test")
+	testGraph.AddEdge("A", "B")
+	testGraph.AddEdge("B", "C")
+	testGraph.AddEdge("C", "A")
+	testGraph.AddEdge("C", "D")
+
+	counts := GetSubgraphNodeCounts(testGraph)
+	assert.Equal(t, map[string]int{"A": 4, "B": 4, "C": 4, "D": 1}, counts)
+}
+
+// The benchmarks below compare the two ways of finding the subgraph
+// of every module and the cost of each node within it. The shared
+// graph has to be updated one module at a time, while dependency
+// nodes let every module be handled independently.
+const (
+	benchmarkModules = 500
+	benchmarkMaxDeps = 4
+	benchmarkWindow  = 100
+)
+
+func BenchmarkSharedGraphSubgraphCosts(b *testing.B) {
+	modules := generateModules(benchmarkModules, benchmarkMaxDeps, benchmarkWindow)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		shared := NewGraph("All")
+		for _, m := range modules {
+			addToSharedGraph(shared, m, modules)
+			sub := GetSubgraph(shared, m.name)
+			for _, id := range sub.GetNodes() {
+				GetSubgraphNodeCount(sub, id)
+			}
+		}
+	}
+}
+
+func BenchmarkDependencyNodeSubgraphCosts(b *testing.B) {
+	modules := generateModules(benchmarkModules, benchmarkMaxDeps, benchmarkWindow)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		for _, node := range newDependencyNodes(modules) {
+			GetSubgraphNodeCounts(node.Subgraph())
+		}
+	}
+}
+
+func BenchmarkDependencyNodeSubgraphCostsParallel(b *testing.B) {
+	modules := generateModules(benchmarkModules, benchmarkMaxDeps, benchmarkWindow)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		nodes := newDependencyNodes(modules)
+		work := make(chan *DependencyNode)
+		wg := sync.WaitGroup{}
+
+		for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for node := range work {
+					GetSubgraphNodeCounts(node.Subgraph())
+				}
+			}()
+		}
+		for _, node := range nodes {
+			work <- node
+		}
+		close(work)
+		wg.Wait()
+	}
+}
+
+func BenchmarkGetSubgraphNodeCount(b *testing.B) {
+	nodes := newDependencyNodes(generateModules(benchmarkModules, benchmarkMaxDeps, benchmarkWindow))
+	sub := nodes[len(nodes)-1].Subgraph()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		for _, id := range sub.GetNodes() {
+			GetSubgraphNodeCount(sub, id)
+		}
+	}
+}
+
+func BenchmarkGetSubgraphNodeCounts(b *testing.B) {
+	nodes := newDependencyNodes(generateModules(benchmarkModules, benchmarkMaxDeps, benchmarkWindow))
+	sub := nodes[len(nodes)-1].Subgraph()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		GetSubgraphNodeCounts(sub)
+	}
+}
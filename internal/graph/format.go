@@ -0,0 +1,280 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ARM-software/bob-build/internal/utils"
+)
+
+// An output format, and the extension of files written in it
+type format struct {
+	serialize func(Graph) string
+	extension string
+}
+
+// Supported output formats, by name
+var formats = map[string]format{
+	"dot":     {ToString, ".graph"},
+	"json":    {ToJSON, ".json"},
+	"graphml": {ToGraphML, ".graphml"},
+	"mermaid": {ToMermaid, ".mmd"},
+}
+
+// FormatNames returns the names of the formats accepted by Format
+func FormatNames() []string {
+	names := []string{}
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unknownFormatError(name string) error {
+	return fmt.Errorf("unknown graph format '%s', expected one of %s",
+		name, strings.Join(FormatNames(), ", "))
+}
+
+// Format returns the representation of the graph in the named format
+func Format(graph Graph, name string) (string, error) {
+	f, ok := formats[name]
+	if !ok {
+		return "", unknownFormatError(name)
+	}
+	return f.serialize(graph), nil
+}
+
+// FormatExtension returns the default file extension of the named format
+func FormatExtension(name string) (string, error) {
+	f, ok := formats[name]
+	if !ok {
+		return "", unknownFormatError(name)
+	}
+	return f.extension, nil
+}
+
+// Attribute values are stored ready for Graphviz, so some are quoted.
+// Other formats want the plain value.
+func attributeValue(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func plainAttributes(attributes Attributes) map[string]string {
+	plain := map[string]string{}
+	for key, value := range attributes {
+		plain[key] = attributeValue(value)
+	}
+	return plain
+}
+
+// The formats below list nodes in name order, and the edges of each
+// node in the order they were added, so the output is stable.
+func sortedNodes(graph Graph) []string {
+	nodes := graph.GetNodes()
+	sort.Strings(nodes)
+	return nodes
+}
+
+type jsonNode struct {
+	ID         string            `json:"id"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type jsonEdge struct {
+	Source     string            `json:"source"`
+	Target     string            `json:"target"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type jsonGraph struct {
+	Name  string     `json:"name"`
+	Nodes []jsonNode `json:"nodes"`
+	Edges []jsonEdge `json:"edges"`
+}
+
+// Return JSON representation, listing the nodes and edges with their
+// attributes
+func ToJSON(graph Graph) string {
+	out := jsonGraph{
+		Name:  graph.GetName(),
+		Nodes: []jsonNode{},
+		Edges: []jsonEdge{},
+	}
+
+	for _, id := range sortedNodes(graph) {
+		attributes, _ := graph.GetNodeAttributes(id)
+		out.Nodes = append(out.Nodes, jsonNode{id, plainAttributes(attributes)})
+
+		targets, _ := graph.GetTargets(id)
+		for _, target := range targets {
+			attributes, _ := graph.GetEdgeAttributes(id, target)
+			out.Edges = append(out.Edges, jsonEdge{id, target, plainAttributes(attributes)})
+		}
+	}
+
+	text, err := json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		panic(err)
+	}
+	return string(text) + "\n"
+}
+
+func xmlEscape(s string) string {
+	buf := new(bytes.Buffer)
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+// Return GraphML representation: http://graphml.graphdrawing.org/
+// Every attribute used in the graph is declared as a string key.
+func ToGraphML(graph Graph) string {
+	nodes := sortedNodes(graph)
+	nodeKeys := map[string]bool{}
+	edgeKeys := map[string]bool{}
+
+	for _, id := range nodes {
+		attributes, _ := graph.GetNodeAttributes(id)
+		for key := range attributes {
+			nodeKeys[key] = true
+		}
+		targets, _ := graph.GetTargets(id)
+		for _, target := range targets {
+			attributes, _ := graph.GetEdgeAttributes(id, target)
+			for key := range attributes {
+				edgeKeys[key] = true
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(buf, "<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	for _, key := range utils.SortedKeysBoolMap(nodeKeys) {
+		fmt.Fprintf(buf, "\t<key id=\"node_%s\" for=\"node\" attr.name=\"%s\" attr.type=\"string\"/>\n",
+			xmlEscape(key), xmlEscape(key))
+	}
+	for _, key := range utils.SortedKeysBoolMap(edgeKeys) {
+		fmt.Fprintf(buf, "\t<key id=\"edge_%s\" for=\"edge\" attr.name=\"%s\" attr.type=\"string\"/>\n",
+			xmlEscape(key), xmlEscape(key))
+	}
+	fmt.Fprintf(buf, "\t<graph id=\"%s\" edgedefault=\"directed\">\n", xmlEscape(graph.GetName()))
+
+	writeData := func(indent, prefix string, attributes Attributes) {
+		plain := plainAttributes(attributes)
+		keys := []string{}
+		for key := range plain {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(buf, "%s<data key=\"%s_%s\">%s</data>\n",
+				indent, prefix, xmlEscape(key), xmlEscape(plain[key]))
+		}
+	}
+
+	for _, id := range nodes {
+		fmt.Fprintf(buf, "\t\t<node id=\"%s\">\n", xmlEscape(id))
+		attributes, _ := graph.GetNodeAttributes(id)
+		writeData("\t\t\t", "node", attributes)
+		fmt.Fprintf(buf, "\t\t</node>\n")
+	}
+	for _, id := range nodes {
+		targets, _ := graph.GetTargets(id)
+		for _, target := range targets {
+			fmt.Fprintf(buf, "\t\t<edge source=\"%s\" target=\"%s\">\n", xmlEscape(id), xmlEscape(target))
+			attributes, _ := graph.GetEdgeAttributes(id, target)
+			writeData("\t\t\t", "edge", attributes)
+			fmt.Fprintf(buf, "\t\t</edge>\n")
+		}
+	}
+
+	fmt.Fprintf(buf, "\t</graph>\n")
+	fmt.Fprintf(buf, "</graphml>\n")
+	return buf.String()
+}
+
+// Mermaid labels are quoted, and can't contain a plain quote
+func mermaidLabel(id string) string {
+	return "\"" + strings.Replace(id, "\"", "#quot;", -1) + "\""
+}
+
+// Return Mermaid flowchart representation: https://mermaid-js.github.io/
+// Module names aren't valid Mermaid identifiers, so nodes are numbered
+// and labelled with their name. The Graphviz fill color, edge color,
+// dashed edges and double circle shape are carried over.
+func ToMermaid(graph Graph) string {
+	nodes := sortedNodes(graph)
+	index := map[string]int{}
+	for i, id := range nodes {
+		index[id] = i
+	}
+
+	buf := new(bytes.Buffer)
+	styles := new(bytes.Buffer)
+	fmt.Fprintf(buf, "graph TD\n")
+
+	for i, id := range nodes {
+		attributes, _ := graph.GetNodeAttributes(id)
+		plain := plainAttributes(attributes)
+
+		if plain["shape"] == "doublecircle" {
+			fmt.Fprintf(buf, "\tn%d((%s))\n", i, mermaidLabel(id))
+		} else {
+			fmt.Fprintf(buf, "\tn%d[%s]\n", i, mermaidLabel(id))
+		}
+		if fill, ok := plain["fillcolor"]; ok {
+			fmt.Fprintf(styles, "\tstyle n%d fill:%s\n", i, fill)
+		}
+	}
+
+	link := 0
+	for _, id := range nodes {
+		targets, _ := graph.GetTargets(id)
+		for _, target := range targets {
+			attributes, _ := graph.GetEdgeAttributes(id, target)
+			plain := plainAttributes(attributes)
+
+			arrow := "-->"
+			if plain["style"] == "dashed" {
+				arrow = "-.->"
+			}
+			fmt.Fprintf(buf, "\tn%d %s n%d\n", index[id], arrow, index[target])
+			if color, ok := plain["color"]; ok {
+				fmt.Fprintf(styles, "\tlinkStyle %d stroke:%s\n", link, color)
+			}
+			link++
+		}
+	}
+
+	buf.Write(styles.Bytes())
+	return buf.String()
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFormatGraph() Graph {
+	testGraph := NewGraph("deps")
+	testGraph.SetNodeBackgroundColor("bin", "gray")
+	testGraph.SetNodeProperty("bin", "shape", "doublecircle")
+	testGraph.AddEdge("bin", "libstatic")
+	testGraph.SetEdgeColor("bin", "libstatic", "green")
+	testGraph.SetEdgeProperty("bin", "libstatic", "style", "dashed")
+	testGraph.AddEdge("bin", "lib<whole>")
+	testGraph.SetEdgeColor("bin", "lib<whole>", "red")
+	return testGraph
+}
+
+func TestShould_list_nodes_and_edges_When_formatted_as_JSON(t *testing.T) {
+	var out jsonGraph
+	assert.Nil(t, json.Unmarshal([]byte(ToJSON(testFormatGraph())), &out))
+
+	assert.Equal(t, jsonGraph{
+		Name: "deps",
+		Nodes: []jsonNode{
+			{"bin", map[string]string{"fillcolor": "gray", "style": "filled", "shape": "doublecircle"}},
+			{"lib<whole>", map[string]string{}},
+			{"libstatic", map[string]string{}},
+		},
+		Edges: []jsonEdge{
+			{"bin", "libstatic", map[string]string{"color": "green", "style": "dashed"}},
+			{"bin", "lib<whole>", map[string]string{"color": "red"}},
+		},
+	}, out)
+}
+
+func TestShould_declare_keys_and_escape_names_When_formatted_as_GraphML(t *testing.T) {
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+	<key id="node_fillcolor" for="node" attr.name="fillcolor" attr.type="string"/>
+	<key id="node_shape" for="node" attr.name="shape" attr.type="string"/>
+	<key id="node_style" for="node" attr.name="style" attr.type="string"/>
+	<key id="edge_color" for="edge" attr.name="color" attr.type="string"/>
+	<key id="edge_style" for="edge" attr.name="style" attr.type="string"/>
+	<graph id="deps" edgedefault="directed">
+		<node id="bin">
+			<data key="node_fillcolor">gray</data>
+			<data key="node_shape">doublecircle</data>
+			<data key="node_style">filled</data>
+		</node>
+		<node id="lib&lt;whole&gt;">
+		</node>
+		<node id="libstatic">
+		</node>
+		<edge source="bin" target="libstatic">
+			<data key="edge_color">green</data>
+			<data key="edge_style">dashed</data>
+		</edge>
+		<edge source="bin" target="lib&lt;whole&gt;">
+			<data key="edge_color">red</data>
+		</edge>
+	</graph>
+</graphml>
+`
+	assert.Equal(t, expected, ToGraphML(testFormatGraph()))
+}
+
+func TestShould_number_nodes_and_keep_styles_When_formatted_as_Mermaid(t *testing.T) {
+	expected := `graph TD
+	n0(("bin"))
+	n1["lib<whole>"]
+	n2["libstatic"]
+	n0 -.-> n2
+	n0 --> n1
+	style n0 fill:gray
+	linkStyle 0 stroke:green
+	linkStyle 1 stroke:red
+`
+	assert.Equal(t, expected, ToMermaid(testFormatGraph()))
+}
+
+func TestShould_report_error_When_format_unknown(t *testing.T) {
+	_, err := Format(testFormatGraph(), "svg")
+	assert.NotNil(t, err)
+
+	text, err := Format(testFormatGraph(), "dot")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(text, "digraph {\n"))
+}
+
+func TestShould_return_extension_When_format_known(t *testing.T) {
+	extension, err := FormatExtension("mermaid")
+	assert.Nil(t, err)
+	assert.Equal(t, ".mmd", extension)
+
+	for _, name := range FormatNames() {
+		extension, err = FormatExtension(name)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(extension, "."))
+	}
+
+	_, err = FormatExtension("svg")
+	assert.NotNil(t, err)
+}
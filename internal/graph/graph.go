@@ -20,6 +20,7 @@ package graph
 import (
 	"bytes"
 	"fmt"
+	"math/bits"
 	"sort"
 	"strconv"
 	"sync"
@@ -682,6 +683,74 @@ func GetSubgraphNodeCount(graph Graph, start string) int {
 	return len(visited)
 }
 
+// A faster alternative to calling GetSubgraphNodeCount for every node
+// in the graph. The set of reachable nodes is memoised per node, so
+// shared dependencies are only walked once. Graphs containing cycles
+// fall back to walking from each node.
+func GetSubgraphNodeCounts(graph Graph) map[string]int {
+	nodes := graph.GetNodes()
+	index := make(map[string]int, len(nodes))
+	for i, id := range nodes {
+		index[id] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	words := (len(nodes) + 63) / 64
+	reachable := make([][]uint64, len(nodes))
+	state := make([]int, len(nodes))
+	isDAG := true
+
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		set := make([]uint64, words)
+		set[i/64] |= 1 << uint(i%64)
+
+		targets, _ := graph.GetTargets(nodes[i])
+		for _, target := range targets {
+			j := index[target]
+			if state[j] == unvisited {
+				visit(j)
+			}
+			if state[j] == visiting {
+				isDAG = false
+				continue
+			}
+			for w := range set {
+				set[w] |= reachable[j][w]
+			}
+		}
+
+		reachable[i] = set
+		state[i] = done
+	}
+
+	counts := make(map[string]int, len(nodes))
+	for i := range nodes {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	for i, id := range nodes {
+		if isDAG {
+			count := 0
+			for _, w := range reachable[i] {
+				count += bits.OnesCount64(w)
+			}
+			counts[id] = count
+		} else {
+			counts[id] = GetSubgraphNodeCount(graph, id)
+		}
+	}
+
+	return counts
+}
+
 // A faster alternative to GetSubgraph(graph, start).HasNode(target)
 func GetSubgraphHasNode(graph Graph, start string, target string) bool {
 	visited := make(map[string]bool)
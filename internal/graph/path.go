@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+// GetPaths returns the paths from source to target which don't visit
+// a node twice. Paths are found depth first, following the edges of
+// each node in the order they were added. When limit is positive, at
+// most limit paths are returned, and complete reports whether they are
+// all the paths there are.
+//
+// Only nodes which can reach target are followed, so the search never
+// explores parts of the graph which don't lead to it.
+func GetPaths(g Graph, source, target string, limit int) (paths [][]string, complete bool) {
+	paths = [][]string{}
+	if !g.HasNode(source) || !g.HasNode(target) {
+		return paths, true
+	}
+
+	reaches := getNodesReaching(g, target)
+	onPath := map[string]bool{}
+	path := []string{}
+	complete = true
+
+	var walk func(id string)
+	walk = func(id string) {
+		path = append(path, id)
+		onPath[id] = true
+
+		if id == target {
+			if limit > 0 && len(paths) == limit {
+				complete = false
+			} else {
+				paths = append(paths, append([]string{}, path...))
+			}
+		} else {
+			targets, _ := g.GetTargets(id)
+			for _, next := range targets {
+				if complete && reaches[next] && !onPath[next] {
+					walk(next)
+				}
+			}
+		}
+
+		onPath[id] = false
+		path = path[:len(path)-1]
+	}
+	if reaches[source] {
+		walk(source)
+	}
+
+	return paths, complete
+}
+
+// Breadth first search following edges backwards from target, to find
+// every node with a path to it, including target itself
+func getNodesReaching(g Graph, target string) map[string]bool {
+	reaches := map[string]bool{target: true}
+	queue := []string{target}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		sources, _ := g.GetSources(id)
+		for _, prev := range sources {
+			if !reaches[prev] {
+				reaches[prev] = true
+				queue = append(queue, prev)
+			}
+		}
+	}
+
+	return reaches
+}
+
+// GetShortestPath returns a path from source to target with the
+// fewest edges, or nil if target can't be reached. When there are
+// several, the one found first by following edges in the order they
+// were added is returned.
+func GetShortestPath(g Graph, source, target string) []string {
+	if !g.HasNode(source) || !g.HasNode(target) {
+		return nil
+	}
+
+	parent := map[string]string{source: ""}
+	queue := []string{source}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if id == target {
+			path := []string{}
+			for ; id != source; id = parent[id] {
+				path = append([]string{id}, path...)
+			}
+			return append([]string{source}, path...)
+		}
+
+		targets, _ := g.GetTargets(id)
+		for _, next := range targets {
+			if _, seen := parent[next]; !seen {
+				parent[next] = id
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return nil
+}
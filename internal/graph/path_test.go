@@ -0,0 +1,110 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graph
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPathGraph() Graph {
+	// bin -> liba -> libb -> libssl
+	//    \-> libc --------/
+	// libb -> liba closes a loop, which paths must not follow
+	testGraph := NewGraph("Test")
+	testGraph.AddEdge("bin", "liba")
+	testGraph.AddEdge("bin", "libc")
+	testGraph.AddEdge("liba", "libb")
+	testGraph.AddEdge("libb", "liba")
+	testGraph.AddEdge("libb", "libssl")
+	testGraph.AddEdge("libc", "libssl")
+	testGraph.AddNode("unrelated")
+	return testGraph
+}
+
+// Adds layers of nodes between source and target, with an edge from
+// every node in each layer to every node in the next, so there are
+// width^depth paths from source to target.
+func addDiamond(g Graph, prefix, source, target string, width, depth int) {
+	previous := []string{source}
+	for layer := 0; layer < depth; layer++ {
+		current := []string{}
+		for i := 0; i < width; i++ {
+			id := prefix + strconv.Itoa(layer) + "_" + strconv.Itoa(i)
+			for _, p := range previous {
+				g.AddEdge(p, id)
+			}
+			current = append(current, id)
+		}
+		previous = current
+	}
+	for _, p := range previous {
+		g.AddEdge(p, target)
+	}
+}
+
+func TestShould_return_every_path_When_several_reach_target(t *testing.T) {
+	paths, complete := GetPaths(testPathGraph(), "bin", "libssl", 0)
+	assert.Equal(t, [][]string{
+		{"bin", "liba", "libb", "libssl"},
+		{"bin", "libc", "libssl"},
+	}, paths)
+	assert.True(t, complete)
+}
+
+func TestShould_return_no_paths_When_target_unreachable(t *testing.T) {
+	for _, pair := range [][]string{{"libssl", "bin"}, {"bin", "unrelated"}, {"bin", "missing"}} {
+		paths, complete := GetPaths(testPathGraph(), pair[0], pair[1], 0)
+		assert.Equal(t, [][]string{}, paths)
+		assert.True(t, complete)
+	}
+}
+
+func TestShould_stop_at_limit_When_graph_is_wide_diamond(t *testing.T) {
+	// 10^10 paths, which could never all be listed
+	testGraph := NewGraph("Test")
+	addDiamond(testGraph, "lib", "bin", "libssl", 10, 10)
+
+	paths, complete := GetPaths(testGraph, "bin", "libssl", 100)
+	assert.Equal(t, 100, len(paths))
+	assert.False(t, complete)
+
+	paths, complete = GetPaths(testGraph, "lib8_0", "libssl", 100)
+	assert.Equal(t, 10, len(paths))
+	assert.True(t, complete)
+}
+
+func TestShould_skip_nodes_not_reaching_target_When_graph_is_wide_diamond(t *testing.T) {
+	// None of the 10^10 paths through the diamond lead to libssl, so
+	// they must not be explored
+	testGraph := NewGraph("Test")
+	addDiamond(testGraph, "lib", "bin", "libc", 10, 10)
+	testGraph.AddEdge("bin", "libssl")
+
+	paths, complete := GetPaths(testGraph, "bin", "libssl", 0)
+	assert.Equal(t, [][]string{{"bin", "libssl"}}, paths)
+	assert.True(t, complete)
+}
+
+func TestShould_return_fewest_edges_When_shortest_path_requested(t *testing.T) {
+	assert.Equal(t, []string{"bin", "libc", "libssl"}, GetShortestPath(testPathGraph(), "bin", "libssl"))
+	assert.Equal(t, []string{"bin"}, GetShortestPath(testPathGraph(), "bin", "bin"))
+	assert.Equal(t, []string(nil), GetShortestPath(testPathGraph(), "bin", "unrelated"))
+}
@@ -0,0 +1,554 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pkgconfig reads pkg-config `.pc` files, so that the flags
+// needed to use a package can be found without running pkg-config.
+package pkgconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Default directories searched when PKG_CONFIG_LIBDIR is not set
+var defaultLibdirs = []string{
+	"/usr/local/lib/pkgconfig",
+	"/usr/local/share/pkgconfig",
+	"/usr/lib/pkgconfig",
+	"/usr/share/pkgconfig",
+}
+
+// Resolver finds packages in a list of directories
+type Resolver struct {
+	// Directories searched for `.pc` files, in order
+	Path []string
+	// Prefix added to absolute -I and -L paths
+	Sysroot string
+}
+
+// Result holds the flags needed to use a package and its dependencies
+type Result struct {
+	Name    string
+	Version string
+	// Compiler flags
+	Cflags []string
+	// Linker flags other than libraries, e.g. -L
+	Ldflags []string
+	// Libraries, i.e. -l flags
+	Ldlibs []string
+	// All the `.pc` files read
+	Files []string
+	// Existing directories searched before each `.pc` file was found.
+	// A file added to one of these could change the result.
+	Dirs []string
+}
+
+// NewResolver creates a Resolver following pkg-config's rules:
+// PKG_CONFIG_PATH is searched first, followed by PKG_CONFIG_LIBDIR, or
+// the default directories if PKG_CONFIG_LIBDIR is not set.
+func NewResolver(path, libdir, sysroot string) *Resolver {
+	r := &Resolver{Sysroot: sysroot}
+	r.Path = append(r.Path, filepath.SplitList(path)...)
+	if libdir != "" {
+		r.Path = append(r.Path, filepath.SplitList(libdir)...)
+	} else {
+		r.Path = append(r.Path, defaultLibdirs...)
+	}
+	return r
+}
+
+// A package name with an optional version constraint
+type requirement struct {
+	name    string
+	op      string
+	version string
+}
+
+func (req requirement) String() string {
+	if req.op == "" {
+		return req.name
+	}
+	return req.name + " " + req.op + " " + req.version
+}
+
+func (req requirement) satisfiedBy(version string) bool {
+	cmp := CompareVersions(version, req.version)
+	switch req.op {
+	case "":
+		return true
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func isOperator(s string) bool {
+	switch s {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// parseRequirements parses a list of packages, as used in Requires
+// fields, e.g. "foo >= 1.2, bar".
+func parseRequirements(s string) ([]requirement, error) {
+	// Make sure operators are separate words, even when written
+	// without spaces, e.g. "foo>=1.2".
+	var sb strings.Builder
+	for i, c := range s {
+		if strings.ContainsRune("=!<>", c) {
+			if i == 0 || !strings.ContainsRune("=!<>", rune(s[i-1])) {
+				sb.WriteRune(' ')
+			}
+			sb.WriteRune(c)
+			if i+1 == len(s) || !strings.ContainsRune("=!<>", rune(s[i+1])) {
+				sb.WriteRune(' ')
+			}
+		} else if c == ',' {
+			sb.WriteRune(' ')
+		} else {
+			sb.WriteRune(c)
+		}
+	}
+
+	reqs := []requirement{}
+	words := strings.Fields(sb.String())
+	for i := 0; i < len(words); i++ {
+		if isOperator(words[i]) {
+			if len(reqs) == 0 || reqs[len(reqs)-1].op != "" || i+1 == len(words) {
+				return nil, fmt.Errorf("invalid package list '%s'", s)
+			}
+			reqs[len(reqs)-1].op = words[i]
+			reqs[len(reqs)-1].version = words[i+1]
+			i++
+		} else if strings.ContainsAny(words[i], "=!<>") {
+			return nil, fmt.Errorf("invalid package list '%s'", s)
+		} else {
+			reqs = append(reqs, requirement{name: words[i]})
+		}
+	}
+	return reqs, nil
+}
+
+// pcFile holds the keywords of a `.pc` file, with variables expanded
+type pcFile struct {
+	path     string
+	keywords map[string]string
+}
+
+func expandVariables(s string, vars map[string]string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+		} else if s[i+1] == '$' {
+			sb.WriteByte('$')
+			i++
+		} else if s[i+1] == '{' {
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable reference in '%s'", s)
+			}
+			name := s[i+2 : i+end]
+			value, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable '%s'", name)
+			}
+			sb.WriteString(value)
+			i += end
+		} else {
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String(), nil
+}
+
+func isVariableName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func parsePcFile(path, sysroot string) (*pcFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pc := &pcFile{path: path, keywords: map[string]string{}}
+	vars := map[string]string{
+		"pcfiledir":     filepath.Dir(path),
+		"pc_sysrootdir": sysroot,
+	}
+	if vars["pc_sysrootdir"] == "" {
+		vars["pc_sysrootdir"] = "/"
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	line := ""
+	for scanner.Scan() {
+		lineNum++
+		text := scanner.Text()
+
+		// Join continuation lines
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\")
+			continue
+		}
+		line += text
+
+		if idx := strings.IndexByte(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// A line is a variable assignment if '=' comes before any ':',
+		// otherwise it is a keyword.
+		eq := strings.IndexByte(line, '=')
+		colon := strings.IndexByte(line, ':')
+		if eq != -1 && (colon == -1 || eq < colon) && isVariableName(strings.TrimSpace(line[:eq])) {
+			value, err := expandVariables(strings.TrimSpace(line[eq+1:]), vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, lineNum, err)
+			}
+			vars[strings.TrimSpace(line[:eq])] = value
+		} else if colon != -1 {
+			value, err := expandVariables(strings.TrimSpace(line[colon+1:]), vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, lineNum, err)
+			}
+			pc.keywords[strings.TrimSpace(line[:colon])] = value
+		} else {
+			return nil, fmt.Errorf("%s:%d: invalid line '%s'", path, lineNum, line)
+		}
+		line = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := pc.keywords["Version"]; !ok {
+		return nil, fmt.Errorf("%s: missing Version field", path)
+	}
+
+	return pc, nil
+}
+
+// splitFlags splits a flag string into separate arguments, following
+// shell quoting rules.
+func splitFlags(s string) ([]string, error) {
+	args := []string{}
+	var sb strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				sb.WriteRune(c)
+			}
+		case c == '\\':
+			if i+1 < len(runes) {
+				i++
+				sb.WriteRune(runes[i])
+			}
+			inArg = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				sb.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			sb.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in '%s'", s)
+	}
+	if inArg {
+		args = append(args, sb.String())
+	}
+	return args, nil
+}
+
+// Add the sysroot to absolute include and library paths
+func (r *Resolver) applySysroot(flags []string) []string {
+	if r.Sysroot == "" {
+		return flags
+	}
+	out := []string{}
+	for _, flag := range flags {
+		for _, prefix := range []string{"-I", "-L"} {
+			if strings.HasPrefix(flag, prefix) && filepath.IsAbs(flag[len(prefix):]) &&
+				!strings.HasPrefix(flag[len(prefix):], r.Sysroot) {
+				flag = prefix + filepath.Join(r.Sysroot, flag[len(prefix):])
+			}
+		}
+		out = append(out, flag)
+	}
+	return out
+}
+
+// find returns the path of a package's `.pc` file, along with the
+// existing directories searched before it
+func (r *Resolver) find(name string) (string, []string, error) {
+	searched := []string{}
+	for _, dir := range r.Path {
+		path := filepath.Join(dir, name+".pc")
+		if _, err := os.Stat(path); err == nil {
+			return path, searched, nil
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			searched = append(searched, dir)
+		}
+	}
+	return "", nil, fmt.Errorf("package '%s' not found in %s", name,
+		strings.Join(r.Path, string(filepath.ListSeparator)))
+}
+
+// Resolution state, shared between the packages being resolved
+type resolution struct {
+	cflags []string
+	libs   []string
+	files  []string
+	dirs   []string
+	// Packages already visited, and whether their libraries were used
+	seen map[string]bool
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// resolve reads a package and its requirements. Requires.private only
+// contributes compiler flags, so the libraries are only added when
+// withLibs is set.
+func (r *Resolver) resolve(req requirement, withLibs bool, res *resolution) (*pcFile, error) {
+	if visitedWithLibs, ok := res.seen[req.name]; ok && (visitedWithLibs || !withLibs) {
+		return nil, nil
+	}
+	res.seen[req.name] = withLibs
+
+	path, searched, err := r.find(req.name)
+	if err != nil {
+		return nil, err
+	}
+	res.dirs = appendUnique(res.dirs, searched...)
+	pc, err := parsePcFile(path, r.Sysroot)
+	if err != nil {
+		return nil, err
+	}
+	res.files = appendUnique(res.files, path)
+
+	if !req.satisfiedBy(pc.keywords["Version"]) {
+		return nil, fmt.Errorf("package '%s' has version %s, but '%s' is required",
+			req.name, pc.keywords["Version"], req)
+	}
+
+	cflags, err := splitFlags(pc.keywords["Cflags"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	res.cflags = appendUnique(res.cflags, cflags...)
+
+	if withLibs {
+		libs, err := splitFlags(pc.keywords["Libs"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		res.libs = append(res.libs, libs...)
+	}
+
+	requires, err := parseRequirements(pc.keywords["Requires"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, dep := range requires {
+		if _, err := r.resolve(dep, withLibs, res); err != nil {
+			return nil, err
+		}
+	}
+
+	privateRequires, err := parseRequirements(pc.keywords["Requires.private"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, dep := range privateRequires {
+		if _, err := r.resolve(dep, false, res); err != nil {
+			return nil, err
+		}
+	}
+
+	return pc, nil
+}
+
+// Resolve finds the flags needed to use a package. The package may be
+// followed by a version constraint, e.g. "zlib >= 1.2".
+func (r *Resolver) Resolve(constraint string) (*Result, error) {
+	reqs, err := parseRequirements(constraint)
+	if err != nil {
+		return nil, err
+	}
+	if len(reqs) != 1 {
+		return nil, fmt.Errorf("expected a single package, not '%s'", constraint)
+	}
+
+	res := &resolution{seen: map[string]bool{}}
+	pc, err := r.resolve(reqs[0], true, res)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Name:    reqs[0].name,
+		Version: pc.keywords["Version"],
+		Cflags:  r.applySysroot(res.cflags),
+		Ldflags: []string{},
+		Ldlibs:  []string{},
+		Files:   res.files,
+		Dirs:    res.dirs,
+	}
+
+	// Libraries keep their last occurrence, so that they still come
+	// after the libraries which use them. Other flags keep their first.
+	for i, flag := range res.libs {
+		if strings.HasPrefix(flag, "-l") {
+			last := true
+			for _, later := range res.libs[i+1:] {
+				if later == flag {
+					last = false
+					break
+				}
+			}
+			if last {
+				result.Ldlibs = append(result.Ldlibs, flag)
+			}
+		} else {
+			result.Ldflags = appendUnique(result.Ldflags, flag)
+		}
+	}
+	result.Ldflags = r.applySysroot(result.Ldflags)
+
+	return result, nil
+}
+
+// CompareVersions compares two version strings in the same way as
+// pkg-config, returning -1, 0 or 1. Versions are split into numeric
+// and alphabetic segments, where numeric segments compare numerically
+// and are newer than alphabetic ones.
+func CompareVersions(a, b string) int {
+	segments := func(v string) []string {
+		segs := []string{}
+		start := -1
+		for i, c := range v + "." {
+			isAlnum := unicode.IsLetter(c) || unicode.IsDigit(c)
+			if start != -1 && (!isAlnum || unicode.IsDigit(c) != unicode.IsDigit(rune(v[start]))) {
+				segs = append(segs, v[start:i])
+				start = -1
+			}
+			if isAlnum && start == -1 {
+				start = i
+			}
+		}
+		return segs
+	}
+
+	segsA, segsB := segments(a), segments(b)
+	for i := 0; i < len(segsA) && i < len(segsB); i++ {
+		sa, sb := segsA[i], segsB[i]
+		numA, numB := unicode.IsDigit(rune(sa[0])), unicode.IsDigit(rune(sb[0]))
+		if numA != numB {
+			if numA {
+				return 1
+			}
+			return -1
+		}
+		if numA {
+			sa, sb = strings.TrimLeft(sa, "0"), strings.TrimLeft(sb, "0")
+			if len(sa) != len(sb) {
+				if len(sa) > len(sb) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if cmp := strings.Compare(sa, sb); cmp != 0 {
+			return cmp
+		}
+	}
+
+	if len(segsA) > len(segsB) {
+		return 1
+	} else if len(segsA) < len(segsB) {
+		return -1
+	}
+	return 0
+}
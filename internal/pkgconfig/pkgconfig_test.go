@@ -0,0 +1,133 @@
+/*
+ * Copyright 2020 Arm Limited.
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkgconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CompareVersions(t *testing.T) {
+	assert.Equal(t, 0, CompareVersions("1.2.3", "1.2.3"))
+	assert.Equal(t, 0, CompareVersions("1.02", "1.2"))
+	assert.Equal(t, 1, CompareVersions("1.10", "1.9"))
+	assert.Equal(t, -1, CompareVersions("1.2", "1.2.1"))
+	assert.Equal(t, 1, CompareVersions("1.2a", "1.2"))
+	assert.Equal(t, 1, CompareVersions("2.0", "2.a"))
+	assert.Equal(t, -1, CompareVersions("1.2alpha", "1.2beta"))
+}
+
+func Test_parseRequirements(t *testing.T) {
+	reqs, err := parseRequirements("foo >= 1.2, bar,baz<3 qux")
+	assert.NoError(t, err)
+	assert.Equal(t, []requirement{
+		{name: "foo", op: ">=", version: "1.2"},
+		{name: "bar"},
+		{name: "baz", op: "<", version: "3"},
+		{name: "qux"},
+	}, reqs)
+
+	_, err = parseRequirements(">= 1.2")
+	assert.Error(t, err)
+	_, err = parseRequirements("foo >=")
+	assert.Error(t, err)
+}
+
+func Test_splitFlags(t *testing.T) {
+	flags, err := splitFlags(`-I/a\ b -DX="y z" '-DQ=$x'  -lfoo`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-I/a b", "-DX=y z", "-DQ=$x", "-lfoo"}, flags)
+
+	_, err = splitFlags(`-DX="y`)
+	assert.Error(t, err)
+}
+
+func Test_Resolve(t *testing.T) {
+	r := NewResolver("testdata", "testdata/empty", "")
+
+	res, err := r.Resolve("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", res.Version)
+	assert.Equal(t, []string{
+		"-I/usr/include/foo", "-DFOO=a b", "-I/opt/bar/include", "-Itestdata/baz",
+	}, res.Cflags)
+	assert.Equal(t, []string{"-L/usr/lib", "-L/opt/bar/lib"}, res.Ldflags)
+	// -lm is kept after -lbar, and -lbaz is private
+	assert.Equal(t, []string{"-lfoo", "-lbar", "-lm"}, res.Ldlibs)
+	assert.Equal(t, []string{
+		filepath.Join("testdata", "foo.pc"),
+		filepath.Join("testdata", "bar.pc"),
+		filepath.Join("testdata", "baz.pc"),
+	}, res.Files)
+}
+
+func Test_ResolveConstraint(t *testing.T) {
+	r := NewResolver("testdata", "testdata/empty", "")
+
+	_, err := r.Resolve("foo >= 1.2")
+	assert.NoError(t, err)
+	_, err = r.Resolve("foo < 1.2")
+	assert.Error(t, err)
+	_, err = r.Resolve("foo bar")
+	assert.Error(t, err)
+
+	// Earlier directories take precedence, so foo's requirement on
+	// bar >= 2.0 fails
+	r = NewResolver(filepath.Join("testdata", "sub")+string(filepath.ListSeparator)+"testdata",
+		"testdata/empty", "")
+	_, err = r.Resolve("foo")
+	assert.Error(t, err)
+}
+
+func Test_ResolveDirs(t *testing.T) {
+	sub := filepath.Join("testdata", "sub")
+	r := NewResolver("testdata"+string(filepath.ListSeparator)+sub, "testdata/empty", "")
+
+	// Nothing is searched before testdata, where every package is found
+	res, err := r.Resolve("bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string(nil), res.Dirs)
+
+	// testdata/sub is searched before finding baz in testdata, and the
+	// missing testdata/empty is never recorded
+	r = NewResolver(sub+string(filepath.ListSeparator)+"testdata", "testdata/empty", "")
+	res, err = r.Resolve("baz")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sub}, res.Dirs)
+}
+
+func Test_ResolveMissing(t *testing.T) {
+	r := NewResolver("testdata", "testdata/empty", "")
+
+	_, err := r.Resolve("missing")
+	assert.Error(t, err)
+	_, err = r.Resolve("badreq")
+	assert.Error(t, err)
+}
+
+func Test_ResolveSysroot(t *testing.T) {
+	r := NewResolver("testdata", "testdata/empty", "/sysroot")
+
+	res, err := r.Resolve("bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-I/sysroot/opt/bar/include"}, res.Cflags)
+	assert.Equal(t, []string{"-L/sysroot/opt/bar/lib"}, res.Ldflags)
+	assert.Equal(t, []string{"-lbar", "-lm"}, res.Ldlibs)
+}
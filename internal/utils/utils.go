@@ -28,7 +28,7 @@ import (
 
 var (
 	headerRegexp        = regexp.MustCompile(`\.(h|hpp|inc)$`)
-	compileSourceRegexp = regexp.MustCompile(`\.(c|s|cpp|cc|S)$`)
+	compileSourceRegexp = regexp.MustCompile(`\.(c|s|S|cc|cpp|cxx|c\+\+|C)$`)
 )
 
 // Does the input string look like it is a header file?
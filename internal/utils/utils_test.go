@@ -38,6 +38,10 @@ func Test_IsNotHeader(t *testing.T) {
 func Test_IsCompilableSource(t *testing.T) {
 	assert.True(t, IsCompilableSource("bla.c"), "bla.c")
 	assert.False(t, IsCompilableSource("bla.bbq"), "bla.bbq")
+	assert.True(t, IsCompilableSource("bla.cxx"), "bla.cxx")
+	assert.True(t, IsCompilableSource("bla.c++"), "bla.c++")
+	assert.True(t, IsCompilableSource("bla.C"), "bla.C")
+	assert.False(t, IsCompilableSource("bla.asm"), "bla.asm")
 }
 
 func Test_IsNotCompilableSource(t *testing.T) {
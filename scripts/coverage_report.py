@@ -0,0 +1,138 @@
+#!/usr/bin/env python
+
+# Copyright 2020 Arm Limited.
+# SPDX-License-Identifier: Apache-2.0
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Merge the coverage data written by instrumented programs into LCOV and
+HTML reports."""
+
+import argparse
+import errno
+import glob
+import os
+import subprocess
+import sys
+
+
+def make_dir(d):
+    try:
+        os.makedirs(d)
+    except OSError as e:
+        # Ignore errors if the dir already exists. Any other error number is
+        # unexpected, so re-raise.
+        if e.errno != errno.EEXIST:
+            raise
+
+
+def run(cmd, stdout=None):
+    try:
+        subprocess.check_call(cmd, stdout=stdout)
+    except subprocess.CalledProcessError as e:
+        sys.stderr.write("Error: Command %s failed with exit code %d" %
+                         (str(cmd), e.returncode))
+        sys.exit(e.returncode)
+    except OSError as e:
+        sys.stderr.write("Error: Couldn't execute command '%s': %s" % (' '.join(cmd), e.strerror))
+        sys.exit(1)
+
+
+def gcov_report(args, info, html_dir):
+    cmd = [args.lcov_tool, "--capture", "--gcov-tool", args.gcov_tool,
+           "--output-file", info]
+    for d in args.object_dir:
+        cmd.extend(["--directory", d])
+    run(cmd)
+
+    run([args.genhtml_tool, info, "--output-directory", html_dir])
+
+
+def llvm_report(args, info, html_dir):
+    profiles = glob.glob(os.path.join(args.profile_dir, "*.profraw"))
+    if not profiles:
+        sys.stderr.write("Error: No profiles found in %s. "
+                         "Run the instrumented programs first.\n" % args.profile_dir)
+        sys.exit(1)
+    if not args.inputs:
+        sys.stderr.write("Error: No instrumented binaries or shared libraries\n")
+        sys.exit(1)
+
+    profdata = os.path.join(args.output_dir, "coverage.profdata")
+    run([args.llvm_profdata_tool, "merge", "-sparse", "-o", profdata] + profiles)
+
+    # llvm-cov takes the first binary as a positional argument, and any
+    # others with -object
+    objects = args.inputs[:1]
+    for obj in args.inputs[1:]:
+        objects.extend(["-object", obj])
+
+    with open(info, "w") as fp:
+        run([args.llvm_cov_tool, "export", "-format=lcov",
+             "-instr-profile=" + profdata] + objects, stdout=fp)
+
+    run([args.llvm_cov_tool, "show", "-format=html",
+         "-instr-profile=" + profdata, "-output-dir=" + html_dir] + objects)
+
+
+def parse_args():
+    parser = argparse.ArgumentParser(description=__doc__)
+
+    parser.add_argument("inputs", nargs="*",
+                        help="Instrumented binaries and shared libraries")
+    parser.add_argument("--output-dir", required=True,
+                        help="Directory to write the reports to")
+    parser.add_argument("--format", action="store",
+                        choices=["gcov", "llvm"], default="gcov",
+                        help="Format of the coverage data")
+    parser.add_argument("--object-dir", action="append", default=[],
+                        help="Directory containing instrumented objects. "
+                             "GCC writes the coverage data next to them")
+    parser.add_argument("--profile-dir", default=None,
+                        help="Directory containing the profiles written by programs "
+                             "compiled with Clang")
+    parser.add_argument("--gcov-tool", default="gcov",
+                        help="Tool used to read GCC coverage data, including path if needed")
+    parser.add_argument("--lcov-tool", default="lcov",
+                        help="Tool used to collect GCC coverage data, including path if needed")
+    parser.add_argument("--genhtml-tool", default="genhtml",
+                        help="Tool used to create HTML reports from GCC coverage data, "
+                             "including path if needed")
+    parser.add_argument("--llvm-profdata-tool", default="llvm-profdata",
+                        help="Tool used to merge Clang profiles, including path if needed")
+    parser.add_argument("--llvm-cov-tool", default="llvm-cov",
+                        help="Tool used to create reports from Clang profiles, "
+                             "including path if needed")
+
+    args = parser.parse_args()
+
+    return args
+
+
+def main():
+    args = parse_args()
+
+    make_dir(args.output_dir)
+    info = os.path.join(args.output_dir, "coverage.info")
+    html_dir = os.path.join(args.output_dir, "html")
+
+    if args.format == "llvm":
+        llvm_report(args, info, html_dir)
+    else:
+        gcov_report(args, info, html_dir)
+
+    print("Coverage report written to %s" % os.path.join(html_dir, "index.html"))
+
+
+if __name__ == "__main__":
+    main()
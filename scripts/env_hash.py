@@ -83,7 +83,12 @@ def hash_env():
         "ARMCOMPILER6_CLANGOPT",
         "ARMCOMPILER6_FROMELFOPT",
         "ARMCOMPILER6_LINKOPT",
-        "ARMROOT"
+        "ARMROOT",
+
+        # pkg-config
+        "PKG_CONFIG_LIBDIR",
+        "PKG_CONFIG_PATH",
+        "PKG_CONFIG_SYSROOT_DIR"
     ]
 
     m = hashlib.sha256()
@@ -101,6 +101,9 @@ tests/bootstrap_linux -o ${build_dir}
 ${build_dir}/config ${OPTIONS} && ${build_dir}/buildme bob_tests
 check_build_output "${build_dir}"
 
+# Run the unit tests declared with bob_test
+${build_dir}/buildme check
+
 # Build in a directory referred to via a symlink
 build_dir=build-link
 mkdir -p build-link-target/builds/build
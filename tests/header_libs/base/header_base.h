@@ -0,0 +1,5 @@
+#ifndef HEADER_BASE
+#error "HEADER_BASE should be exported by libheader_base"
+#endif
+
+#define HEADER_BASE_VALUE 1
@@ -0,0 +1,13 @@
+#include <header_base.h>
+
+#ifdef USE_REEXPORT
+#include <header_reexport.h>
+#endif
+
+int main(void) {
+#ifdef USE_STATIC
+    return header_user() - HEADER_REEXPORT_VALUE;
+#else
+    return HEADER_BASE_VALUE - 1;
+#endif
+}
@@ -0,0 +1,5 @@
+#include <header_base.h>
+
+#define HEADER_REEXPORT_VALUE (HEADER_BASE_VALUE + 1)
+
+int header_user(void);
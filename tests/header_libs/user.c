@@ -0,0 +1,5 @@
+#include <header_reexport.h>
+
+int header_user(void) {
+    return HEADER_REEXPORT_VALUE;
+}
@@ -0,0 +1,17 @@
+#include <stdio.h>
+#include <string.h>
+
+int main(int argc, char *argv[])
+{
+    if (argc != 3) {
+        fprintf(stderr, "Expected 2 arguments, got %d\n", argc - 1);
+        return 1;
+    }
+
+    if (strcmp(argv[1], "hello") != 0 || strcmp(argv[2], "world") != 0) {
+        fprintf(stderr, "Unexpected arguments: %s %s\n", argv[1], argv[2]);
+        return 1;
+    }
+
+    return 0;
+}